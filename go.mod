@@ -3,6 +3,6 @@ module github.com/ayang64/ztool
 go 1.17
 
 require (
-	github.com/bkaradzic/go-lz4 v1.0.0
+	github.com/klauspost/compress v1.15.15
 	github.com/pierrec/lz4 v2.0.5+incompatible
 )
@@ -0,0 +1,177 @@
+/*
+ * map prints the rows TestDraidMapOpenZFS in zfs/draid_test.go expects.  The
+ * functions are transcribed from vdev_draid.c in OpenZFS 2.1 with the kernel
+ * plumbing removed; vdev_draid_map_alloc_row() prints its columns rather than
+ * building a raidz_row_t.  Geometries are named as zpool create names them.
+ *
+ *	cc -o map map.c && ./map
+ */
+#include <stdio.h>
+#include <stdint.h>
+#include <stdlib.h>
+#include <string.h>
+
+#define VDEV_DRAID_SEED 0xd7a1d5eedULL
+#define VDEV_DRAID_ROWHEIGHT (1ULL << 24)
+
+typedef struct { uint64_t dm_children, dm_nperms, dm_seed, dm_checksum; } draid_map_t;
+static const draid_map_t maps[] = {
+	{6, 256, 0x88c3c62d8585b362ULL, 0x00000003d3b0c2c4ULL},
+	{11, 256, 0x74ccebf1dcf3ae80ULL, 0x0000000dd691358cULL},
+	{14, 256, 0x559b8c44065f8967ULL, 0x00000016ab2ff079ULL},
+};
+
+typedef struct {
+	uint64_t vdc_ndata, vdc_nparity, vdc_nspares, vdc_children, vdc_ngroups;
+	uint8_t *vdc_perms; uint64_t vdc_nperms;
+	uint64_t vdc_groupwidth, vdc_ndisks, vdc_groupsz, vdc_devslicesz;
+} vdev_draid_config_t;
+
+static inline uint64_t rotl(const uint64_t x, int k) { return (x << k) | (x >> (64 - k)); }
+static uint64_t vdev_draid_rand(uint64_t *s) {
+	const uint64_t s0 = s[0]; uint64_t s1 = s[1];
+	const uint64_t result = rotl(s0 + s1, 17) + s0;
+	s1 ^= s0; s[0] = rotl(s0, 49) ^ s1 ^ (s1 << 21); s[1] = rotl(s1, 28);
+	return result;
+}
+
+static uint8_t *generate_perms(const draid_map_t *map) {
+	uint64_t children = map->dm_children, nperms = map->dm_nperms;
+	uint8_t *perms = malloc(children * nperms), *initial = malloc(children);
+	for (int i = 0; i < children; i++) initial[i] = i;
+	uint64_t seed[2] = { VDEV_DRAID_SEED, map->dm_seed };
+	uint8_t *cur, *prev = initial;
+	for (int i = 0; i < nperms; i++) {
+		cur = &perms[i * children]; memcpy(cur, prev, children);
+		for (int j = children - 1; j > 0; j--) {
+			uint64_t k = vdev_draid_rand(seed) % (j + 1);
+			uint8_t v = cur[j]; cur[j] = cur[k]; cur[k] = v;
+		}
+		prev = cur;
+	}
+	/* fletcher_4_native of the map */
+	uint64_t a = 0, b = 0, c = 0, d = 0;
+	for (uint64_t i = 0; i + 4 <= children * nperms; i += 4) {
+		uint32_t w; memcpy(&w, perms + i, 4); a += w; b += a; c += b; d += c;
+	}
+	if (a != map->dm_checksum) { fprintf(stderr, "bad checksum %d\n", (int)children); exit(1); }
+	return perms;
+}
+
+static void get_perm(vdev_draid_config_t *vdc, uint64_t pindex, uint8_t **base, uint64_t *iter) {
+	uint64_t ncols = vdc->vdc_children;
+	uint64_t poff = pindex % (vdc->vdc_nperms * ncols);
+	*base = vdc->vdc_perms + (poff / ncols) * ncols;
+	*iter = poff % ncols;
+}
+static uint64_t permute_id(vdev_draid_config_t *vdc, uint8_t *base, uint64_t iter, uint64_t index) {
+	return (base[index] + iter) % vdc->vdc_children;
+}
+
+static uint64_t asize(vdev_draid_config_t *vdc, uint64_t ashift, uint64_t psize) {
+	uint64_t rows = ((psize - 1) / (vdc->vdc_ndata << ashift)) + 1;
+	return (rows * vdc->vdc_groupwidth) << ashift;
+}
+static uint64_t asize_to_psize(vdev_draid_config_t *vdc, uint64_t ashift, uint64_t asize) {
+	return ((asize >> ashift) / vdc->vdc_groupwidth * vdc->vdc_ndata) << ashift;
+}
+static uint64_t offset_to_group(vdev_draid_config_t *vdc, uint64_t offset) { return offset / vdc->vdc_groupsz; }
+static uint64_t group_to_offset(vdev_draid_config_t *vdc, uint64_t group) { return group * vdc->vdc_groupsz; }
+
+static uint64_t logical_to_physical(vdev_draid_config_t *vdc, uint64_t ashift, uint64_t logical_offset,
+    uint64_t *perm, uint64_t *group_start) {
+	uint64_t b_offset = logical_offset >> ashift;
+	uint64_t rowheight_sectors = VDEV_DRAID_ROWHEIGHT >> ashift;
+	uint64_t groupwidth = vdc->vdc_groupwidth, ngroups = vdc->vdc_ngroups, ndisks = vdc->vdc_ndisks;
+	uint64_t group = logical_offset / vdc->vdc_groupsz;
+	uint64_t groupstart = (group * groupwidth) % ndisks;
+	*group_start = groupstart;
+	b_offset = b_offset % (rowheight_sectors * groupwidth);
+	*perm = group / ngroups;
+	uint64_t row = (*perm * ((groupwidth * ngroups) / ndisks)) + (((group % ngroups) * groupwidth) / ndisks);
+	return ((rowheight_sectors * row) + (b_offset / groupwidth)) << ashift;
+}
+
+/* prints one row; returns io_size mapped */
+static uint64_t map_alloc_row(vdev_draid_config_t *vdc, uint64_t ashift, uint64_t io_offset, uint64_t abd_size) {
+	uint64_t io_size = abd_size;
+	uint64_t io_asize = asize(vdc, ashift, io_size);
+	uint64_t group = offset_to_group(vdc, io_offset);
+	uint64_t start_offset = group_to_offset(vdc, group + 1);
+	if (io_offset + io_asize > start_offset)
+		io_size = asize_to_psize(vdc, ashift, start_offset - io_offset);
+
+	uint64_t groupstart, perm;
+	uint64_t physical_offset = logical_to_physical(vdc, ashift, io_offset, &perm, &groupstart);
+	uint64_t ndisks = vdc->vdc_ndisks, groupwidth = vdc->vdc_groupwidth, wrap = groupwidth;
+	if (groupstart + groupwidth > ndisks) wrap = ndisks - groupstart;
+	const uint64_t psize = io_size >> ashift;
+	uint64_t q = psize / vdc->vdc_ndata;
+	uint64_t r = psize - q * vdc->vdc_ndata;
+	uint64_t bc = (r == 0 ? 0 : r + vdc->vdc_nparity);
+
+	uint8_t *base; uint64_t iter;
+	get_perm(vdc, perm, &base, &iter);
+	printf("\t\t\t\t{%d, [][3]uint64{", (int)io_size);
+	for (uint64_t i = 0; i < groupwidth; i++) {
+		uint64_t c = (groupstart + i) % ndisks;
+		if (i == wrap) physical_offset += VDEV_DRAID_ROWHEIGHT;
+		uint64_t size;
+		if (q == 0 && i >= bc) size = 0;
+		else if (i < bc) size = (q + 1) << ashift;
+		else size = q << ashift;
+		if (i % 4 == 0)
+			printf("\n\t\t\t\t\t");
+		else
+			printf(" ");
+		printf("{%d, %#llx, %d},", (int)permute_id(vdc, base, iter, c),
+		    (unsigned long long)physical_offset, (int)size);
+	}
+	printf("\n\t\t\t\t}},\n");
+	return io_size;
+}
+
+static const char *geometry;
+
+static void map_alloc(vdev_draid_config_t *vdc, uint64_t ashift, const char *name, uint64_t offset, uint64_t size) {
+	printf("\t\t\"%s %s\": {\n\t\t\tVdev: \"%s\", Offset: %#llx, Size: %llu,\n\t\t\tRows: []draidRow{\n",
+	    geometry, name, geometry, (unsigned long long)offset, (unsigned long long)size);
+	/* vdev_draid_map_alloc: the second row starts at the next group */
+	uint64_t n = map_alloc_row(vdc, ashift, offset, size);
+	if (n < size)
+		map_alloc_row(vdc, ashift, group_to_offset(vdc, offset_to_group(vdc, offset) + 1), size - n);
+	printf("\t\t\t},\n\t\t},\n");
+}
+
+static vdev_draid_config_t config(int m, uint64_t ndata, uint64_t nparity, uint64_t nspares, uint64_t ngroups) {
+	vdev_draid_config_t v = {0};
+	v.vdc_children = maps[m].dm_children; v.vdc_nperms = maps[m].dm_nperms;
+	v.vdc_perms = generate_perms(&maps[m]);
+	v.vdc_ndata = ndata; v.vdc_nparity = nparity; v.vdc_nspares = nspares; v.vdc_ngroups = ngroups;
+	v.vdc_groupwidth = ndata + nparity; v.vdc_ndisks = v.vdc_children - nspares;
+	v.vdc_groupsz = v.vdc_groupwidth * VDEV_DRAID_ROWHEIGHT;
+	return v;
+}
+
+int main(void) {
+	uint64_t R = VDEV_DRAID_ROWHEIGHT;
+	vdev_draid_config_t a = config(1, 5, 2, 2, 9);
+	geometry = "draid2:5d:11c:2s";
+	map_alloc(&a, 9, "first group", 0, 512);
+	map_alloc(&a, 9, "partial stripe", 7 * 512, 3 * 512);
+	map_alloc(&a, 9, "wraps to the next row", 7 * R + 14 * 512, 12 * 512);
+	map_alloc(&a, 9, "spans two groups", 7 * R - 7 * 512, 8 * 512);
+	map_alloc(&a, 9, "second permutation", 9 * 7 * R + 3 * 7 * R + 21 * 512, 10 * 512);
+
+	vdev_draid_config_t b = config(0, 4, 1, 1, 1);
+	geometry = "draid1:4d:6c:1s";
+	map_alloc(&b, 9, "first group", 10 * 512, 9 * 512);
+	map_alloc(&b, 9, "later permutation", 1000 * 5 * R + 5 * 512, 4 * 512);
+	map_alloc(&b, 9, "spans two groups", 5 * R - 10 * 512, 12 * 512);
+
+	vdev_draid_config_t c = config(2, 8, 2, 2, 6);
+	geometry = "draid2:8d:14c:2s";
+	map_alloc(&c, 9, "wraps to the next row", 10 * R, 20 * 512);
+	map_alloc(&c, 9, "spans two groups", 6 * 10 * R - 20 * 512, 24 * 512);
+	return 0;
+}
@@ -0,0 +1,69 @@
+/*
+ * encode writes test-data/zpool.cache.zle, which TestZLEPoolConfig in
+ * zfs/zle_test.go checks: test-data/zpool.cache zero filled to the 16K block
+ * that holds a pool's config object and encoded by zfs_zle_compress_buf()
+ * from OpenZFS's zle.c with the n of 64 zio_compress_table gives zle.
+ *
+ *	cc -o encode encode.c && ./encode < ../zpool.cache > ../zpool.cache.zle
+ */
+#include <stdio.h>
+#include <stdlib.h>
+#include <string.h>
+
+typedef unsigned char uchar_t;
+
+#define	MIN(a, b)	((a) < (b) ? (a) : (b))
+
+/* zfs_zle_compress_buf() from module/zfs/zle.c. */
+static size_t
+zfs_zle_compress_buf(void *s_start, void *d_start, size_t s_len,
+    size_t d_len, int n)
+{
+	uchar_t *src = s_start;
+	uchar_t *dst = d_start;
+	uchar_t *s_end = src + s_len;
+	uchar_t *d_end = dst + d_len;
+
+	while (src < s_end && dst < d_end - 1) {
+		uchar_t *first = src;
+		uchar_t *len = dst++;
+		if (src[0] == 0) {
+			uchar_t *last = src + (256 - n);
+			while (src < MIN(last, s_end) && src[0] == 0)
+				src++;
+			*len = src - first - 1 + n;
+		} else {
+			uchar_t *last = src + n;
+			if (d_end - dst < n)
+				break;
+			while (src < MIN(last, s_end) - 1 && (src[0] | src[1]))
+				*dst++ = *src++;
+			if (src[0])
+				*dst++ = *src++;
+			*len = src - first - 1;
+		}
+	}
+	return (src == s_end ? dst - (uchar_t *)d_start : s_len);
+}
+
+int
+main(void)
+{
+	static uchar_t block[16 << 10], out[16 << 10];
+	size_t n;
+
+	n = fread(block, 1, sizeof (block), stdin);
+	if (ferror(stdin) || !feof(stdin)) {
+		fprintf(stderr, "zpool.cache does not fit in %zu bytes\n", sizeof (block));
+		return (1);
+	}
+
+	n = zfs_zle_compress_buf(block, out, sizeof (block), sizeof (out), 64);
+	if (n == sizeof (block)) {
+		fprintf(stderr, "block does not compress\n");
+		return (1);
+	}
+
+	fwrite(out, 1, n, stdout);
+	return (0);
+}
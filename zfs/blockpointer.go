@@ -5,11 +5,9 @@
 package zfs
 
 import (
-	"bytes"
-	"encoding/binary"
+	"errors"
 	"fmt"
 	"io"
-	"log"
 	"strings"
 )
 
@@ -48,27 +46,51 @@ func (bp BlockPointer) String() string {
 	fmt.Fprintf(&s, "  Props.Lsize() = %d\n", bp.Props.Lsize())
 	fmt.Fprintf(&s, "  Props.Psize() = %d\n", bp.Props.Psize())
 	fmt.Fprintf(&s, "  Props.Embedded() = %v\n", bp.Props.Embedded())
+	fmt.Fprintf(&s, "  Props.Dedup() = %v\n", bp.Props.Dedup())
+	fmt.Fprintf(&s, "  Props.Crypt() = %v\n", bp.Props.Crypt())
 	fmt.Fprintf(&s, "  Props.Compression() = %d (%s)\n", bp.Props.Compression(), bp.Props.CompressionString())
 	return s.String()
 }
 
-func (bp *BlockPointer) GetDnode(r io.ReadSeeker) (*DnodePhys, error) {
-	vdev := 0
-	log.Printf("offset = %d", bp.Vdevs[vdev].Block())
-	if _, err := r.Seek(int64(bp.Vdevs[vdev].Block()), io.SeekStart); err != nil {
-		log.Printf("err: %v", err)
-		return nil, err
+// IsHole reports whether bp is a hole: a block that was never written or was
+// freed and reads as zeros.  Holes have no DVA.  Block pointers that were
+// never born are treated as holes too.
+func (bp *BlockPointer) IsHole() bool {
+	if bp.Props.Embedded() {
+		return false
+	}
+	return bp.Vdevs[0].IsEmpty() || (bp.Birth == 0 && bp.BirthTransactionGroup == 0)
+}
+
+// HoleBirth returns the transaction group a hole was created in.  Pools with
+// the hole_birth feature record it so incremental sends can tell which holes
+// are new; it is zero on older pools and for block pointers that are not
+// holes.
+func (bp *BlockPointer) HoleBirth() uint64 {
+	if !bp.IsHole() {
+		return 0
 	}
+	return bp.Birth
+}
 
-	cmp := bp.Props.Compression()
+// GetDnode reads and decodes the dnode bp points to from r.  If r has a valid
+// label it is read as part of the pool the label describes; if it has none it
+// is taken to be the only disk of a pool.  Any other error assembling the pool
+// is returned.
+//
+// Deprecated: use Pool.GetDnode, which can read every device of the pool and
+// does not read the labels and uber blocks of r again on every call.
+func (bp *BlockPointer) GetDnode(r io.ReadSeeker) (*DnodePhys, error) {
+	p, err := NewPool(WithDevice("", r))
 
-	pbuf := make([]byte, bp.Props.Psize())
-	lbuf := make([]byte, bp.Props.Lsize())
+	var le *LabelError
+	if errors.As(err, &le) {
+		p, err = newDiskPool(r), nil
+	}
 
-	binary.Read(r, binary.LittleEndian, pbuf)
-	cmp.Decompress(lbuf, pbuf)
-	log.Printf("pbuf: %v", pbuf)
-	log.Printf("lbuf: %v", lbuf)
+	if err != nil {
+		return nil, err
+	}
 
-	return bp.Vdevs[vdev].ReadDnode(bytes.NewReader(lbuf))
+	return p.GetDnode(bp)
 }
@@ -0,0 +1,212 @@
+// Copyright 2018 Ayan George.
+// All rights reserved.  Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package zfs_test
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"testing"
+
+	"github.com/ayang64/ztool/zfs"
+	"github.com/ayang64/ztool/zfs/nvlist"
+)
+
+func TestBlockPointerByteOrder(t *testing.T) {
+	const (
+		off     = 0x400000 + 512 // 4M + 1 sector
+		uncompr = uint64(zfs.ChecksumFletcher4)<<40 | uint64(zfs.CompressionOff)<<32
+	)
+
+	tests := map[string]struct {
+		Order binary.ByteOrder
+		Props zfs.BlockPointerProps
+	}{
+		"little endian": {Order: binary.LittleEndian, Props: zfs.BlockPointerProps(1<<63 | uncompr)},
+		"big endian":    {Order: binary.BigEndian, Props: zfs.BlockPointerProps(uncompr)},
+	}
+
+	for name, test := range tests {
+		test := test
+		t.Run(name, func(t *testing.T) {
+			if test.Props.ByteOrder() != test.Order {
+				t.Fatalf("ByteOrder() = %v; expected %v", test.Props.ByteOrder(), test.Order)
+			}
+
+			expected := zfs.DnodePhys{
+				Type:          zfs.DMU_OT_OBJSET,
+				DataBlockSize: 0x20,
+				BonusLength:   0x140,
+				MaxBlockID:    0x0102030405060708,
+			}
+			expected.BlockPointer[0].Birth = 1234
+
+			b := &bytes.Buffer{}
+			binary.Write(b, test.Order, expected)
+
+			img := make([]byte, 8<<20)
+			copy(img[off:], b.Bytes())
+
+			bp := zfs.BlockPointer{Props: test.Props, Birth: 1}
+			bp.Vdevs[0].Offset = 1
+			bp.ChecksumList, _ = zfs.BlockChecksum(zfs.ChecksumFletcher4, b.Bytes(), test.Order)
+
+			dn, err := bp.GetDnode(bytes.NewReader(img))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if *dn != expected {
+				t.Fatalf("decoded %#v; expected %#v", *dn, expected)
+			}
+
+			// a damaged dnode is reported rather than decoded.
+			img[off+8] ^= 0xff
+			if dn, err := bp.GetDnode(bytes.NewReader(img)); err == nil {
+				t.Fatalf("decoded damaged dnode %#v", *dn)
+			}
+		})
+	}
+}
+
+func TestBlockPointerGetDnodeLabeled(t *testing.T) {
+	expected := zfs.DnodePhys{Type: zfs.DMU_OT_OBJSET, DataBlockSize: 0x20}
+
+	b := &bytes.Buffer{}
+	binary.Write(b, binary.LittleEndian, expected)
+
+	// device 20 is top level vdev 1 of the pool its label describes.
+	img := poolDevice(testPoolGUID, 20, 20, 40)
+	copy(img[4<<20+40*512:], b.Bytes())
+
+	bp := dataBlock(1, 40, b.Bytes())
+	dn, err := bp.GetDnode(bytes.NewReader(img))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if *dn != expected {
+		t.Fatalf("decoded %#v; expected %#v", *dn, expected)
+	}
+
+	// without its label the image is the pool's only vdev.
+	if dn, err := bp.GetDnode(bytes.NewReader(img[4<<20 : 6<<20])); err == nil {
+		t.Fatalf("decoded %#v from top level vdev 1 of an unlabeled image", *dn)
+	}
+
+	// a label whose configuration cannot be used is reported rather than
+	// the image being read as an unlabeled disk.
+	bad := labelImage(8<<20, xdrList(
+		"version", uint64(5000),
+		"name", "tank",
+		"txg", uint64(40),
+		"pool_guid", uint64(testPoolGUID),
+		"guid", uint64(20),
+		"top_guid", uint64(20),
+		"vdev_children", uint64(1),
+		"vdev_tree", nvlist.List{"type": "tape", "id": uint64(0), "guid": uint64(20)},
+	))
+	copy(bad[4<<20+40*512:], b.Bytes())
+
+	bp = dataBlock(0, 40, b.Bytes())
+	_, err = bp.GetDnode(bytes.NewReader(bad))
+
+	var le *zfs.LabelError
+	if err == nil || errors.As(err, &le) {
+		t.Fatalf("reading a device with an unusable configuration returned %v", err)
+	}
+
+	// a device too small to hold labels has none.
+	if _, err := zfs.NewPool(zfs.WithDevice("small", bytes.NewReader(img[:512<<10]))); !errors.As(err, &le) {
+		t.Fatalf("assembling a device too small for labels returned %v; expected a *zfs.LabelError", err)
+	}
+}
+
+func TestBlockPointerProps(t *testing.T) {
+	type decoded struct {
+		Level       int
+		Type        uint8
+		Checksum    uint8
+		Compression zfs.ZfsCompressionType
+		Lsize       int
+		Psize       int
+		Embedded    bool
+		Dedup       bool
+		Crypt       bool
+		Order       binary.ByteOrder
+	}
+
+	tests := map[string]struct {
+		Props    zfs.BlockPointerProps
+		Expected decoded
+	}{
+		// [L0 DMU objset] fletcher4 lz4 LE size=1000L/200P
+		"objset": {
+			Props:    0x800b070f00000007,
+			Expected: decoded{Type: 11, Checksum: 7, Compression: zfs.CompressionLZ4, Lsize: 0x1000, Psize: 0x200, Order: binary.LittleEndian},
+		},
+		// [L0 ZFS plain file] fletcher4 lz4 LE size=100000L/20000P
+		"1M record": {
+			Props:    0x8013070f00ff07ff,
+			Expected: decoded{Type: 19, Checksum: 7, Compression: zfs.CompressionLZ4, Lsize: 1 << 20, Psize: 0x20000, Order: binary.LittleEndian},
+		},
+		// [L0 ZFS plain file] fletcher4 uncompressed BE size=1000000L/1000000P
+		"16M record": {
+			Props:    0x001307027fff7fff,
+			Expected: decoded{Type: 19, Checksum: 7, Compression: zfs.CompressionOff, Lsize: 16 << 20, Psize: 16 << 20, Order: binary.BigEndian},
+		},
+		// [L2 DMU dnode] fletcher4 lz4 LE size=20000L/1800P
+		"indirect": {
+			Props:    0x820a070f000b00ff,
+			Expected: decoded{Level: 2, Type: 10, Checksum: 7, Compression: zfs.CompressionLZ4, Lsize: 0x20000, Psize: 0x1800, Order: binary.LittleEndian},
+		},
+		// [L0 ZFS plain file] sha256 gzip-9 LE dedup size=20000L/4200P
+		"dedup": {
+			Props:    0xc013080d002000ff,
+			Expected: decoded{Type: 19, Checksum: 8, Compression: zfs.CompressionGzip9, Lsize: 0x20000, Psize: 0x4200, Dedup: true, Order: binary.LittleEndian},
+		},
+		// [L1 ZFS plain file] sha512 lz4 LE encrypted size=20000L/1000P
+		"crypt": {
+			Props:    0xa1130b0f000700ff,
+			Expected: decoded{Level: 1, Type: 19, Checksum: 11, Compression: zfs.CompressionLZ4, Lsize: 0x20000, Psize: 0x1000, Crypt: true, Order: binary.LittleEndian},
+		},
+		// compression functions past lz4 use the bits above the original
+		// five.
+		"extended compression": {
+			Props:    0x8013077000000000,
+			Expected: decoded{Type: 19, Checksum: 7, Compression: 0x70, Lsize: 512, Psize: 512, Order: binary.LittleEndian},
+		},
+		// EMBEDDED [L0 ZFS plain file] et=0 LE lsize=2c00 psize=48
+		"embedded": {
+			Props:    0x8013008f8e002bff,
+			Expected: decoded{Type: 19, Compression: zfs.CompressionLZ4, Lsize: 0x2c00, Psize: 0x48, Embedded: true, Order: binary.LittleEndian},
+		},
+	}
+
+	for name, test := range tests {
+		test := test
+		t.Run(name, func(t *testing.T) {
+			bpp := test.Props
+			got := decoded{
+				Level:       bpp.Level(),
+				Type:        bpp.Type(),
+				Checksum:    bpp.Checksum(),
+				Compression: bpp.Compression(),
+				Lsize:       bpp.Lsize(),
+				Psize:       bpp.Psize(),
+				Embedded:    bpp.Embedded(),
+				Dedup:       bpp.Dedup(),
+				Crypt:       bpp.Crypt(),
+				Order:       bpp.ByteOrder(),
+			}
+			if test.Expected.Embedded {
+				// the checksum bits hold the embedded type.
+				got.Checksum = 0
+			}
+			if got != test.Expected {
+				t.Fatalf("decoded %#x as %+v; expected %+v", uint64(bpp), got, test.Expected)
+			}
+		})
+	}
+}
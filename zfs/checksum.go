@@ -0,0 +1,268 @@
+// Copyright 2018 Ayan George.
+// All rights reserved.  Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package zfs
+
+import (
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/binary"
+	"errors"
+	"fmt"
+)
+
+// typedef struct zio_eck {
+// 	uint64_t	zec_magic;	/* for validation, endianness	*/
+// 	zio_cksum_t	zec_cksum;	/* 256-bit checksum		*/
+// } zio_eck_t;
+
+// EmbeddedChecksum is the trailer ZFS stores at the end of blocks that carry
+// their own checksum such as the label nvlist and each uberblock slot.
+type EmbeddedChecksum struct {
+	Magic    uint64    // EmbeddedChecksumMagic in the byte order of the block.
+	Checksum [4]uint64 // 256-bit checksum
+}
+
+const (
+	// EmbeddedChecksumMagic identifies an embedded checksum and, by its byte
+	// order, the byte order of the block it protects.
+	EmbeddedChecksumMagic = 0x0210da7ab10c7a11
+
+	// EmbeddedChecksumSize is the size of an EmbeddedChecksum on disk.
+	EmbeddedChecksumSize = 40
+)
+
+// ChecksumError is returned when data does not match its checksum.
+type ChecksumError struct {
+	Offset   uint64    // device offset of the data
+	Expected [4]uint64 // checksum stored on disk
+	Actual   [4]uint64 // checksum of the data that was read
+}
+
+func (e *ChecksumError) Error() string {
+	return fmt.Sprintf("checksum mismatch at offset %#x; expected %016x, got %016x", e.Offset, e.Expected, e.Actual)
+}
+
+// ErrChecksumUnsupported is returned for blocks whose checksum type cannot be
+// computed.  Reads return such blocks unverified.
+var ErrChecksumUnsupported = errors.New("unsupported checksum type")
+
+// sha256Checksum returns the SHA-256 digest of b as the four 64-bit words ZFS
+// uses to store it.
+func sha256Checksum(b []byte) [4]uint64 {
+	sum := sha256.Sum256(b)
+	return [4]uint64{
+		binary.BigEndian.Uint64(sum[0:]),
+		binary.BigEndian.Uint64(sum[8:]),
+		binary.BigEndian.Uint64(sum[16:]),
+		binary.BigEndian.Uint64(sum[24:]),
+	}
+}
+
+// sha512Checksum returns the SHA-512/256 digest of b, which ZFS calls sha512,
+// as the four 64-bit words ZFS uses to store it.
+func sha512Checksum(b []byte) [4]uint64 {
+	sum := sha512.Sum512_256(b)
+	return [4]uint64{
+		binary.BigEndian.Uint64(sum[0:]),
+		binary.BigEndian.Uint64(sum[8:]),
+		binary.BigEndian.Uint64(sum[16:]),
+		binary.BigEndian.Uint64(sum[24:]),
+	}
+}
+
+// embeddedChecksumOrder returns the byte order of the embedded checksum at the
+// end of buf as determined by its magic number.
+func embeddedChecksumOrder(buf []byte) (binary.ByteOrder, error) {
+	if len(buf) < EmbeddedChecksumSize {
+		return nil, fmt.Errorf("%d byte buffer is too small to hold an embedded checksum", len(buf))
+	}
+
+	eck := buf[len(buf)-EmbeddedChecksumSize:]
+	for _, bo := range []binary.ByteOrder{binary.LittleEndian, binary.BigEndian} {
+		if bo.Uint64(eck) == EmbeddedChecksumMagic {
+			return bo, nil
+		}
+	}
+
+	return nil, fmt.Errorf("bad embedded checksum magic %#016x", binary.LittleEndian.Uint64(eck))
+}
+
+// embeddedChecksum computes the SHA-256 of buf with its embedded checksum
+// replaced by verifier as ZFS does for blocks that carry their own checksum.
+// The contents of buf are left untouched.
+func embeddedChecksum(buf []byte, verifier [4]uint64, bo binary.ByteOrder) [4]uint64 {
+	b := make([]byte, len(buf))
+	copy(b, buf)
+
+	cksum := b[len(b)-EmbeddedChecksumSize+8:]
+	for i, v := range verifier {
+		bo.PutUint64(cksum[i*8:], v)
+	}
+
+	return sha256Checksum(b)
+}
+
+// labelChecksum computes the checksum of buf as ZFS does for
+// ZIO_CHECKSUM_LABEL; the verifier holds the device offset of the buffer.
+func labelChecksum(buf []byte, offset uint64, bo binary.ByteOrder) [4]uint64 {
+	return embeddedChecksum(buf, [4]uint64{offset}, bo)
+}
+
+// VerifyLabelChecksum checks the embedded checksum at the end of buf.  offset
+// is the byte offset on the device that buf was read from.  The buffer may
+// have been written in either byte order.
+func VerifyLabelChecksum(buf []byte, offset uint64) error {
+	bo, err := embeddedChecksumOrder(buf)
+	if err != nil {
+		return err
+	}
+
+	cksum := buf[len(buf)-EmbeddedChecksumSize+8:]
+
+	e := ChecksumError{
+		Offset: offset,
+		Actual: labelChecksum(buf, offset, bo),
+	}
+
+	for i := range e.Expected {
+		e.Expected[i] = bo.Uint64(cksum[i*8:])
+	}
+
+	if e.Expected != e.Actual {
+		return &e
+	}
+
+	return nil
+}
+
+// WriteLabelChecksum stores an embedded checksum at the end of buf so that it
+// will pass VerifyLabelChecksum when read from offset.  This is mostly useful
+// for building device images.
+func WriteLabelChecksum(buf []byte, offset uint64, bo binary.ByteOrder) {
+	eck := buf[len(buf)-EmbeddedChecksumSize:]
+	bo.PutUint64(eck, EmbeddedChecksumMagic)
+
+	cksum := labelChecksum(buf, offset, bo)
+	for i := range cksum {
+		bo.PutUint64(eck[8+i*8:], cksum[i])
+	}
+}
+
+// ChecksumType identifies the checksum algorithm recorded in a block pointer.
+type ChecksumType uint8
+
+const (
+	ChecksumInherit    = ChecksumType(iota) // ZIO_CHECKSUM_INHERIT
+	ChecksumOn                              // ZIO_CHECKSUM_ON
+	ChecksumOff                             // ZIO_CHECKSUM_OFF
+	ChecksumLabel                           // ZIO_CHECKSUM_LABEL
+	ChecksumGangHeader                      // ZIO_CHECKSUM_GANG_HEADER
+	ChecksumZilog                           // ZIO_CHECKSUM_ZILOG
+	ChecksumFletcher2                       // ZIO_CHECKSUM_FLETCHER_2
+	ChecksumFletcher4                       // ZIO_CHECKSUM_FLETCHER_4
+	ChecksumSHA256                          // ZIO_CHECKSUM_SHA256
+	ChecksumZilog2                          // ZIO_CHECKSUM_ZILOG2
+	ChecksumNoParity                        // ZIO_CHECKSUM_NOPARITY
+	ChecksumSHA512                          // ZIO_CHECKSUM_SHA512
+	ChecksumSkein                           // ZIO_CHECKSUM_SKEIN
+	ChecksumEdonR                           // ZIO_CHECKSUM_EDONR
+	ChecksumBlake3                          // ZIO_CHECKSUM_BLAKE3
+)
+
+// dedup reports whether t is strong enough to identify blocks for dedup.
+func (t ChecksumType) dedup() bool {
+	switch t {
+	case ChecksumSHA256, ChecksumSHA512, ChecksumSkein, ChecksumBlake3:
+		return true
+	default:
+		return false
+	}
+}
+
+// fletcher2Checksum computes the fletcher-2 checksum of b, which is read as
+// pairs of 64-bit words in byte order bo.
+func fletcher2Checksum(b []byte, bo binary.ByteOrder) [4]uint64 {
+	var a0, a1, b0, b1 uint64
+	for i := 0; i+16 <= len(b); i += 16 {
+		a0 += bo.Uint64(b[i:])
+		a1 += bo.Uint64(b[i+8:])
+		b0 += a0
+		b1 += a1
+	}
+	return [4]uint64{a0, a1, b0, b1}
+}
+
+// fletcher4Checksum computes the fletcher-4 checksum of b, which is read as
+// 32-bit words in byte order bo.
+func fletcher4Checksum(b []byte, bo binary.ByteOrder) [4]uint64 {
+	var a, b1, c, d uint64
+	for i := 0; i+4 <= len(b); i += 4 {
+		a += uint64(bo.Uint32(b[i:]))
+		b1 += a
+		c += b1
+		d += c
+	}
+	return [4]uint64{a, b1, c, d}
+}
+
+// BlockChecksum computes the checksum of type t of data.  bo is the byte order
+// the data was written in; the fletcher checksums depend on it.
+func BlockChecksum(t ChecksumType, data []byte, bo binary.ByteOrder) ([4]uint64, error) {
+	switch t {
+	case ChecksumFletcher2:
+		return fletcher2Checksum(data, bo), nil
+	case ChecksumFletcher4:
+		return fletcher4Checksum(data, bo), nil
+	case ChecksumSHA256:
+		return sha256Checksum(data), nil
+	case ChecksumSHA512:
+		return sha512Checksum(data), nil
+	default:
+		return [4]uint64{}, fmt.Errorf("%w %d", ErrChecksumUnsupported, t)
+	}
+}
+
+// VerifyBlockChecksum checks data, the physical contents of the block bp
+// points to, against the checksum stored in bp.  Blocks without a checksum
+// always pass and blocks with a checksum that cannot be computed fail with an
+// error wrapping ErrChecksumUnsupported.  Only the first two words are checked
+// for encrypted and authenticated blocks other than object sets as the last two
+// hold the block's MAC.
+func VerifyBlockChecksum(bp *BlockPointer, data []byte) error {
+	t := ChecksumType(bp.Props.Checksum())
+	if t == ChecksumOff || t == ChecksumNoParity {
+		return nil
+	}
+
+	sum, err := BlockChecksum(t, data, bp.Props.ByteOrder())
+	if err != nil {
+		return err
+	}
+
+	// as zio_checksum_handle_crypt() does, checksums that are not strong
+	// enough for dedup fold their upper half into the lower half.
+	if bp.Props.Crypt() && bp.Props.Level() == 0 && DmuObjectType(bp.Props.Type()) != DMU_OT_OBJSET {
+		if !t.dedup() {
+			sum[0] ^= sum[2]
+			sum[1] ^= sum[3]
+		}
+		sum[2], sum[3] = bp.ChecksumList[2], bp.ChecksumList[3]
+	}
+
+	if sum != bp.ChecksumList {
+		return &ChecksumError{Expected: bp.ChecksumList, Actual: sum}
+	}
+
+	return nil
+}
+
+// verifyBlock is VerifyBlockChecksum for reads; blocks whose checksum cannot
+// be computed pass unverified.
+func verifyBlock(bp *BlockPointer, data []byte) error {
+	if err := VerifyBlockChecksum(bp, data); !errors.Is(err, ErrChecksumUnsupported) {
+		return err
+	}
+	return nil
+}
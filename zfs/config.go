@@ -0,0 +1,75 @@
+// Copyright 2018 Ayan George.
+// All rights reserved.  Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package zfs
+
+// PoolConfig is the configuration of a pool as stored in zpool.cache and, with
+// the addition of the fields that describe the device itself, in the nvlist
+// of each vdev label.
+type PoolConfig struct {
+	Name            string          `nvlist:"name"`
+	Version         uint64          `nvlist:"version"`
+	State           uint64          `nvlist:"state"`
+	TXG             uint64          `nvlist:"txg"`
+	GUID            uint64          `nvlist:"pool_guid"`
+	HostID          uint64          `nvlist:"hostid"`
+	HostName        string          `nvlist:"hostname"`
+	VdevChildren    uint64          `nvlist:"vdev_children"`
+	VdevTree        VdevConfig      `nvlist:"vdev_tree"`
+	FeaturesForRead map[string]bool `nvlist:"features_for_read"`
+	HoleArray       []uint64        `nvlist:"hole_array"`
+}
+
+// LabelConfig is the nvlist stored in a vdev label.  Its VdevTree describes
+// only the top level vdev the device belongs to and GUID and TopGUID identify
+// the device and that top level vdev.
+type LabelConfig struct {
+	PoolConfig
+	GUID    uint64 `nvlist:"guid"`
+	TopGUID uint64 `nvlist:"top_guid"`
+}
+
+// VdevConfig is a node of the vdev tree as recorded in a pool configuration.
+// Fields that do not apply to a given type of vdev are left at their zero
+// values.  NParity is nil when the configuration has no nparity.  Only the
+// root vdev has L2Cache and Spares.
+type VdevConfig struct {
+	Type          string       `nvlist:"type"`
+	ID            uint64       `nvlist:"id"`
+	GUID          uint64       `nvlist:"guid"`
+	Path          string       `nvlist:"path"`
+	PhysPath      string       `nvlist:"phys_path"`
+	DevID         string       `nvlist:"devid"`
+	WholeDisk     uint64       `nvlist:"whole_disk"`
+	AShift        uint64       `nvlist:"ashift"`
+	ASize         uint64       `nvlist:"asize"`
+	MetaslabArray uint64       `nvlist:"metaslab_array"`
+	MetaslabShift uint64       `nvlist:"metaslab_shift"`
+	IsLog         uint64       `nvlist:"is_log"`
+	NParity       *uint64      `nvlist:"nparity"`
+	NData         uint64       `nvlist:"draid_ndata"`
+	NSpares       uint64       `nvlist:"draid_nspares"`
+	NGroups       uint64       `nvlist:"draid_ngroups"`
+	NChildren     uint64       `nvlist:"draid_nchildren"`
+	TopGUID       uint64       `nvlist:"top_guid"`
+	SpareID       uint64       `nvlist:"spareid"`
+	CreateTXG     uint64       `nvlist:"create_txg"`
+	Children      []VdevConfig `nvlist:"children"`
+	L2Cache       []VdevConfig `nvlist:"l2cache"`
+	Spares        []VdevConfig `nvlist:"spares"`
+}
+
+// Leaves returns the leaf vdevs, the ones without children, at or below v in
+// depth first order.
+func (v *VdevConfig) Leaves() []*VdevConfig {
+	if len(v.Children) == 0 && v.Type != "root" {
+		return []*VdevConfig{v}
+	}
+
+	rc := []*VdevConfig{}
+	for i := range v.Children {
+		rc = append(rc, v.Children[i].Leaves()...)
+	}
+	return rc
+}
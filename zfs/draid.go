@@ -0,0 +1,214 @@
+// Copyright 2018 Ayan George.
+// All rights reserved.  Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package zfs
+
+import (
+	"encoding/binary"
+	"fmt"
+)
+
+const (
+	// draidSeed is combined with the seed of a permutation map to start the
+	// random number generator the map is built with.
+	draidSeed = 0xd7a1d5eed
+
+	// draidRowHeight is the amount of each child a redundancy group takes
+	// up in a row; enough for the largest block with one data column.
+	draidRowHeight = 1 << 24
+)
+
+// draidMap holds the parameters of the permutation map of a dRAID vdev with
+// a given number of children.
+type draidMap struct {
+	children uint64
+	nperms   uint64
+	seed     uint64
+	checksum uint64
+}
+
+// draidRand is the xoroshiro128++ generator used to build permutation maps.
+func draidRand(s *[2]uint64) uint64 {
+	rotl := func(x uint64, k uint) uint64 { return x<<k | x>>(64-k) }
+
+	s0, s1 := s[0], s[1]
+	rc := rotl(s0+s1, 17) + s0
+
+	s1 ^= s0
+	s[0] = rotl(s0, 49) ^ s1 ^ (s1 << 21)
+	s[1] = rotl(s1, 28)
+
+	return rc
+}
+
+// draidPermutations builds the permutation map for a dRAID vdev with the given
+// number of children.  Each of the rows is a shuffle of the one before it.
+func draidPermutations(children uint64) ([]byte, error) {
+	var m *draidMap
+	for i := range draidMaps {
+		if draidMaps[i].children == children {
+			m = &draidMaps[i]
+			break
+		}
+	}
+	if m == nil {
+		return nil, fmt.Errorf("no dRAID permutation map for %d children", children)
+	}
+
+	perms := make([]byte, m.nperms*children)
+	row := make([]byte, children)
+	for i := range row {
+		row[i] = byte(i)
+	}
+
+	seed := [2]uint64{draidSeed, m.seed}
+	for i := uint64(0); i < m.nperms; i++ {
+		cur := perms[i*children : (i+1)*children]
+		copy(cur, row)
+		for j := children - 1; j > 0; j-- {
+			k := draidRand(&seed) % (j + 1)
+			cur[j], cur[k] = cur[k], cur[j]
+		}
+		row = cur
+	}
+
+	if sum := fletcher4Checksum(perms, binary.LittleEndian); sum[0] != m.checksum {
+		return nil, fmt.Errorf("dRAID permutation map for %d children has checksum %#x; expected %#x", children, sum[0], m.checksum)
+	}
+
+	return perms, nil
+}
+
+// groupWidth returns the number of columns in each redundancy group.
+func (v *DraidVdev) groupWidth() uint64 {
+	return v.NData + v.NParity
+}
+
+// ndisks returns the number of children that hold redundancy groups rather
+// than distributed spare space.
+func (v *DraidVdev) ndisks() uint64 {
+	return uint64(len(v.children)) - v.NSpares
+}
+
+// permute returns the child that holds position index of permutation pindex.
+func (v *DraidVdev) permute(pindex, index uint64) int {
+	children := uint64(len(v.children))
+	nperms := uint64(len(v.perms)) / children
+	poff := pindex % (nperms * children)
+	base := v.perms[(poff/children)*children:]
+	return int((uint64(base[index]) + poff%children) % children)
+}
+
+// mapRow maps up to size bytes of a block starting at offset.  A block never
+// spans a group boundary within a row, so the size actually mapped is
+// returned along with the row.
+func (v *DraidVdev) mapRow(offset, size uint64) (*RaidzMap, uint64) {
+	ashift := v.AShift
+	width := v.groupWidth()
+	groupsz := width * draidRowHeight
+
+	// the part of the block that fits in this group.
+	group := offset / groupsz
+	asize := ((size-1)/(v.NData<<ashift) + 1) * width << ashift
+	if next := (group + 1) * groupsz; offset+asize > next {
+		size = (next - offset) / width * v.NData
+	}
+
+	// the row the group starts in and its first column.
+	ndisks := v.ndisks()
+	perm := group / v.NGroups
+	group %= v.NGroups
+	permRows := width * v.NGroups / ndisks
+	start := group * width % ndisks
+	row := perm*permRows + group*width/ndisks
+
+	b := (offset >> ashift) % (draidRowHeight >> ashift * width)
+	physical := (draidRowHeight>>ashift*row + b/width) << ashift
+
+	wrap := width
+	if start+width > ndisks {
+		wrap = ndisks - start
+	}
+
+	s := size >> ashift
+	q := s / v.NData
+	r := s - q*v.NData
+	bc := uint64(0)
+	if r != 0 {
+		bc = r + v.NParity
+	}
+
+	m := RaidzMap{NParity: int(v.NParity), Size: size}
+	for i := uint64(0); i < width; i++ {
+		if i == wrap {
+			physical += draidRowHeight
+		}
+
+		csize := q << ashift
+		switch {
+		case q == 0 && i >= bc:
+			csize = 0
+		case i < bc:
+			csize = (q + 1) << ashift
+		}
+
+		m.Columns = append(m.Columns, RaidzColumn{
+			Child:  v.permute(perm, (start+i)%ndisks),
+			Offset: physical,
+			Size:   csize,
+		})
+	}
+
+	return &m, size
+}
+
+// Map lays out the block of size bytes at offset across the children of v.
+// Blocks at the end of a redundancy group continue at the start of the next
+// so there are one or two rows.  Unlike RAID-Z every row has a column on
+// each child of the group even if it holds no data.
+func (v *DraidVdev) Map(offset, size uint64) []*RaidzMap {
+	size = (size + 1<<v.AShift - 1) >> v.AShift << v.AShift
+
+	rows := []*RaidzMap{}
+	for size > 0 {
+		m, n := v.mapRow(offset, size)
+		rows = append(rows, m)
+
+		offset += ((n-1)/(v.NData<<v.AShift) + 1) * v.groupWidth() << v.AShift
+		size -= n
+	}
+	return rows
+}
+
+// spareChild returns the child of v that holds the space of distributed spare
+// id at offset on the spare.
+func (v *DraidVdev) spareChild(id, offset uint64) int {
+	slice := v.groupWidth() * draidRowHeight * v.NGroups / v.ndisks()
+	return v.permute(offset/slice, uint64(len(v.children))-1-id)
+}
+
+// readDraid reads the block of size bytes at offset from the dRAID vdev v.
+func (p *Pool) readDraid(v *DraidVdev, offset uint64, size int, verify func([]byte) error, bad func(VdevTree, error)) ([]byte, error) {
+	return p.readRows(v, v.Map(offset, uint64(size)), v.Children(), size, verify, bad)
+}
+
+// readDistributedSpare reads from the child of the dRAID vdev that holds the
+// space of distributed spare v at offset.
+func (p *Pool) readDistributedSpare(v *DSpareVdev, offset uint64, size int, verify func([]byte) error, bad func(VdevTree, error)) ([]byte, error) {
+	var parent *DraidVdev
+	for _, t := range p.root.Children() {
+		if d, ok := t.(*DraidVdev); ok && d.GUID == v.TopGUID {
+			parent = d
+		}
+	}
+
+	if parent == nil || v.SpareID >= parent.NSpares {
+		err := fmt.Errorf("distributed spare %d of vdev %d does not exist", v.SpareID, v.TopGUID)
+		bad(v, err)
+		return nil, err
+	}
+
+	c := parent.Children()[parent.spareChild(v.SpareID, offset)]
+	return p.readVdev(c, offset, size, verify, bad)
+}
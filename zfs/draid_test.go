@@ -0,0 +1,384 @@
+// Copyright 2018 Ayan George.
+// All rights reserved.  Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package zfs_test
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"math/rand"
+	"testing"
+
+	"github.com/ayang64/ztool/zfs"
+	"github.com/ayang64/ztool/zfs/nvlist"
+)
+
+// draidVdev builds a dRAID vdev with the given geometry from disks.
+func draidVdev(t *testing.T, children, ndata, nparity, nspares, ngroups uint64) *zfs.DraidVdev {
+	cfg := zfs.VdevConfig{
+		Type: "draid", GUID: 10, AShift: 9,
+		NParity: &nparity, NData: ndata, NSpares: nspares, NGroups: ngroups,
+	}
+	for i := uint64(0); i < children; i++ {
+		cfg.Children = append(cfg.Children, zfs.VdevConfig{Type: "disk", GUID: 11 + i})
+	}
+
+	v, err := zfs.NewVdevTree(&cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return v.(*zfs.DraidVdev)
+}
+
+func TestDraidPermutations(t *testing.T) {
+	// building the vdev verifies the permutation map against its checksum.
+	for children := uint64(2); children <= 255; children++ {
+		draidVdev(t, children, 1, 1, 0, children)
+	}
+
+	cfg := zfs.VdevConfig{Type: "draid", NParity: uint64p(1), NData: 1, NGroups: 1}
+	if _, err := zfs.NewVdevTree(&cfg); err == nil {
+		t.Fatal("built a dRAID vdev without children")
+	}
+}
+
+func TestDraidMap(t *testing.T) {
+	const rowHeight = 1 << 24
+
+	// 11 children with 2 spares leave 9 disks for groups of 5 + 2 parity.
+	v := draidVdev(t, 11, 5, 2, 2, 9)
+
+	tests := map[string]struct {
+		Offset, Size uint64
+		Rows         []uint64 // data held by each row.
+	}{
+		"single sector": {Offset: 0, Size: 512, Rows: []uint64{512}},
+		"rounded up":    {Offset: 7 * 512, Size: 700, Rows: []uint64{1024}},
+		"full stripe":   {Offset: 0, Size: 5 * 512, Rows: []uint64{5 * 512}},
+		"two stripes":   {Offset: 7 * 512, Size: 6 * 512, Rows: []uint64{6 * 512}},
+		"spans groups": {
+			Offset: 7*rowHeight - 7*512, Size: 8 * 512,
+			Rows: []uint64{5 * 512, 3 * 512},
+		},
+	}
+
+	for name, test := range tests {
+		test := test
+		t.Run(name, func(t *testing.T) {
+			rows := v.Map(test.Offset, test.Size)
+			if len(rows) != len(test.Rows) {
+				t.Fatalf("mapped %d rows; expected %d", len(rows), len(test.Rows))
+			}
+
+			for r, m := range rows {
+				if m.Size != test.Rows[r] {
+					t.Errorf("row %d holds %d bytes; expected %d", r, m.Size, test.Rows[r])
+				}
+				if m.NParity != 2 || len(m.Columns) != 7 {
+					t.Errorf("row %d has %d columns and %d parity", r, len(m.Columns), m.NParity)
+				}
+
+				children := map[int]bool{}
+				sum := uint64(0)
+				for _, c := range m.Columns {
+					if children[c.Child] || c.Child < 0 || c.Child >= 11 {
+						t.Errorf("row %d uses child %d more than once", r, c.Child)
+					}
+					children[c.Child] = true
+					sum += c.Size
+				}
+
+				if sum != m.Size+2*m.Columns[0].Size {
+					t.Errorf("row %d columns hold %d bytes", r, sum)
+				}
+			}
+		})
+	}
+}
+
+// draidRow is a row of a dRAID map: the bytes of data it holds and the child,
+// offset and size of each column.
+type draidRow struct {
+	Size    uint64
+	Columns [][3]uint64
+}
+
+func TestDraidMapOpenZFS(t *testing.T) {
+	vdevs := map[string]*zfs.DraidVdev{
+		"draid2:5d:11c:2s": draidVdev(t, 11, 5, 2, 2, 9),
+		"draid1:4d:6c:1s":  draidVdev(t, 6, 4, 1, 1, 1),
+		"draid2:8d:14c:2s": draidVdev(t, 14, 8, 2, 2, 6),
+	}
+
+	// computed by test-data/draid/map.c, which follows OpenZFS.
+	tests := map[string]struct {
+		Vdev         string
+		Offset, Size uint64
+		Rows         []draidRow
+	}{
+		"draid2:5d:11c:2s first group": {
+			Vdev: "draid2:5d:11c:2s", Offset: 0, Size: 512,
+			Rows: []draidRow{
+				{512, [][3]uint64{
+					{7, 0, 512}, {8, 0, 512}, {10, 0, 512}, {6, 0, 0},
+					{0, 0, 0}, {2, 0, 0}, {4, 0, 0},
+				}},
+			},
+		},
+		"draid2:5d:11c:2s partial stripe": {
+			Vdev: "draid2:5d:11c:2s", Offset: 0xe00, Size: 1536,
+			Rows: []draidRow{
+				{1536, [][3]uint64{
+					{7, 0x200, 512}, {8, 0x200, 512}, {10, 0x200, 512}, {6, 0x200, 512},
+					{0, 0x200, 512}, {2, 0x200, 0}, {4, 0x200, 0},
+				}},
+			},
+		},
+		"draid2:5d:11c:2s wraps to the next row": {
+			Vdev: "draid2:5d:11c:2s", Offset: 0x7001c00, Size: 6144,
+			Rows: []draidRow{
+				{6144, [][3]uint64{
+					{3, 0x400, 1536}, {9, 0x400, 1536}, {7, 0x1000400, 1536}, {8, 0x1000400, 1536},
+					{10, 0x1000400, 1024}, {6, 0x1000400, 1024}, {0, 0x1000400, 1024},
+				}},
+			},
+		},
+		"draid2:5d:11c:2s spans two groups": {
+			Vdev: "draid2:5d:11c:2s", Offset: 0x6fff200, Size: 4096,
+			Rows: []draidRow{
+				{2560, [][3]uint64{
+					{7, 0xfffe00, 512}, {8, 0xfffe00, 512}, {10, 0xfffe00, 512}, {6, 0xfffe00, 512},
+					{0, 0xfffe00, 512}, {2, 0xfffe00, 512}, {4, 0xfffe00, 512},
+				}},
+				{1536, [][3]uint64{
+					{3, 0, 512}, {9, 0, 512}, {7, 0x1000000, 512}, {8, 0x1000000, 512},
+					{10, 0x1000000, 512}, {6, 0x1000000, 0}, {0, 0x1000000, 0},
+				}},
+			},
+		},
+		"draid2:5d:11c:2s second permutation": {
+			Vdev: "draid2:5d:11c:2s", Offset: 0x54002a00, Size: 5120,
+			Rows: []draidRow{
+				{5120, [][3]uint64{
+					{7, 0x9000600, 1024}, {1, 0x9000600, 1024}, {3, 0x9000600, 1024}, {5, 0x9000600, 1024},
+					{4, 0x9000600, 1024}, {10, 0x9000600, 1024}, {8, 0xa000600, 1024},
+				}},
+			},
+		},
+		"draid1:4d:6c:1s first group": {
+			Vdev: "draid1:4d:6c:1s", Offset: 0x1400, Size: 4608,
+			Rows: []draidRow{
+				{4608, [][3]uint64{
+					{0, 0x400, 1536}, {4, 0x400, 1536}, {3, 0x400, 1024}, {1, 0x400, 1024},
+					{5, 0x400, 1024},
+				}},
+			},
+		},
+		"draid1:4d:6c:1s later permutation": {
+			Vdev: "draid1:4d:6c:1s", Offset: 0x1388000a00, Size: 2048,
+			Rows: []draidRow{
+				{2048, [][3]uint64{
+					{5, 0x3e8000200, 512}, {3, 0x3e8000200, 512}, {1, 0x3e8000200, 512}, {2, 0x3e8000200, 512},
+					{0, 0x3e8000200, 512},
+				}},
+			},
+		},
+		"draid1:4d:6c:1s spans two groups": {
+			Vdev: "draid1:4d:6c:1s", Offset: 0x4ffec00, Size: 6144,
+			Rows: []draidRow{
+				{4096, [][3]uint64{
+					{0, 0xfffc00, 1024}, {4, 0xfffc00, 1024}, {3, 0xfffc00, 1024}, {1, 0xfffc00, 1024},
+					{5, 0xfffc00, 1024},
+				}},
+				{2048, [][3]uint64{
+					{1, 0x1000000, 512}, {5, 0x1000000, 512}, {4, 0x1000000, 512}, {2, 0x1000000, 512},
+					{0, 0x1000000, 512},
+				}},
+			},
+		},
+		"draid2:8d:14c:2s wraps to the next row": {
+			Vdev: "draid2:8d:14c:2s", Offset: 0xa000000, Size: 10240,
+			Rows: []draidRow{
+				{10240, [][3]uint64{
+					{4, 0, 1536}, {11, 0, 1536}, {1, 0x1000000, 1536}, {7, 0x1000000, 1536},
+					{3, 0x1000000, 1536}, {13, 0x1000000, 1536}, {9, 0x1000000, 1024}, {0, 0x1000000, 1024},
+					{12, 0x1000000, 1024}, {5, 0x1000000, 1024},
+				}},
+			},
+		},
+		"draid2:8d:14c:2s spans two groups": {
+			Vdev: "draid2:8d:14c:2s", Offset: 0x3bffd800, Size: 12288,
+			Rows: []draidRow{
+				{8192, [][3]uint64{
+					{3, 0x4fffc00, 1024}, {13, 0x4fffc00, 1024}, {9, 0x4fffc00, 1024}, {0, 0x4fffc00, 1024},
+					{12, 0x4fffc00, 1024}, {5, 0x4fffc00, 1024}, {6, 0x4fffc00, 1024}, {10, 0x4fffc00, 1024},
+					{4, 0x4fffc00, 1024}, {11, 0x4fffc00, 1024},
+				}},
+				{4096, [][3]uint64{
+					{2, 0x5000000, 512}, {8, 0x5000000, 512}, {4, 0x5000000, 512}, {0, 0x5000000, 512},
+					{10, 0x5000000, 512}, {1, 0x5000000, 512}, {13, 0x5000000, 512}, {6, 0x5000000, 512},
+					{7, 0x5000000, 512}, {11, 0x5000000, 512},
+				}},
+			},
+		},
+	}
+
+	for name, test := range tests {
+		test := test
+		t.Run(name, func(t *testing.T) {
+			rows := vdevs[test.Vdev].Map(test.Offset, test.Size)
+			if len(rows) != len(test.Rows) {
+				t.Fatalf("mapped %d rows; expected %d", len(rows), len(test.Rows))
+			}
+
+			for r, m := range rows {
+				cols := [][3]uint64{}
+				for _, c := range m.Columns {
+					cols = append(cols, [3]uint64{uint64(c.Child), c.Offset, c.Size})
+				}
+
+				if m.Size != test.Rows[r].Size || fmt.Sprint(cols) != fmt.Sprint(test.Rows[r].Columns) {
+					t.Errorf("row %d holds %d bytes in %v; expected %d in %v", r, m.Size, cols, test.Rows[r].Size, test.Rows[r].Columns)
+				}
+			}
+		})
+	}
+}
+
+func TestPoolReadDraid(t *testing.T) {
+	const size = 9 * 512
+
+	rnd := rand.New(rand.NewSource(3))
+	data := make([]byte, size)
+	rnd.Read(data)
+
+	// six children; one of them worth of distributed spare space and groups
+	// of four data and one parity column.
+	leaves := []uint64{11, 12, 13, 14, 15, 16}
+	geometry := draidVdev(t, 6, 4, 1, 1, 1)
+
+	bp := zfs.BlockPointer{Props: blockProps(size)}
+	bp.Vdevs[0] = zfs.NewDVA(0, 10<<9, false)
+	bp.ChecksumList, _ = zfs.BlockChecksum(zfs.ChecksumFletcher4, data, binary.LittleEndian)
+
+	rows := geometry.Map(bp.Vdevs[0].VdevOffset(), size)
+	if len(rows) != 1 {
+		t.Fatalf("block maps to %d rows", len(rows))
+	}
+	m := rows[0]
+
+	// the groups fill five children so the sixth holds the spare space.
+	spare := 15
+	for _, c := range m.Columns {
+		spare -= c.Child
+	}
+
+	tests := map[string]struct {
+		Absent  []int // columns whose device is left out of the pool.
+		Corrupt []int // columns whose contents are damaged.
+		Spare   int   // column rebuilt onto the distributed spare, if positive.
+		Fail    bool
+	}{
+		"intact":           {},
+		"lost parity":      {Absent: []int{0}},
+		"lost data":        {Absent: []int{2}},
+		"damaged data":     {Corrupt: []int{3}},
+		"too many lost":    {Absent: []int{1, 2}, Fail: true},
+		"distributed":      {Spare: 2},
+		"spare and damage": {Spare: 1, Corrupt: []int{4}},
+	}
+
+	for name, test := range tests {
+		test := test
+		t.Run(name, func(t *testing.T) {
+			children := []nvlist.List{}
+			for i, g := range leaves {
+				children = append(children, disk(g, fmt.Sprintf("/dev/da%d", i)))
+			}
+			if test.Spare > 0 {
+				c := m.Columns[test.Spare].Child
+				children[c] = nvlist.List{
+					"type": "spare", "guid": uint64(50), "children": []nvlist.List{
+						children[c],
+						{"type": "dspare", "guid": uint64(51), "path": "draid1-0-0", "top_guid": uint64(10), "spareid": uint64(0)},
+					},
+				}
+			}
+
+			top := nvlist.List{
+				"type": "draid", "id": uint64(0), "guid": uint64(10), "ashift": uint64(9),
+				"nparity": uint64(1), "draid_ndata": uint64(4), "draid_nspares": uint64(1),
+				"draid_ngroups": uint64(1), "children": children,
+			}
+			images := singleTopPool(top, leaves...)
+
+			cols := make([][]byte, len(m.Columns))
+			rest := data
+			for i, c := range m.Columns {
+				cols[i] = make([]byte, c.Size)
+				if i >= m.NParity {
+					rest = rest[copy(cols[i], rest):]
+				}
+			}
+			m.Generate(cols)
+
+			for i, c := range m.Columns {
+				img := images[leaves[c.Child]]
+				if i == test.Spare && test.Spare > 0 {
+					img = images[leaves[spare]]
+				}
+				copy(img[4<<20+c.Offset:], cols[i])
+				if containsColumn(test.Corrupt, i) {
+					img[4<<20+c.Offset] ^= 0xff
+				}
+			}
+
+			absent := map[int]bool{}
+			for _, c := range test.Absent {
+				absent[m.Columns[c].Child] = true
+			}
+			if test.Spare > 0 {
+				absent[m.Columns[test.Spare].Child] = true
+			}
+
+			bad := []zfs.BadCopy{}
+			opts := []func(*zfs.Pool) error{
+				zfs.WithBadCopyHandler(func(bc zfs.BadCopy) { bad = append(bad, bc) }),
+			}
+			for i, g := range leaves {
+				if !absent[i] {
+					opts = append(opts, zfs.WithDevice(fmt.Sprintf("dev%d", g), bytes.NewReader(images[g])))
+				}
+			}
+
+			p, err := zfs.NewPool(opts...)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			got, err := p.ReadPhysical(&bp)
+			if test.Fail {
+				if err == nil {
+					t.Fatal("read a block with too many columns missing")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(got, data) {
+				t.Fatal("read the wrong data")
+			}
+
+			expected := len(test.Absent) + len(test.Corrupt)
+			if test.Spare > 0 {
+				expected++ // the replaced disk.
+			}
+			if len(bad) != expected {
+				t.Errorf("reported %v as bad", bad)
+			}
+		})
+	}
+}
@@ -0,0 +1,131 @@
+// Copyright 2018 Ayan George.
+// All rights reserved.  Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package zfs
+
+import (
+	"encoding/binary"
+	"fmt"
+)
+
+// EmbeddedType describes the payload of an embedded block pointer.
+type EmbeddedType uint8
+
+const (
+	EmbeddedTypeData     = EmbeddedType(iota) // BP_EMBEDDED_TYPE_DATA
+	EmbeddedTypeReserved                      // BP_EMBEDDED_TYPE_RESERVED
+	EmbeddedTypeRedacted                      // BP_EMBEDDED_TYPE_REDACTED
+)
+
+func (et EmbeddedType) String() string {
+	switch et {
+	case EmbeddedTypeData:
+		return "data"
+	case EmbeddedTypeReserved:
+		return "reserved"
+	case EmbeddedTypeRedacted:
+		return "redacted"
+	default:
+		return fmt.Sprintf("*ERROR-%03d-UNKNOWN-EMBEDDED-TYPE*", uint8(et))
+	}
+}
+
+// EmbeddedPayloadSize is the most data an embedded block pointer can hold.
+const EmbeddedPayloadSize = 14 * 8
+
+// EmbeddedType returns the type of the payload of an embedded block pointer.
+// It shares its bits with the checksum type of other block pointers.
+func (bpp BlockPointerProps) EmbeddedType() EmbeddedType {
+	return EmbeddedType(uint8(bpp>>40) & 0xff)
+}
+
+// EmbeddedPayload returns the physical contents of the embedded block
+// pointer bp; the payload stored in place of its DVAs, padding, physical
+// birth, fill count and checksum.
+func (bp *BlockPointer) EmbeddedPayload() ([]byte, error) {
+	if !bp.Props.Embedded() {
+		return nil, fmt.Errorf("block pointer is not embedded")
+	}
+
+	psize := bp.Props.Psize()
+	if psize > EmbeddedPayloadSize {
+		return nil, fmt.Errorf("embedded payload of %d bytes is larger than %d", psize, EmbeddedPayloadSize)
+	}
+
+	// bytes are taken from the low end of each word first.
+	buf := make([]byte, EmbeddedPayloadSize)
+	for i, w := range bp.words() {
+		binary.LittleEndian.PutUint64(buf[i*8:], w)
+	}
+
+	return buf[:psize], nil
+}
+
+// SetEmbeddedPayload stores payload in the block pointer bp, marks it
+// embedded and records the physical size.  The type, logical size and
+// compression of the payload are left for the caller to set.
+func (bp *BlockPointer) SetEmbeddedPayload(payload []byte) error {
+	if len(payload) == 0 || len(payload) > EmbeddedPayloadSize {
+		return fmt.Errorf("embedded payload of %d bytes must be between 1 and %d", len(payload), EmbeddedPayloadSize)
+	}
+
+	buf := make([]byte, EmbeddedPayloadSize)
+	copy(buf, payload)
+
+	words := make([]uint64, 0, 14)
+	for i := 0; i < len(buf); i += 8 {
+		words = append(words, binary.LittleEndian.Uint64(buf[i:]))
+	}
+	bp.setWords(words)
+
+	bp.Props = bp.Props&^(0x7f<<25) | BlockPointerProps(len(payload)-1)<<25 | 1<<39
+	return nil
+}
+
+// words returns the 14 payload words of an embedded block pointer.
+func (bp *BlockPointer) words() []uint64 {
+	rc := []uint64{}
+	for i := range bp.Vdevs {
+		rc = append(rc, bp.Vdevs[i].Word, bp.Vdevs[i].Offset)
+	}
+	rc = append(rc, bp.Padding[0], bp.Padding[1], bp.BirthTransactionGroup, bp.FillCount)
+	return append(rc, bp.ChecksumList[:]...)
+}
+
+// setWords stores the 14 payload words of an embedded block pointer.
+func (bp *BlockPointer) setWords(w []uint64) {
+	for i := range bp.Vdevs {
+		bp.Vdevs[i] = DVA{Word: w[i*2], Offset: w[i*2+1]}
+	}
+	bp.Padding[0], bp.Padding[1], bp.BirthTransactionGroup, bp.FillCount = w[6], w[7], w[8], w[9]
+	copy(bp.ChecksumList[:], w[10:])
+}
+
+// ReadEmbedded returns the logical contents of the embedded block pointer bp
+// decompressing its payload if need be.
+func (bp *BlockPointer) ReadEmbedded() ([]byte, error) {
+	payload, err := bp.EmbeddedPayload()
+	if err != nil {
+		return nil, err
+	}
+
+	if et := bp.Props.EmbeddedType(); et != EmbeddedTypeData {
+		return nil, fmt.Errorf("cannot read embedded block pointer of type %s", et)
+	}
+
+	if bp.Props.Compression() == CompressionOff {
+		return payload, nil
+	}
+
+	lbuf := make([]byte, bp.Props.Lsize())
+	n, err := bp.Props.Compression().Decompress(lbuf, payload)
+	if err != nil {
+		return nil, err
+	}
+	if n != len(lbuf) {
+		return nil, fmt.Errorf("embedded %s block decompressed to %d of %d bytes", bp.Props.Compression(), n, len(lbuf))
+	}
+
+	return lbuf, nil
+}
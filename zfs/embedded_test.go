@@ -0,0 +1,150 @@
+// Copyright 2018 Ayan George.
+// All rights reserved.  Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package zfs_test
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+
+	"github.com/ayang64/ztool/zfs"
+)
+
+// embeddedImage returns the on disk form, in byte order bo, of an embedded
+// block pointer holding payload with properties props.
+func embeddedImage(payload []byte, props uint64, birth uint64, bo binary.ByteOrder) []byte {
+	buf := make([]byte, zfs.EmbeddedPayloadSize)
+	copy(buf, payload)
+
+	words := []uint64{}
+	for i := 0; i < len(buf); i += 8 {
+		words = append(words, binary.LittleEndian.Uint64(buf[i:]))
+	}
+
+	// the properties and logical birth are not part of the payload.
+	all := append(append(append([]uint64{}, words[:6]...), props), words[6:9]...)
+	all = append(append(all, birth), words[9:]...)
+
+	img := make([]byte, 128)
+	for i, w := range all {
+		bo.PutUint64(img[i*8:], w)
+	}
+	return img
+}
+
+func TestEmbeddedPayload(t *testing.T) {
+	payload := make([]byte, zfs.EmbeddedPayloadSize)
+	for i := range payload {
+		payload[i] = byte(i + 1)
+	}
+
+	tests := map[string]struct {
+		Order binary.ByteOrder
+		Size  int
+	}{
+		"little endian full":  {Order: binary.LittleEndian, Size: zfs.EmbeddedPayloadSize},
+		"big endian full":     {Order: binary.BigEndian, Size: zfs.EmbeddedPayloadSize},
+		"little endian short": {Order: binary.LittleEndian, Size: 13},
+		"big endian one byte": {Order: binary.BigEndian, Size: 1},
+	}
+
+	for name, test := range tests {
+		test := test
+		t.Run(name, func(t *testing.T) {
+			p := payload[:test.Size]
+
+			props := uint64(zfs.CompressionOff)<<32 | 1<<39 | uint64(test.Size-1)<<25 | uint64(test.Size-1)
+			if test.Order == binary.LittleEndian {
+				props |= 1 << 63
+			}
+
+			bp := zfs.BlockPointer{}
+			if err := binary.Read(bytes.NewReader(embeddedImage(p, props, 77, test.Order)), test.Order, &bp); err != nil {
+				t.Fatal(err)
+			}
+
+			if !bp.Props.Embedded() || bp.Props.EmbeddedType() != zfs.EmbeddedTypeData {
+				t.Fatalf("props %#x are not embedded data", uint64(bp.Props))
+			}
+			if bp.Props.Lsize() != test.Size || bp.Props.Psize() != test.Size {
+				t.Errorf("lsize %d, psize %d; expected %d", bp.Props.Lsize(), bp.Props.Psize(), test.Size)
+			}
+			if bp.Birth != 77 {
+				t.Errorf("birth %d; expected 77", bp.Birth)
+			}
+
+			got, err := bp.ReadEmbedded()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(got, p) {
+				t.Fatalf("payload %v; expected %v", got, p)
+			}
+
+			// storing the payload again yields the same block pointer.
+			set := zfs.BlockPointer{Props: bp.Props, Birth: bp.Birth}
+			if err := set.SetEmbeddedPayload(p); err != nil {
+				t.Fatal(err)
+			}
+			if set != bp {
+				t.Fatalf("SetEmbeddedPayload() built %#v; expected %#v", set, bp)
+			}
+		})
+	}
+}
+
+func TestReadEmbeddedErrors(t *testing.T) {
+	redacted := zfs.BlockPointer{Props: zfs.BlockPointerProps(uint64(zfs.EmbeddedTypeRedacted) << 40)}
+	if err := redacted.SetEmbeddedPayload([]byte{1}); err != nil {
+		t.Fatal(err)
+	}
+
+	// a single lzjb literal run expands to 8 of the 64 bytes claimed by lsize.
+	short := zfs.BlockPointer{Props: zfs.BlockPointerProps(1<<63 | uint64(zfs.CompressionLZJB)<<32 | 63)}
+	if err := short.SetEmbeddedPayload([]byte{0, 1, 2, 3, 4, 5, 6, 7, 8}); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := map[string]zfs.BlockPointer{
+		"not embedded": {Props: blockProps(512)},
+		"redacted":     redacted,
+		"short":        short,
+	}
+
+	for name, bp := range tests {
+		bp := bp
+		t.Run(name, func(t *testing.T) {
+			if b, err := bp.ReadEmbedded(); err == nil {
+				t.Fatalf("read %v without error", b)
+			}
+		})
+	}
+
+	if err := (&zfs.BlockPointer{}).SetEmbeddedPayload(make([]byte, zfs.EmbeddedPayloadSize+1)); err == nil {
+		t.Fatal("stored an oversized payload")
+	}
+}
+
+func TestPoolReadEmbedded(t *testing.T) {
+	p, err := zfs.NewPool(zfs.WithDevice("dev20", bytes.NewReader(poolDevice(testPoolGUID, 20, 20, 40))))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	payload := []byte("data that lives in the block pointer")
+
+	bp := zfs.BlockPointer{Props: zfs.BlockPointerProps(1<<63 | uint64(zfs.CompressionOff)<<32 | uint64(len(payload)-1))}
+	if err := bp.SetEmbeddedPayload(payload); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := p.ReadPhysical(&bp)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, payload) {
+		t.Fatalf("read %q; expected %q", got, payload)
+	}
+}
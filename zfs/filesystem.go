@@ -10,19 +10,23 @@ import (
 	"fmt"
 	"io"
 	"os"
+	"sort"
 
 	"github.com/ayang64/ztool/zfs/nvlist"
 )
 
 type cache struct {
 	ashift *uint64
+	pool   *Pool
 }
 
 type Filesystem struct {
 	rs     io.ReadSeeker
 	nvlist nvlist.List
 
-	vdl VdevLabel
+	vdl    VdevLabel
+	labels []LabelCopy // all copies of the vdev label found on the device.
+	label  int         // index into labels of the copy in use.
 
 	cache
 }
@@ -66,41 +70,100 @@ func New(opts ...func(*Filesystem) error) (*Filesystem, error) {
 		return nil, err
 	}
 
-	if err := rc.readNVP(); err != nil {
+	return &rc, nil
+}
+
+// GetDnode reads and decodes the dnode bp points to.
+func (fs *Filesystem) GetDnode(bp *BlockPointer) (*DnodePhys, error) {
+	lbuf, err := fs.ReadBlock(bp)
+	if err != nil {
 		return nil, err
 	}
 
-	return &rc, nil
+	return bp.Vdevs[0].ReadDnode(bytes.NewReader(lbuf), bp.Props.ByteOrder())
 }
 
-func (fs *Filesystem) GetDnode(bp *BlockPointer) (*DnodePhys, error) {
-	return bp.GetDnode(fs.rs)
+// ReadBlock returns the verified logical contents of the block bp points to.
+// The device is read as part of the pool its label describes so the DVA of
+// each copy is resolved through the vdev tree; copies held by other devices
+// cannot be read.
+func (fs *Filesystem) ReadBlock(bp *BlockPointer) ([]byte, error) {
+	p, err := fs.pool()
+	if err != nil {
+		return nil, err
+	}
+
+	return p.ReadBlock(bp)
 }
 
+// pool returns the pool made up of just this device.
+func (fs *Filesystem) pool() (*Pool, error) {
+	if fs.cache.pool != nil {
+		return fs.cache.pool, nil
+	}
+
+	if fs.rs == nil {
+		return nil, fmt.Errorf("no device to read blocks from")
+	}
+
+	p, err := NewPool(WithDevice("", fs.rs))
+	if err != nil {
+		return nil, err
+	}
+
+	fs.cache.pool = p
+	return p, nil
+}
+
+// LoadVdevLabel reads every copy of the vdev label and uses the first one
+// that is valid.  This lets us open devices whose front labels have been
+// overwritten as long as L2 or L3 survived.  A *LabelError is returned if no
+// copy is valid.
 func (fs *Filesystem) LoadVdevLabel() error {
-	// get nvlist
-	fs.rs.Seek(0, 0)
-	if err := binary.Read(fs.rs, binary.LittleEndian, &fs.vdl); err != nil {
+	labels, err := ReadVdevLabels(fs.rs)
+	if err != nil {
 		return err
 	}
 
-	return nil
+	fs.labels = labels
+
+	for i := range labels {
+		if !labels[i].Valid() {
+			continue
+		}
+		fs.label = i
+		fs.vdl = *labels[i].Label
+		fs.nvlist = labels[i].NVList
+		fs.cache = cache{}
+		return nil
+	}
+
+	return &LabelError{Labels: labels}
 }
 
+// Labels returns all four copies of the vdev label read from the device.
+func (fs *Filesystem) Labels() []LabelCopy {
+	return fs.labels
+}
+
+// Label returns the copy of the vdev label in use.
+func (fs *Filesystem) Label() *LabelCopy {
+	if fs.labels == nil {
+		return nil
+	}
+	return &fs.labels[fs.label]
+}
+
+// ActiveUberBlock returns the most recent valid uber block in the ring.
 func (fs *Filesystem) ActiveUberBlock() (*ActiveUberBlock, error) {
-	ubs, err := fs.UberBlocks()
+	ubs, err := fs.UberBlockCandidates()
 
 	if err != nil {
 		return nil, err
 	}
 
-	var txg uint64
-	var idx int
-
-	for i := range ubs {
-		if ubs[i].TransactionGroup <= txg {
-		}
-		idx, txg = i, ubs[i].TransactionGroup
+	if len(ubs) == 0 {
+		return nil, fmt.Errorf("no valid uber blocks found")
 	}
 
 	ashift, err := fs.AShift()
@@ -111,13 +174,41 @@ func (fs *Filesystem) ActiveUberBlock() (*ActiveUberBlock, error) {
 
 	aub := ActiveUberBlock{
 		AShift:    ashift,
-		UberBlock: ubs[idx],
+		ByteOrder: ubs[0].ByteOrder,
+		UberBlock: ubs[0].UberBlock,
 	}
 
 	return &aub, nil
 }
 
-func (fs *Filesystem) UberBlocks() ([]UberBlock, error) {
+// UberBlockCandidates returns the valid uber blocks in the ring ordered from
+// newest to oldest.  The first entry is the active uber block and the rest are
+// the candidates for rewinding the pool to an earlier transaction group.
+func (fs *Filesystem) UberBlockCandidates() ([]UberBlockSlot, error) {
+	ubs, err := fs.UberBlocks()
+
+	if err != nil {
+		return nil, err
+	}
+
+	rc := []UberBlockSlot{}
+	for i := range ubs {
+		if ubs[i].Valid() {
+			rc = append(rc, ubs[i])
+		}
+	}
+
+	sort.SliceStable(rc, func(i, j int) bool {
+		return CompareUberBlocks(&rc[i].UberBlock, &rc[j].UberBlock) > 0
+	})
+
+	return rc, nil
+}
+
+// UberBlocks returns every slot of the uber block ring in the label in use.
+// Slots that are empty, have a bad magic number or fail their checksum are
+// flagged with an error.
+func (fs *Filesystem) UberBlocks() ([]UberBlockSlot, error) {
 	// the ashift determines our block size.  this also determines how many
 	// uberblocks we can fit in our uberblock array.
 	ashift, err := fs.AShift()
@@ -125,22 +216,33 @@ func (fs *Filesystem) UberBlocks() ([]UberBlock, error) {
 		return nil, err
 	}
 
-	r := bytes.NewReader(fs.vdl.UberBlockBuf[:])
+	var base int64
+	if l := fs.Label(); l != nil {
+		base = l.Offset + labelUberBlockOffset
+	}
+
+	ring := fs.vdl.UberBlockBuf[:]
 
-	rc := []UberBlock{}
+	rc := []UberBlockSlot{}
 
-	// the uber block section is 128 << 10 bytes in size.
-	//
-	// FIXME: this feels hamfisted.  i feel like there is a better way to express
-	// this.
-	for i, rsize, nrecords := 0, (1 << ashift), (128<<10)/(1<<ashift); i < nrecords; i++ {
-		offset := int64(i * rsize)
-		r.Seek(offset, 0)
+	// the uber block section is 128 << 10 bytes in size and is divided into
+	// slots that are at least 1k and at most 8k in size.
+	for i, rsize := 0, 1<<uberBlockShift(ashift); i < len(ring)/rsize; i++ {
+		buf := ring[i*rsize : (i+1)*rsize]
 
-		var ub UberBlock
+		slot := UberBlockSlot{
+			Index:  i,
+			Offset: base + int64(i*rsize),
+		}
 
-		binary.Read(r, binary.LittleEndian, &ub)
-		rc = append(rc, ub)
+		// uber blocks are written in the byte order of the host that wrote
+		// them; the magic number tells us which one that was.
+		if slot.ByteOrder, slot.Err = uberBlockOrder(buf); slot.Err == nil {
+			binary.Read(bytes.NewReader(buf), slot.ByteOrder, &slot.UberBlock)
+			slot.Err = VerifyLabelChecksum(buf, uint64(slot.Offset))
+		}
+
+		rc = append(rc, slot)
 	}
 
 	return rc, nil
@@ -151,29 +253,40 @@ func (fs *Filesystem) AShift() (uint, error) {
 		return uint(*fs.cache.ashift), nil
 	}
 
-	ashift, found := fs.nvlist.Find("ashift")
+	var label struct {
+		VdevTree struct {
+			AShift *uint64 `nvlist:"ashift"`
+		} `nvlist:"vdev_tree"`
+	}
 
-	if !found {
-		return 0, fmt.Errorf("ashift not found")
+	if err := nvlist.UnmarshalList(fs.nvlist, &label); err != nil {
+		return 0, err
 	}
 
-	fs.cache.ashift = new(uint64)
+	if label.VdevTree.AShift == nil {
+		return 0, fmt.Errorf("ashift not found")
+	}
 
-	*fs.cache.ashift = ashift.(uint64)
+	fs.cache.ashift = label.VdevTree.AShift
 
 	return uint(*fs.cache.ashift), nil
 }
 
-func (fs *Filesystem) readNVP() error {
-
-	r := bytes.NewReader(fs.vdl.NVP[:])
-
-	l, err := nvlist.Read(r)
+// Config returns the configuration stored in the vdev label in use.
+func (fs *Filesystem) Config() (*LabelConfig, error) {
+	var rc LabelConfig
+	if err := nvlist.UnmarshalList(fs.nvlist, &rc); err != nil {
+		return nil, err
+	}
+	return &rc, nil
+}
 
+// VdevTree returns the typed tree of the top level vdev the device belongs
+// to.
+func (fs *Filesystem) VdevTree() (VdevTree, error) {
+	cfg, err := fs.Config()
 	if err != nil {
-		return err
+		return nil, err
 	}
-
-	fs.nvlist = l
-	return nil
+	return NewVdevTree(&cfg.VdevTree)
 }
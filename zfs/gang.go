@@ -0,0 +1,143 @@
+// Copyright 2018 Ayan George.
+// All rights reserved.  Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package zfs
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+)
+
+// typedef struct zio_gbh {
+// 	blkptr_t		zg_blkptr[SPA_GBH_NBLKPTRS];
+// 	uint64_t		zg_filler[SPA_GBH_FILLER];
+// 	zio_eck_t		zg_tail;
+// } zio_gbh_phys_t;
+
+const (
+	// GangHeaderSize is the size of a gang block header.  Pools with the
+	// dynamic_gang_header feature may use larger headers which are not
+	// supported.
+	GangHeaderSize = 512
+
+	// GangHeaderBlockPointers is the number of block pointers in a gang
+	// header.
+	GangHeaderBlockPointers = (GangHeaderSize - EmbeddedChecksumSize) / 128
+)
+
+// GangHeader is the block a gang block pointer points to.  Its block pointers
+// point to the members of the gang; their physical contents in order make up
+// the physical contents of the gang block.  Unused block pointers are holes.
+type GangHeader struct {
+	BlockPointers [GangHeaderBlockPointers]BlockPointer
+	Filler        [(GangHeaderSize - EmbeddedChecksumSize - GangHeaderBlockPointers*128) / 8]uint64
+	Tail          EmbeddedChecksum
+}
+
+// gangVerifier returns the value that stands in for the embedded checksum of
+// the gang header bp points to when the checksum is computed.
+func gangVerifier(bp *BlockPointer) [4]uint64 {
+	txg := bp.BirthTransactionGroup
+	if txg == 0 {
+		txg = bp.Birth
+	}
+	return [4]uint64{uint64(bp.Vdevs[0].Vdev()), bp.Vdevs[0].VdevOffset(), txg, 0}
+}
+
+// VerifyGangChecksum checks the embedded checksum of buf, the gang header bp
+// points to.  The header may have been written in either byte order.
+func VerifyGangChecksum(buf []byte, bp *BlockPointer) error {
+	bo, err := embeddedChecksumOrder(buf)
+	if err != nil {
+		return err
+	}
+
+	e := ChecksumError{Actual: embeddedChecksum(buf, gangVerifier(bp), bo)}
+
+	cksum := buf[len(buf)-EmbeddedChecksumSize+8:]
+	for i := range e.Expected {
+		e.Expected[i] = bo.Uint64(cksum[i*8:])
+	}
+
+	if e.Expected != e.Actual {
+		return &e
+	}
+
+	return nil
+}
+
+// WriteGangChecksum stores an embedded checksum at the end of buf so that it
+// passes VerifyGangChecksum as the gang header bp points to.  This is mostly
+// useful for building device images.
+func WriteGangChecksum(buf []byte, bp *BlockPointer, bo binary.ByteOrder) {
+	eck := buf[len(buf)-EmbeddedChecksumSize:]
+	bo.PutUint64(eck, EmbeddedChecksumMagic)
+
+	cksum := embeddedChecksum(buf, gangVerifier(bp), bo)
+	for i := range cksum {
+		bo.PutUint64(eck[8+i*8:], cksum[i])
+	}
+}
+
+// ReadGangHeader verifies and decodes buf, the gang header bp points to.
+func ReadGangHeader(buf []byte, bp *BlockPointer) (*GangHeader, error) {
+	if len(buf) != GangHeaderSize {
+		return nil, fmt.Errorf("gang header is %d bytes; expected %d", len(buf), GangHeaderSize)
+	}
+
+	if err := VerifyGangChecksum(buf, bp); err != nil {
+		return nil, err
+	}
+
+	bo, _ := embeddedChecksumOrder(buf)
+
+	gh := GangHeader{}
+	if err := binary.Read(bytes.NewReader(buf), bo, &gh); err != nil {
+		return nil, err
+	}
+
+	return &gh, nil
+}
+
+// readGang reads the gang block whose header is at offset in the top level
+// vdev v and reassembles the physical contents of the block from its members.
+func (p *Pool) readGang(bp *BlockPointer, v VdevTree, offset uint64, bad func(VdevTree, error)) ([]byte, error) {
+	var gh *GangHeader
+	verify := func(buf []byte) error {
+		var err error
+		gh, err = ReadGangHeader(buf, bp)
+		return err
+	}
+
+	if _, err := p.readVdev(v, offset, GangHeaderSize, verify, bad); err != nil {
+		return nil, err
+	}
+
+	data := []byte{}
+	for i := range gh.BlockPointers {
+		member := &gh.BlockPointers[i]
+		if member.Vdevs[0].IsEmpty() {
+			continue
+		}
+
+		// members may themselves be gang blocks.
+		b, err := p.ReadPhysical(member)
+		if err != nil {
+			return nil, fmt.Errorf("gang member %d: %w", i, err)
+		}
+		data = append(data, b...)
+	}
+
+	if len(data) != bp.Props.Psize() {
+		return nil, fmt.Errorf("gang members hold %d bytes; expected %d", len(data), bp.Props.Psize())
+	}
+
+	if err := verifyBlock(bp, data); err != nil {
+		bad(v, err)
+		return nil, err
+	}
+
+	return data, nil
+}
@@ -0,0 +1,164 @@
+// Copyright 2018 Ayan George.
+// All rights reserved.  Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package zfs_test
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math/rand"
+	"testing"
+
+	"github.com/ayang64/ztool/zfs"
+)
+
+// gangHeader returns the gang header gang points to holding members.
+func gangHeader(gang *zfs.BlockPointer, bo binary.ByteOrder, members ...zfs.BlockPointer) []byte {
+	gh := zfs.GangHeader{}
+	copy(gh.BlockPointers[:], members)
+
+	b := &bytes.Buffer{}
+	binary.Write(b, bo, gh)
+	buf := b.Bytes()
+	zfs.WriteGangChecksum(buf, gang, bo)
+	return buf
+}
+
+func TestReadGangHeader(t *testing.T) {
+	gang := zfs.BlockPointer{Birth: 7}
+	gang.Vdevs[0] = zfs.NewDVA(1, 99<<9, true)
+
+	member := dataBlock(0, 5, make([]byte, 512))
+
+	for _, bo := range []binary.ByteOrder{binary.LittleEndian, binary.BigEndian} {
+		bo := bo
+		t.Run(bo.String(), func(t *testing.T) {
+			buf := gangHeader(&gang, bo, member)
+
+			gh, err := zfs.ReadGangHeader(buf, &gang)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if gh.BlockPointers[0] != member || !gh.BlockPointers[1].Vdevs[0].IsEmpty() {
+				t.Fatalf("decoded %v", gh.BlockPointers)
+			}
+
+			// the checksum depends on where the header is.
+			moved := gang
+			moved.Vdevs[0].Offset++
+			var cerr *zfs.ChecksumError
+			if _, err := zfs.ReadGangHeader(buf, &moved); !errors.As(err, &cerr) {
+				t.Fatalf("ReadGangHeader() of a moved header = %v; expected *ChecksumError", err)
+			}
+
+			// so does the physical birth when it differs from the
+			// logical one.
+			rewritten := gang
+			rewritten.BirthTransactionGroup = 9
+			if _, err := zfs.ReadGangHeader(buf, &rewritten); !errors.As(err, &cerr) {
+				t.Fatalf("ReadGangHeader() with another physical birth = %v; expected *ChecksumError", err)
+			}
+			if _, err := zfs.ReadGangHeader(gangHeader(&rewritten, bo, member), &rewritten); err != nil {
+				t.Fatalf("ReadGangHeader() of a rewritten header = %v", err)
+			}
+		})
+	}
+}
+
+func TestPoolReadGang(t *testing.T) {
+	const sector = 512
+
+	rnd := rand.New(rand.NewSource(4))
+	data := make([]byte, 3*sector)
+	rnd.Read(data)
+
+	// the block is a gang of a plain member and a gang of two more.
+	first := dataBlock(1, 40, data[:sector])
+	second := dataBlock(1, 41, data[sector:2*sector])
+	third := dataBlock(0, 42, data[2*sector:])
+
+	inner := dataBlock(0, 50, data[sector:])
+	inner.Vdevs[0].Offset |= 1 << 63
+
+	gang := dataBlock(0, 30, data)
+	gang.Vdevs[0].Offset |= 1 << 63
+
+	place := func(images map[uint64][]byte, guids []uint64, sector uint64, b []byte) {
+		for _, g := range guids {
+			copy(images[g][4<<20+sector*512:], b)
+		}
+	}
+
+	tests := map[string]struct {
+		Damage func(images map[uint64][]byte)
+		Bad    int
+		Fail   bool
+	}{
+		"intact": {Damage: func(map[uint64][]byte) {}},
+		"header copy": {
+			Damage: func(images map[uint64][]byte) { images[11][4<<20+30*512] ^= 0xff },
+			Bad:    1,
+		},
+		"nested header copy": {
+			Damage: func(images map[uint64][]byte) { images[11][4<<20+50*512+8] ^= 0xff },
+			Bad:    1,
+		},
+		"member": {
+			Damage: func(images map[uint64][]byte) { images[20][4<<20+41*512] ^= 0xff },
+			Bad:    1,
+			Fail:   true,
+		},
+	}
+
+	for name, test := range tests {
+		test := test
+		t.Run(name, func(t *testing.T) {
+			images := map[uint64][]byte{}
+			for g, top := range map[uint64]uint64{11: 10, 12: 10, 20: 20} {
+				images[g] = poolDevice(testPoolGUID, g, top, 40)
+			}
+
+			place(images, []uint64{11, 12}, 30, gangHeader(&gang, binary.LittleEndian, first, inner))
+			place(images, []uint64{11, 12}, 50, gangHeader(&inner, binary.BigEndian, second, third))
+			place(images, []uint64{20}, 40, data[:sector])
+			place(images, []uint64{20}, 41, data[sector:2*sector])
+			place(images, []uint64{11, 12}, 42, data[2*sector:])
+
+			test.Damage(images)
+
+			bad := []zfs.BadCopy{}
+			opts := []func(*zfs.Pool) error{
+				zfs.WithBadCopyHandler(func(bc zfs.BadCopy) { bad = append(bad, bc) }),
+			}
+			for g, img := range images {
+				opts = append(opts, zfs.WithDevice(fmt.Sprintf("dev%d", g), bytes.NewReader(img)))
+			}
+
+			p, err := zfs.NewPool(opts...)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			got, err := p.ReadPhysical(&gang)
+			if test.Fail {
+				if err == nil {
+					t.Fatal("read a gang block with a damaged member")
+				}
+			} else {
+				if err != nil {
+					t.Fatal(err)
+				}
+				if !bytes.Equal(got, data) {
+					t.Fatal("reassembled the wrong data")
+				}
+			}
+
+			if len(bad) != test.Bad {
+				t.Errorf("reported %v as bad", bad)
+			}
+		})
+	}
+}
@@ -0,0 +1,71 @@
+// Copyright 2018 Ayan George.
+// All rights reserved.  Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package zfs
+
+import (
+	"bytes"
+	"compress/zlib"
+	"fmt"
+	"io"
+)
+
+// Blocks compressed with gzip-N hold a zlib stream, not a gzip file, written
+// at level N.  The stream is followed by padding up to the physical size.
+
+// gzipLevel returns the compression level of zct, which must be one of the
+// gzip compression types.
+func gzipLevel(zct ZfsCompressionType) int {
+	return int(zct-CompressionGzip1) + 1
+}
+
+// gzipDecompress fills dst from src, a zlib stream that must hold exactly
+// len(dst) bytes.
+func gzipDecompress(dst []byte, src []byte) (int, error) {
+	zr, err := zlib.NewReader(bytes.NewReader(src))
+	if err != nil {
+		return 0, err
+	}
+	defer zr.Close()
+
+	n, err := io.ReadFull(zr, dst)
+	if err != nil {
+		return n, fmt.Errorf("gzip stream holds %d of %d bytes: %w", n, len(dst), err)
+	}
+
+	// the stream must end where the logical block does; reading to the end
+	// also checks the stream's checksum.
+	rest, err := io.ReadAll(zr)
+	if err != nil {
+		return n, err
+	}
+	if len(rest) != 0 {
+		return n, fmt.Errorf("gzip stream holds %d bytes; expected %d", n+len(rest), len(dst))
+	}
+
+	return n, nil
+}
+
+// gzipCompress compresses src into dst as a zlib stream at the given level.
+// It fails if the stream does not fit in dst.
+func gzipCompress(dst []byte, src []byte, level int) (int, error) {
+	b := &bytes.Buffer{}
+
+	zw, err := zlib.NewWriterLevel(b, level)
+	if err != nil {
+		return 0, err
+	}
+	if _, err := zw.Write(src); err != nil {
+		return 0, err
+	}
+	if err := zw.Close(); err != nil {
+		return 0, err
+	}
+
+	if b.Len() > len(dst) {
+		return 0, fmt.Errorf("%d bytes do not compress into %d", len(src), len(dst))
+	}
+
+	return copy(dst, b.Bytes()), nil
+}
@@ -0,0 +1,64 @@
+// Copyright 2018 Ayan George.
+// All rights reserved.  Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package zfs_test
+
+import (
+	"testing"
+
+	"github.com/ayang64/ztool/zfs"
+)
+
+func TestGzipDecompress(t *testing.T) {
+	// "zfs zfs zfs zfs zfs zfs" at level 9 followed by sector padding.
+	stream := []byte{0x78, 0xda, 0xab, 0x4a, 0x2b, 0x56, 0xa8, 0xc2, 0xc4, 0x00, 0x67, 0x19, 0x08, 0x93}
+	padded := append(append([]byte{}, stream...), make([]byte, 512-len(stream))...)
+
+	damaged := append([]byte{}, stream...)
+	damaged[len(damaged)-1] ^= 0xff
+
+	tests := map[string]struct {
+		Src  []byte
+		Size int
+		Fail bool
+	}{
+		"stream":      {Src: stream, Size: 23},
+		"padded":      {Src: padded, Size: 23},
+		"too long":    {Src: stream, Size: 24, Fail: true},
+		"too short":   {Src: stream, Size: 22, Fail: true},
+		"checksum":    {Src: damaged, Size: 23, Fail: true},
+		"not zlib":    {Src: []byte("zfs zfs zfs zfs zfs zfs"), Size: 23, Fail: true},
+		"truncated":   {Src: stream[:8], Size: 23, Fail: true},
+		"empty input": {Src: []byte{}, Size: 23, Fail: true},
+	}
+
+	for name, test := range tests {
+		test := test
+		t.Run(name, func(t *testing.T) {
+			dst := make([]byte, test.Size)
+			_, err := zfs.CompressionGzip9.Decompress(dst, test.Src)
+			if test.Fail {
+				if err == nil {
+					t.Fatalf("decompressed %q without error", dst)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(dst) != "zfs zfs zfs zfs zfs zfs" {
+				t.Fatalf("decompressed %q", dst)
+			}
+		})
+	}
+}
+
+func TestGzipCompress(t *testing.T) {
+	data := compressibleData(64 << 10)
+
+	// the compressor refuses to grow a block.
+	if n, err := zfs.CompressionGzip9.Compress(make([]byte, 8), data); err == nil {
+		t.Fatalf("compressed %d bytes into %d", len(data), n)
+	}
+}
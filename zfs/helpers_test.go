@@ -0,0 +1,85 @@
+// Copyright 2018 Ayan George.
+// All rights reserved.  Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package zfs_test
+
+import (
+	"bytes"
+	"encoding/binary"
+	"math/rand"
+
+	"github.com/ayang64/ztool/zfs"
+	"github.com/ayang64/ztool/zfs/nvlist"
+)
+
+// a pool made of a two way mirror (guid 10) and a single disk (guid 20).
+const (
+	testPoolGUID    = 100
+	testPoolGuidSum = testPoolGUID + 10 + 11 + 12 + 20
+)
+
+var testPoolTops = map[uint64]nvlist.List{
+	10: {
+		"type": "mirror", "id": uint64(0), "guid": uint64(10), "ashift": uint64(9),
+		"children": []nvlist.List{disk(11, "/dev/da0"), disk(12, "/dev/da1")},
+	},
+	20: {"type": "disk", "id": uint64(1), "guid": uint64(20), "ashift": uint64(9), "path": "/dev/da2"},
+}
+
+func uint64p(v uint64) *uint64 {
+	return &v
+}
+
+// poolDevice returns the image of the device with the given guid in top level
+// vdev top of the pool with guid pool.
+func poolDevice(pool, guid, top, txg uint64) []byte {
+	img := labelImage(8<<20, xdrList(
+		"version", uint64(5000),
+		"name", "tank",
+		"state", uint64(0),
+		"txg", txg,
+		"pool_guid", pool,
+		"guid", guid,
+		"top_guid", top,
+		"vdev_children", uint64(2),
+		"vdev_tree", testPoolTops[top],
+	))
+
+	putUberBlock(img, 10, 0, zfs.UberBlock{
+		Magic:            zfs.UberBlockMagic,
+		TransactionGroup: txg,
+		GuidSum:          testPoolGuidSum,
+	}, binary.LittleEndian)
+
+	return img
+}
+
+// dataBlock returns a block pointer to the uncompressed block data at sector
+// offset of top level vdev top.  As in real pools only the logical birth is
+// set; the physical birth is zero when the two are the same.
+func dataBlock(top uint32, offset uint64, data []byte) zfs.BlockPointer {
+	bp := zfs.BlockPointer{Props: blockProps(len(data)), Birth: 40}
+	bp.Vdevs[0] = zfs.NewDVA(top, offset<<9, false)
+	bp.ChecksumList, _ = zfs.BlockChecksum(zfs.ChecksumFletcher4, data, binary.LittleEndian)
+	return bp
+}
+
+// blockProps returns the properties of an uncompressed little endian block
+// of size bytes checksummed with fletcher4.
+func blockProps(size int) zfs.BlockPointerProps {
+	n := uint64(size/512 - 1)
+	return zfs.BlockPointerProps(1<<63 | uint64(zfs.ChecksumFletcher4)<<40 | uint64(zfs.CompressionOff)<<32 | n<<16 | n)
+}
+
+// compressibleData returns size bytes of text-like data.
+func compressibleData(size int) []byte {
+	rnd := rand.New(rand.NewSource(int64(size)))
+	words := []string{"zfs ", "pool ", "dnode ", "block ", "pointer ", "\n", "0000", "vdev "}
+
+	b := &bytes.Buffer{}
+	for b.Len() < size {
+		b.WriteString(words[rnd.Intn(len(words))])
+	}
+	return b.Bytes()[:size]
+}
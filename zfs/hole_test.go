@@ -0,0 +1,121 @@
+// Copyright 2018 Ayan George.
+// All rights reserved.  Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package zfs_test
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/ayang64/ztool/zfs"
+)
+
+func TestBlockPointerIsHole(t *testing.T) {
+	written := dataBlock(1, 40, make([]byte, 512))
+
+	unborn := written
+	unborn.Birth = 0
+
+	// dedup and clones leave the physical birth older than the logical
+	// one; the physical birth alone does not make a block.
+	physical := written
+	physical.Birth, physical.BirthTransactionGroup = 0, 30
+
+	// only the first DVA decides whether a block is a hole.
+	punched := zfs.BlockPointer{Props: blockProps(4096), Birth: 60}
+	punched.Vdevs[1] = zfs.NewDVA(1, 40<<9, false)
+
+	holeBirth := zfs.BlockPointer{Props: blockProps(4096), Birth: 55}
+
+	embedded := zfs.BlockPointer{Props: zfs.BlockPointerProps(1 << 63)}
+	embedded.SetEmbeddedPayload([]byte{0})
+
+	tests := map[string]struct {
+		BP    zfs.BlockPointer
+		Hole  bool
+		Birth uint64
+	}{
+		"zeroed":     {BP: zfs.BlockPointer{}, Hole: true},
+		"hole birth": {BP: holeBirth, Hole: true, Birth: 55},
+		"unborn":     {BP: unborn, Hole: true},
+		"written":    {BP: written},
+		"physical":   {BP: physical},
+		"second dva": {BP: punched, Hole: true, Birth: 60},
+		"embedded":   {BP: embedded},
+	}
+
+	for name, test := range tests {
+		test := test
+		t.Run(name, func(t *testing.T) {
+			if test.BP.IsHole() != test.Hole {
+				t.Fatalf("IsHole() = %v; expected %v", test.BP.IsHole(), test.Hole)
+			}
+			if test.BP.HoleBirth() != test.Birth {
+				t.Fatalf("HoleBirth() = %d; expected %d", test.BP.HoleBirth(), test.Birth)
+			}
+		})
+	}
+}
+
+func TestPoolReadBlockHole(t *testing.T) {
+	// the hole's DVA-less offset would land on junk if it were read.
+	img := poolDevice(testPoolGUID, 20, 20, 40)
+	for i := 4 << 20; i < 4<<20+8192; i++ {
+		img[i] = 0xa5
+	}
+
+	p, err := zfs.NewPool(zfs.WithDevice("dev20", bytes.NewReader(img)))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := map[string]struct {
+		BP      zfs.BlockPointer
+		Size    int
+		Context int // logical size known from the dnode.
+	}{
+		"zeroed":     {BP: zfs.BlockPointer{}, Size: 512, Context: 128 << 10},
+		"hole birth": {BP: zfs.BlockPointer{Props: blockProps(4096), Birth: 55}, Size: 4096, Context: 4096},
+	}
+
+	for name, test := range tests {
+		test := test
+		t.Run(name, func(t *testing.T) {
+			b, err := p.ReadBlock(&test.BP)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(b, make([]byte, test.Size)) {
+				t.Fatalf("read %d bytes %v; expected %d zeros", len(b), b[:16], test.Size)
+			}
+
+			b, err = p.ReadBlockSize(&test.BP, test.Context)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(b, make([]byte, test.Context)) {
+				t.Fatalf("read %d bytes in a %d byte context", len(b), test.Context)
+			}
+
+			dn, err := p.GetDnode(&test.BP)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if *dn != (zfs.DnodePhys{}) {
+				t.Fatalf("hole decoded as %#v", *dn)
+			}
+		})
+	}
+
+	// blocks that are not holes record their own size.
+	data := bytes.Repeat([]byte{0x5a}, 512)
+	copy(img[4<<20+40*512:], data)
+	bp := dataBlock(1, 40, data)
+	if b, err := p.ReadBlockSize(&bp, 128<<10); err == nil {
+		t.Fatalf("read %d bytes of a 512 byte block in a 128K context", len(b))
+	}
+	if b, err := p.ReadBlockSize(&bp, 512); err != nil || !bytes.Equal(b, data) {
+		t.Fatalf("ReadBlockSize() read %d bytes; %v", len(b), err)
+	}
+}
@@ -5,10 +5,8 @@
 package nvlist_test
 
 import (
-	"bytes"
 	"encoding/json"
 	"io"
-	"log"
 	"os"
 	"path"
 	"testing"
@@ -34,12 +32,9 @@ func TestLooper(t *testing.T) {
 	reader := func() io.Reader {
 		switch path.Ext(volpath) {
 		case ".cache":
-			// concatinate a few bytes
-			inf, err := os.Open(volpath)
-			if err != nil {
-				log.Fatal(err)
-			}
-			return io.MultiReader(bytes.NewReader([]byte{0x0, 0x1, 0x0, 0x0}), inf)
+			// zpool.cache is a natively encoded nvlist that includes its
+			// header.
+			return fh
 		default:
 			// zfs nvlist is XDR encoded data that lives between 0x4000 - 0x20000 on the volume.
 			// return a section reader covering that range.
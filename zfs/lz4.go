@@ -0,0 +1,61 @@
+// Copyright 2018 Ayan George.
+// All rights reserved.  Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package zfs
+
+import (
+	"encoding/binary"
+	"fmt"
+
+	"github.com/pierrec/lz4"
+)
+
+// LZ4 compressed blocks start with the size of the compressed data as a 4
+// byte big endian integer.  A raw LZ4 block, not an LZ4 frame, follows and is
+// padded up to the physical size of the block.
+const lz4HeaderSize = 4
+
+// lz4Decompress fills dst from src, an LZ4 compressed block that must hold
+// exactly len(dst) bytes.
+func lz4Decompress(dst []byte, src []byte) (int, error) {
+	if len(src) < lz4HeaderSize {
+		return 0, fmt.Errorf("lz4 block of %d bytes is too short for its header", len(src))
+	}
+
+	size := binary.BigEndian.Uint32(src)
+	if uint64(size) > uint64(len(src)-lz4HeaderSize) {
+		return 0, fmt.Errorf("lz4 data of %d bytes overruns the %d byte block", size, len(src))
+	}
+
+	// the decoder silently stops at the end of its output buffer so a byte
+	// of room is left to tell a block that is too long.
+	out := make([]byte, len(dst)+1)
+	n, err := lz4.UncompressBlock(src[lz4HeaderSize:lz4HeaderSize+size], out)
+	if err != nil {
+		return 0, err
+	}
+	if n > len(dst) {
+		return 0, fmt.Errorf("lz4 block holds more than %d bytes", len(dst))
+	}
+	if n < len(dst) {
+		return 0, fmt.Errorf("lz4 block holds %d bytes; expected %d", n, len(dst))
+	}
+
+	return copy(dst, out), nil
+}
+
+// lz4Compress compresses src into dst and prepends the size of the compressed
+// data.  It fails if the result does not fit in dst.
+func lz4Compress(dst []byte, src []byte) (int, error) {
+	if len(dst) > lz4HeaderSize {
+		// the compressor returns 0 for data it cannot shrink.
+		n, err := lz4.CompressBlock(src, dst[lz4HeaderSize:], make([]int, 1<<16))
+		if err == nil && n > 0 {
+			binary.BigEndian.PutUint32(dst, uint32(n))
+			return lz4HeaderSize + n, nil
+		}
+	}
+
+	return 0, fmt.Errorf("%d bytes do not compress into %d", len(src), len(dst))
+}
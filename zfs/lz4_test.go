@@ -0,0 +1,92 @@
+// Copyright 2018 Ayan George.
+// All rights reserved.  Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package zfs_test
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+
+	"github.com/ayang64/ztool/zfs"
+)
+
+// lz4Block is lz4Data as compressed by the LZ4 compressor in ZFS.
+var lz4Block = []byte{
+	0x00, 0x00, 0x00, 0x27, 0xff, 0x0a, 0x6c, 0x7a, 0x34, 0x20, 0x63, 0x6f,
+	0x6d, 0x70, 0x72, 0x65, 0x73, 0x73, 0x65, 0x64, 0x20, 0x7a, 0x66, 0x73,
+	0x20, 0x62, 0x6c, 0x6f, 0x63, 0x6b, 0x0a, 0x19, 0x00, 0xff, 0xff, 0xff,
+	0xd2, 0x50, 0x62, 0x6c, 0x6f, 0x63, 0x6b,
+}
+
+func lz4Data() []byte {
+	return bytes.Repeat([]byte("lz4 compressed zfs block\n"), 41)[:1024]
+}
+
+func TestLZ4Decompress(t *testing.T) {
+	padded := append(append([]byte{}, lz4Block...), make([]byte, 512-len(lz4Block))...)
+
+	overrun := append([]byte{}, lz4Block...)
+	binary.BigEndian.PutUint32(overrun, uint32(len(lz4Block)))
+
+	tests := map[string]struct {
+		Src  []byte
+		Size int
+		Fail bool
+	}{
+		"block":     {Src: lz4Block, Size: 1024},
+		"padded":    {Src: padded, Size: 1024},
+		"too long":  {Src: lz4Block, Size: 1025, Fail: true},
+		"too short": {Src: lz4Block, Size: 1023, Fail: true},
+		"overrun":   {Src: overrun, Size: 1024, Fail: true},
+		"truncated": {Src: lz4Block[:20], Size: 1024, Fail: true},
+		"no header": {Src: lz4Block[:3], Size: 1024, Fail: true},
+	}
+
+	for name, test := range tests {
+		test := test
+		t.Run(name, func(t *testing.T) {
+			dst := make([]byte, test.Size)
+			_, err := zfs.CompressionLZ4.Decompress(dst, test.Src)
+			if test.Fail {
+				if err == nil {
+					t.Fatal("decompressed without error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(dst, lz4Data()) {
+				t.Fatalf("decompressed %q", dst)
+			}
+		})
+	}
+}
+
+func TestLZ4Compress(t *testing.T) {
+	data := compressibleData(64 << 10)
+
+	buf := make([]byte, len(data))
+	n, err := zfs.CompressionLZ4.Compress(buf, data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if size := binary.BigEndian.Uint32(buf); int(size) != n-4 {
+		t.Fatalf("header records %d bytes; compressed to %d", size, n-4)
+	}
+
+	dst := make([]byte, len(data))
+	if _, err := zfs.CompressionLZ4.Decompress(dst, buf[:n]); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(dst, data) {
+		t.Fatal("data changed in a round trip")
+	}
+
+	// the compressor refuses to grow a block.
+	if n, err := zfs.CompressionLZ4.Compress(make([]byte, 8), data); err == nil {
+		t.Fatalf("compressed %d bytes into %d", len(data), n)
+	}
+}
@@ -0,0 +1,124 @@
+// Copyright 2018 Ayan George.
+// All rights reserved.  Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package zfs
+
+import "fmt"
+
+// LZJB is a Lempel-Ziv variant.  Each group of eight items is preceded by a
+// byte whose bits, low bit first, tell whether the item is a literal byte or a
+// two byte copy of earlier output.  A copy holds a 6-bit length and a 10-bit
+// distance back into the output.
+const (
+	lzjbMatchBits  = 6
+	lzjbMatchMin   = 3
+	lzjbMatchMax   = 1<<lzjbMatchBits + lzjbMatchMin - 1
+	lzjbOffsetMask = 1<<(16-lzjbMatchBits) - 1
+	lzjbLempelSize = 1024
+)
+
+// lzjbDecompress fills dst from src, the LZJB compressed form of dst.
+func lzjbDecompress(dst []byte, src []byte) (int, error) {
+	var copymap byte
+	copymask := 1 << 7
+
+	s, d := 0, 0
+	for d < len(dst) {
+		if copymask <<= 1; copymask == 1<<8 {
+			if s >= len(src) {
+				return d, fmt.Errorf("lzjb input ends after %d of %d bytes", d, len(dst))
+			}
+			copymask = 1
+			copymap = src[s]
+			s++
+		}
+
+		if int(copymap)&copymask == 0 {
+			if s >= len(src) {
+				return d, fmt.Errorf("lzjb input ends after %d of %d bytes", d, len(dst))
+			}
+			dst[d] = src[s]
+			s, d = s+1, d+1
+			continue
+		}
+
+		if s+2 > len(src) {
+			return d, fmt.Errorf("lzjb input ends after %d of %d bytes", d, len(dst))
+		}
+		mlen := int(src[s]>>(8-lzjbMatchBits)) + lzjbMatchMin
+		offset := (int(src[s])<<8 | int(src[s+1])) & lzjbOffsetMask
+		s += 2
+
+		cpy := d - offset
+		if offset == 0 || cpy < 0 {
+			return d, fmt.Errorf("lzjb copy from %d bytes back at output byte %d", offset, d)
+		}
+
+		// copies may overlap the bytes they produce.
+		for ; mlen > 0 && d < len(dst); mlen-- {
+			dst[d] = dst[cpy]
+			d, cpy = d+1, cpy+1
+		}
+	}
+
+	return d, nil
+}
+
+// lzjbCompress compresses src into dst and returns the number of bytes used.
+// It fails if the compressed form does not fit in dst.
+func lzjbCompress(dst []byte, src []byte) (int, error) {
+	var lempel [lzjbLempelSize]uint16
+
+	copymap := 0
+	copymask := 1 << 7
+
+	s, d := 0, 0
+	for s < len(src) {
+		if copymask <<= 1; copymask == 1<<8 {
+			// leave room for a full group of copies.
+			if d >= len(dst)-1-2*8 {
+				return 0, fmt.Errorf("%d bytes do not compress into %d", len(src), len(dst))
+			}
+			copymask = 1
+			copymap = d
+			dst[d] = 0
+			d++
+		}
+
+		if s > len(src)-lzjbMatchMax {
+			dst[d] = src[s]
+			s, d = s+1, d+1
+			continue
+		}
+
+		hash := int(src[s])<<16 + int(src[s+1])<<8 + int(src[s+2])
+		hash += hash >> 9
+		hash += hash >> 5
+		hp := &lempel[hash&(lzjbLempelSize-1)]
+
+		offset := (s - int(*hp)) & lzjbOffsetMask
+		*hp = uint16(s)
+		cpy := s - offset
+
+		if cpy >= 0 && cpy != s && src[s] == src[cpy] && src[s+1] == src[cpy+1] && src[s+2] == src[cpy+2] {
+			dst[copymap] |= byte(copymask)
+
+			mlen := lzjbMatchMin
+			for ; mlen < lzjbMatchMax; mlen++ {
+				if src[s+mlen] != src[cpy+mlen] {
+					break
+				}
+			}
+
+			dst[d] = byte((mlen-lzjbMatchMin)<<(8-lzjbMatchBits) | offset>>8)
+			dst[d+1] = byte(offset)
+			s, d = s+mlen, d+2
+		} else {
+			dst[d] = src[s]
+			s, d = s+1, d+1
+		}
+	}
+
+	return d, nil
+}
@@ -0,0 +1,105 @@
+// Copyright 2018 Ayan George.
+// All rights reserved.  Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package zfs_test
+
+import (
+	"bytes"
+	"math/rand"
+	"testing"
+
+	"github.com/ayang64/ztool/zfs"
+)
+
+func TestLZJBDecompress(t *testing.T) {
+	tests := map[string]struct {
+		Src      []byte
+		Size     int
+		Expected []byte
+		Fail     bool
+	}{
+		"literals": {
+			Src:      []byte{0x00, 'z', 'f', 's'},
+			Size:     3,
+			Expected: []byte("zfs"),
+		},
+		"overlapping copy": {
+			// a literal followed by a copy of 9 bytes from 1 back.
+			Src:      []byte{0x02, 'a', 0x18, 0x01},
+			Size:     10,
+			Expected: []byte("aaaaaaaaaa"),
+		},
+		"second group": {
+			Src:      []byte{0x00, '0', '1', '2', '3', '4', '5', '6', '7', 0x01, 0x00, 0x08},
+			Size:     11,
+			Expected: []byte("01234567012"),
+		},
+		"copy before start": {Src: []byte{0x02, 'a', 0x00, 0x02}, Size: 4, Fail: true},
+		"truncated copy":    {Src: []byte{0x02, 'a', 0x18}, Size: 10, Fail: true},
+		"truncated":         {Src: []byte{0x00, 'a'}, Size: 2, Fail: true},
+	}
+
+	for name, test := range tests {
+		test := test
+		t.Run(name, func(t *testing.T) {
+			dst := make([]byte, test.Size)
+			_, err := zfs.CompressionLZJB.Decompress(dst, test.Src)
+			if test.Fail {
+				if err == nil {
+					t.Fatalf("decompressed %v without error", dst)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(dst, test.Expected) {
+				t.Fatalf("decompressed %q; expected %q", dst, test.Expected)
+			}
+		})
+	}
+}
+
+func TestLZJBCompress(t *testing.T) {
+	random := make([]byte, 4096)
+	rand.New(rand.NewSource(1)).Read(random)
+
+	tests := map[string]struct {
+		Data   []byte
+		Shrink bool // the data must compress.
+	}{
+		"empty":    {Data: []byte{}},
+		"short":    {Data: []byte("zfs")},
+		"zeros":    {Data: make([]byte, 128<<10), Shrink: true},
+		"text":     {Data: compressibleData(32 << 10), Shrink: true},
+		"odd size": {Data: compressibleData(1001), Shrink: true},
+	}
+
+	for name, test := range tests {
+		test := test
+		t.Run(name, func(t *testing.T) {
+			buf := make([]byte, len(test.Data)+64)
+			n, err := zfs.CompressionLZJB.Compress(buf, test.Data)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if test.Shrink && n >= len(test.Data) {
+				t.Fatalf("compressed %d bytes to %d", len(test.Data), n)
+			}
+
+			dst := make([]byte, len(test.Data))
+			if _, err := zfs.CompressionLZJB.Decompress(dst, buf[:n]); err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(dst, test.Data) {
+				t.Fatal("data changed in a round trip")
+			}
+		})
+	}
+
+	// random data does not fit in less space than it started with.
+	if n, err := zfs.CompressionLZJB.Compress(make([]byte, len(random)), random); err == nil {
+		t.Fatalf("compressed random data to %d bytes", n)
+	}
+}
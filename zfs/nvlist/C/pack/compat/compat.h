@@ -0,0 +1,21 @@
+/* Solaris types and macros the vendored sources expect. */
+#include <stdint.h>
+#include <stdarg.h>
+#include <stddef.h>
+#include <sys/types.h>
+typedef unsigned int uint_t;
+typedef unsigned char uchar_t;
+typedef unsigned long ulong_t;
+typedef long long hrtime_t;
+typedef long long longlong_t;
+typedef unsigned long long u_longlong_t;
+typedef enum { B_FALSE = 0, B_TRUE = 1 } boolean_t;
+typedef va_list __va_list;
+#define KM_SLEEP 0
+#define KM_NOSLEEP 1
+#include <endian.h>
+#define _LITTLE_ENDIAN LITTLE_ENDIAN
+#define P2ROUNDUP(x, a) (-(-(x) & -(a)))
+#define IS_P2ALIGNED(v, a) ((((uintptr_t)(v)) & ((uintptr_t)(a) - 1)) == 0)
+#define EOVERFLOW 75
+#define hrt2ts(a,b) ((void)0)
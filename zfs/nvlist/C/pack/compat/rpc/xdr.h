@@ -0,0 +1,16 @@
+/*
+ * libtirpc's xdr_control() is a statement rather than an expression and it
+ * has no struct xdr_bytesrec.  Both are only used when unpacking, which the
+ * driver never does.
+ */
+#include_next <rpc/xdr.h>
+
+#undef xdr_control
+
+struct xdr_bytesrec {
+	bool_t xc_is_last_record;
+	size_t xc_num_avail;
+};
+
+#define	XDR_GET_BYTES_AVAIL	1
+#define	xdr_control(xdrs, req, info)	(0)
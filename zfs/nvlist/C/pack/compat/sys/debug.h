@@ -0,0 +1,7 @@
+#include <assert.h>
+#define ASSERT(x) assert(x)
+#define ASSERT3U(a,op,b) assert((a) op (b))
+#define ASSERT3P(a,op,b) assert((a) op (b))
+#define ASSERT0(a) assert((a)==0)
+#define VERIFY0(a) assert((a)==0)
+#define VERIFY(a) assert(a)
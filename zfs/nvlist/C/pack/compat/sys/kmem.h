@@ -0,0 +1 @@
+/* nothing needed from <sys/kmem.h> in userland. */
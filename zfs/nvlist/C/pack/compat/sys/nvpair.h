@@ -0,0 +1,2 @@
+/* the vendored header lives in zfs/nvlist/C. */
+#include "../../../nvpair.h"
@@ -0,0 +1 @@
+/* nothing needed from <sys/sunddi.h> in userland. */
@@ -0,0 +1 @@
+/* nothing needed from <sys/sysmacros.h> in userland. */
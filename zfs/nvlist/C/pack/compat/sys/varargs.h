@@ -0,0 +1 @@
+/* nothing needed from <sys/varargs.h> in userland. */
@@ -0,0 +1,87 @@
+/*
+ * pack writes allTypes from nvlist_test.go to stdout, packed with
+ * nvlist_pack(..., NV_ENCODE_XDR, 0).  Pairs are added sorted by name, which
+ * is the order Marshal encodes a map in.
+ */
+
+#include <stdio.h>
+#include <stdlib.h>
+#include <string.h>
+#include <sys/nvpair.h>
+
+static int sys_init(nv_alloc_t *nva, __va_list ap) { return 0; }
+static void *sys_alloc(nv_alloc_t *nva, size_t n) { return calloc(1, n); }
+static void sys_free(nv_alloc_t *nva, void *p, size_t n) { free(p); }
+static const nv_alloc_ops_t sys_ops = { sys_init, NULL, sys_alloc, sys_free, NULL };
+static nv_alloc_t sys_nva = { &sys_ops, NULL };
+nv_alloc_t *nv_alloc_nosleep = &sys_nva;
+nv_alloc_t *nv_alloc_sleep = &sys_nva;
+
+static nvlist_t *newlist(void) {
+	nvlist_t *l;
+	if (nvlist_alloc(&l, NV_UNIQUE_NAME, 0) != 0) abort();
+	return l;
+}
+
+#define CK(x) do { if ((x) != 0) { fprintf(stderr, "%s failed\n", #x); exit(1); } } while (0)
+
+int main(int argc, char **argv) {
+	nvlist_t *l = newlist();
+	boolean_t bools[] = { B_TRUE, B_FALSE, B_TRUE };
+	uchar_t bytes[] = { 1, 2, 3, 4, 5, 6 };
+	int8_t i8s[] = { -1, 0, 1 };
+	int16_t i16s[] = { -1, 0, 1 };
+	uint16_t u16s[] = { 0xffff, 0, 1 };
+	int32_t i32s[] = { -1, 0, 1 };
+	uint32_t u32s[] = { 0xffffffff, 0, 1 };
+	int64_t i64s[] = { -1, 0, 1 };
+	uint64_t u64s[] = { ~0ULL, 0, 1 };
+	char *strs[] = { "a", "bc", "def", "ghij", "" };
+
+	nvlist_t *sub = newlist();
+	CK(nvlist_add_int8_array(sub, "empty int8s", NULL, 0));
+	CK(nvlist_add_uint64(sub, "guid", 42));
+	CK(nvlist_add_string(sub, "path", "/dev/ada0"));
+
+	nvlist_t *a0 = newlist(), *a1 = newlist(), *c0 = newlist();
+	CK(nvlist_add_uint64(a0, "id", 0));
+	CK(nvlist_add_uint64(c0, "id", 2));
+	CK(nvlist_add_nvlist_array(a1, "children", &c0, 1));
+	CK(nvlist_add_uint64(a1, "id", 1));
+	nvlist_t *arr[] = { a0, a1 };
+	nvlist_t *empty = newlist();
+
+	/* pairs are added sorted by name as the Go encoder writes a List. */
+	CK(nvlist_add_boolean_value(l, "bool", B_TRUE));
+	CK(nvlist_add_boolean_array(l, "bool array", bools, 3));
+	CK(nvlist_add_byte_array(l, "byte array", bytes, 6));
+	CK(nvlist_add_nvlist(l, "empty nvlist", empty));
+	CK(nvlist_add_string(l, "empty string", ""));
+	CK(nvlist_add_uint64_array(l, "empty uint64s", NULL, 0));
+	CK(nvlist_add_hrtime(l, "hrtime", 123456789));
+	CK(nvlist_add_int16(l, "int16", -300));
+	CK(nvlist_add_int16_array(l, "int16 array", i16s, 3));
+	CK(nvlist_add_int32(l, "int32", -70000));
+	CK(nvlist_add_int32_array(l, "int32 array", i32s, 3));
+	CK(nvlist_add_int64(l, "int64", -(1LL << 40)));
+	CK(nvlist_add_int64_array(l, "int64 array", i64s, 3));
+	CK(nvlist_add_int8(l, "int8", -2));
+	CK(nvlist_add_int8_array(l, "int8 array", i8s, 3));
+	CK(nvlist_add_nvlist(l, "nvlist", sub));
+	CK(nvlist_add_nvlist_array(l, "nvlist array", arr, 2));
+	CK(nvlist_add_string(l, "string", "hello, world"));
+	CK(nvlist_add_string_array(l, "string array", strs, 5));
+	CK(nvlist_add_uint16(l, "uint16", 0xfff0));
+	CK(nvlist_add_uint16_array(l, "uint16 array", u16s, 3));
+	CK(nvlist_add_uint32(l, "uint32", 0xfffffff0));
+	CK(nvlist_add_uint32_array(l, "uint32 array", u32s, 3));
+	CK(nvlist_add_uint64(l, "uint64", ~0ULL));
+	CK(nvlist_add_uint64_array(l, "uint64 array", u64s, 3));
+	CK(nvlist_add_uint8(l, "uint8", 0xfe));
+
+	char *buf = NULL;
+	size_t len = 0;
+	CK(nvlist_pack(l, &buf, &len, NV_ENCODE_XDR, 0));
+	fwrite(buf, 1, len, stdout);
+	return 0;
+}
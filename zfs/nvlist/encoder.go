@@ -5,36 +5,406 @@
 package nvlist
 
 import (
+	"bytes"
 	"encoding/binary"
+	"fmt"
 	"io"
+	"reflect"
+	"time"
 )
 
+// Encoder writes nvlists in the same layout the C library's nvlist_pack()
+// produces.
 type Encoder struct {
-	bo binary.ByteOrder
-	w  io.Writer
+	bo       binary.ByteOrder
+	w        io.Writer
+	encoding Encoding
+
+	// slack is the number of bytes the C library counts in the encoded
+	// size of the XDR list being written but does not write.
+	slack int
 }
 
-func NewEncoder(w io.Writer, bo binary.ByteOrder) *Encoder {
-	return &Encoder{
-		bo: bo,
-		w:  w,
+// WithEncoding selects the encoding written by the Encoder.  The default is
+// EncodingXDR.
+func WithEncoding(enc Encoding) func(*Encoder) {
+	return func(e *Encoder) {
+		e.encoding = enc
 	}
 }
 
+// NewEncoder returns an Encoder that writes to w.  bo is the byte order of
+// the host recorded in the nvlist header.  As XDR requires, XDR values are
+// always written in big endian byte order while native values are written in
+// byte order bo.
+func NewEncoder(w io.Writer, bo binary.ByteOrder, opts ...func(*Encoder)) *Encoder {
+	rc := &Encoder{
+		bo:       bo,
+		w:        w,
+		encoding: EncodingXDR,
+	}
+
+	for _, opt := range opts {
+		opt(rc)
+	}
+
+	return rc
+}
+
+// EncodeString writes s to w as an XDR string; a 4 byte length followed by
+// the bytes of the string padded to a multiple of four bytes.
 func (e *Encoder) EncodeString(w io.Writer, s string) error {
-	l := int32(len(s))
-	binary.Write(e.w, e.bo, l)
-	binary.Write(e.w, e.bo, s)
-	return nil
-}
-
-func (e *Encoder) Encode(w io.Writer, m map[string]interface{}) {
-	/*
-		for k := range m {
-			switch v := m[k].(type) {
-			case uint64:
-			case string:
+	if err := binary.Write(w, binary.BigEndian, int32(len(s))); err != nil {
+		return err
+	}
+
+	str := make([]byte, align4(int32(len(s))))
+	copy(str, s)
+
+	_, err := w.Write(str)
+	return err
+}
+
+// Encode writes v, a List, OrderedList or *OrderedList, including the nvlist
+// header, to the encoder's writer.  OrderedList values are written as
+// EncodeOrdered writes them.  List does not record the order or the types of
+// the pairs it was read from so its pairs are written sorted by name with the
+// types TypeOf chooses; use ReadOrdered to re-encode a list unchanged.
+func (e *Encoder) Encode(v interface{}) error {
+	switch l := v.(type) {
+	case List:
+		o := l.Ordered()
+		return e.EncodeOrdered(&o)
+	case OrderedList:
+		return e.EncodeOrdered(&l)
+	case *OrderedList:
+		return e.EncodeOrdered(l)
+	default:
+		return fmt.Errorf("cannot encode %T as an nvlist", v)
+	}
+}
+
+// EncodeOrdered writes o, including the nvlist header, to the encoder's
+// writer.  Pairs are written in order with their recorded types and each list
+// keeps its version and flags.
+func (e *Encoder) EncodeOrdered(o *OrderedList) error {
+	if e.encoding != EncodingXDR && e.encoding != EncodingNative {
+		return fmt.Errorf("unknown nvlist encoding %s", e.encoding)
+	}
+
+	hdr := Header{
+		Encoding: e.encoding,
+		Endian:   EndianOf(e.bo),
+	}
+
+	if err := binary.Write(e.w, binary.BigEndian, hdr); err != nil {
+		return err
+	}
+
+	if e.encoding == EncodingNative {
+		return e.encodeNativeList(e.w, *o, true)
+	}
+
+	// nvlist_pack() returns a buffer of the size it counted so the bytes it
+	// did not write follow the list as zeros.
+	e.slack = 0
+	if err := e.encodeList(e.w, *o); err != nil {
+		return err
+	}
+
+	_, err := e.w.Write(make([]byte, e.slack))
+	return err
+}
+
+func (e *Encoder) encodeList(w io.Writer, o OrderedList) error {
+	if err := binary.Write(w, binary.BigEndian, o.ListMeta); err != nil {
+		return err
+	}
+
+	for _, p := range o.Pairs {
+		if p.Type == Unknown {
+			return fmt.Errorf("cannot encode %q; unsupported type %T", p.Name, p.Value)
+		}
+
+		if err := e.encodePair(w, p); err != nil {
+			return err
+		}
+	}
+
+	// two zeros mark the end of the list.
+	return binary.Write(w, binary.BigEndian, [2]int32{})
+}
+
+// encodePair writes a single name/value pair.  The pair is prefixed with its
+// encoded size and the size the C library would need to hold it in memory.
+func (e *Encoder) encodePair(w io.Writer, p NVPair) error {
+	nelem, err := elements(p.Type, p.Value)
+	if err != nil {
+		return fmt.Errorf("%q: %w", p.Name, err)
+	}
+
+	b := &bytes.Buffer{}
+	e.EncodeString(b, p.Name)
+	binary.Write(b, binary.BigEndian, p.Type)
+	binary.Write(b, binary.BigEndian, int32(nelem))
+
+	slack := e.slack
+	if err := e.encodeValue(b, p.Type, nelem, p.Value); err != nil {
+		return fmt.Errorf("%q: %w", p.Name, err)
+	}
+
+	// the C library counts the element count of an empty array in the
+	// encoded size even though it does not write it.  The encoded size of
+	// an embedded list includes what it counted for its own pairs.
+	if nelem == 0 && counted(p.Type) {
+		e.slack += 4
+	}
+	size := 8 + b.Len() + e.slack - slack
+
+	pair := Pair{
+		Size:        int32(size),
+		DecodedSize: int32(align8(16+len(p.Name)+1) + align8(valueSize(p.Type, nelem, p.Value))),
+	}
+
+	if err := binary.Write(w, binary.BigEndian, pair); err != nil {
+		return err
+	}
+
+	_, err = w.Write(b.Bytes())
+	return err
+}
+
+func (e *Encoder) encodeValue(w io.Writer, t Type, nelem int, v interface{}) error {
+	put := func(v interface{}) error { return binary.Write(w, binary.BigEndian, v) }
+
+	// arrays are preceded by their length except when empty in which case
+	// nothing at all is written.
+	array := func(v interface{}) error {
+		if nelem == 0 {
+			return nil
+		}
+		if err := put(int32(nelem)); err != nil {
+			return err
+		}
+		return put(v)
+	}
+
+	widen := func(n int, f func(i int) int32) []int32 {
+		rc := make([]int32, n)
+		for i := range rc {
+			rc[i] = f(i)
+		}
+		return rc
+	}
+
+	switch t {
+	case Boolean:
+		return nil
+	case Byte, Uint8:
+		// the C library writes bytes with xdr_char() which sign extends
+		// them.
+		return put(int32(int8(v.(uint8))))
+	case Int8:
+		return put(int32(v.(int8)))
+	case Int16:
+		return put(int32(v.(int16)))
+	case Uint16:
+		return put(int32(v.(uint16)))
+	case Int32, Uint32, Int64, Uint64:
+		return put(v)
+	case BooleanValue:
+		if v.(bool) {
+			return put(int32(1))
+		}
+		return put(int32(0))
+	case HRTime:
+		return put(int64(v.(time.Duration)))
+	case String:
+		return e.EncodeString(w, v.(string))
+	case ByteArray:
+		b := make([]byte, align4(int32(nelem)))
+		copy(b, v.([]byte))
+		_, err := w.Write(b)
+		return err
+	case Int8Array:
+		a := v.([]int8)
+		return array(widen(len(a), func(i int) int32 { return int32(a[i]) }))
+	case Uint8Array:
+		a := v.([]uint8)
+		return array(widen(len(a), func(i int) int32 { return int32(int8(a[i])) }))
+	case Int16Array:
+		a := v.([]int16)
+		return array(widen(len(a), func(i int) int32 { return int32(a[i]) }))
+	case Uint16Array:
+		a := v.([]uint16)
+		return array(widen(len(a), func(i int) int32 { return int32(a[i]) }))
+	case BooleanArray:
+		a := v.([]bool)
+		return array(widen(len(a), func(i int) int32 {
+			if a[i] {
+				return 1
+			}
+			return 0
+		}))
+	case Int32Array, Uint32Array, Int64Array, Uint64Array:
+		return array(v)
+	case StringArray:
+		for _, s := range v.([]string) {
+			if err := e.EncodeString(w, s); err != nil {
+				return err
+			}
+		}
+		return nil
+	case NVList:
+		return e.encodeList(w, asOrdered(v))
+	case NVListArray:
+		for _, l := range asOrderedArray(v) {
+			if err := e.encodeList(w, l); err != nil {
+				return err
 			}
 		}
-	*/
+		return nil
+	default:
+		return fmt.Errorf("cannot encode type %s", t)
+	}
+}
+
+// TypeOf returns the nvlist type used to encode v or Unknown if v cannot be
+// encoded.  Types that share a Go representation are encoded as the wider or
+// more common nvlist type; uint8 values become Uint8, []uint8 values become
+// ByteArray and bool values become BooleanValue.
+func TypeOf(v interface{}) Type {
+	switch v.(type) {
+	case bool:
+		return BooleanValue
+	case uint8:
+		return Uint8
+	case int8:
+		return Int8
+	case int16:
+		return Int16
+	case uint16:
+		return Uint16
+	case int32:
+		return Int32
+	case uint32:
+		return Uint32
+	case int64:
+		return Int64
+	case uint64:
+		return Uint64
+	case time.Duration:
+		return HRTime
+	case string:
+		return String
+	case []byte:
+		return ByteArray
+	case []int8:
+		return Int8Array
+	case []int16:
+		return Int16Array
+	case []uint16:
+		return Uint16Array
+	case []int32:
+		return Int32Array
+	case []uint32:
+		return Uint32Array
+	case []int64:
+		return Int64Array
+	case []uint64:
+		return Uint64Array
+	case []bool:
+		return BooleanArray
+	case []string:
+		return StringArray
+	case List, OrderedList:
+		return NVList
+	case []List, []OrderedList:
+		return NVListArray
+	default:
+		return Unknown
+	}
+}
+
+// elements returns the number of elements recorded for a value of type t.
+func elements(t Type, v interface{}) (int, error) {
+	switch t {
+	case Boolean:
+		return 0, nil
+	case ByteArray, Int8Array, Uint8Array, Int16Array, Uint16Array,
+		Int32Array, Uint32Array, Int64Array, Uint64Array, BooleanArray,
+		StringArray, NVListArray:
+		if t == Uint8Array {
+			// []uint8 and []byte are the same type in go.
+			t = ByteArray
+		}
+		if TypeOf(v) != t {
+			return 0, fmt.Errorf("cannot encode %T as %s", v, t)
+		}
+		return reflect.ValueOf(v).Len(), nil
+	default:
+		if vt := TypeOf(v); vt != t && !(t == Byte && vt == Uint8) {
+			return 0, fmt.Errorf("cannot encode %T as %s", v, t)
+		}
+		return 1, nil
+	}
+}
+
+// counted reports whether arrays of type t are written with an element count.
+func counted(t Type) bool {
+	switch t {
+	case Int8Array, Uint8Array, Int16Array, Uint16Array, Int32Array,
+		Uint32Array, Int64Array, Uint64Array, BooleanArray:
+		return true
+	default:
+		return false
+	}
+}
+
+// valueSize returns the number of bytes the C library uses to hold a value in
+// memory.  It is recorded in each encoded pair.
+func valueSize(t Type, nelem int, v interface{}) int {
+	switch t {
+	case Boolean:
+		return 0
+	case Byte, Int8, Uint8:
+		return 1
+	case Int16, Uint16:
+		return 2
+	case BooleanValue, Int32, Uint32:
+		return 4
+	case Int64, Uint64, HRTime:
+		return 8
+	case String:
+		return len(v.(string)) + 1
+	case ByteArray, Int8Array, Uint8Array:
+		return nelem
+	case Int16Array, Uint16Array:
+		return nelem * 2
+	case BooleanArray, Int32Array, Uint32Array:
+		return nelem * 4
+	case Int64Array, Uint64Array:
+		return nelem * 8
+	case StringArray:
+		// an array of pointers followed by the strings themselves.
+		size := nelem * 8
+		for _, s := range v.([]string) {
+			size += len(s) + 1
+		}
+		return size
+	case NVList:
+		return nvlistSize
+	case NVListArray:
+		return nelem * (8 + nvlistSize)
+	default:
+		return 0
+	}
+}
+
+// nvlistSize is the size of an nvlist_t in memory.
+const nvlistSize = 24
+
+// align8 rounds i up to the next multiple of eight.
+func align8(i int) int {
+	return (i + 7) &^ 7
 }
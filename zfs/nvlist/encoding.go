@@ -9,11 +9,12 @@ import "fmt"
 // Encoding is a type that stores the name/valure pair encoding type.  This can
 // be either  EncodingNative or EncodingXDR.
 //
-// At the moment, the only encoding seen in the wild is XDR.
+// XDR is used on disk in vdev labels and packed nvlist objects while the
+// native encoding is used by zpool.cache.
 type Encoding uint8
 
 const (
-	// EncodingNative denotes the native nvlist value encoding. NEVER SEEN IN USE
+	// EncodingNative denotes the native nvlist value encoding.
 	EncodingNative = Encoding(iota)
 	// EncodingXDR denotes nvlist value encoding.  Default.
 	EncodingXDR
@@ -9,16 +9,25 @@ import (
 	"fmt"
 )
 
-// Endian encodes an byte ordervalue in a nvlist header.
+// Endian encodes an byte ordervalue in a nvlist header.  Like the C library,
+// it records whether the host that packed the list was little endian.
 type Endian int8
 
 const (
-	// LittleEndian denotes a little-endian byte order.
-	LittleEndian = Endian(iota) // 0
 	// BigEndian denotes a big-endian byte order.
-	BigEndian // 1
+	BigEndian = Endian(iota) // 0
+	// LittleEndian denotes a little-endian byte order.
+	LittleEndian // 1
 )
 
+// EndianOf returns the Endian value that corresponds with a binary.ByteOrder.
+func EndianOf(bo binary.ByteOrder) Endian {
+	if bo == binary.BigEndian {
+		return BigEndian
+	}
+	return LittleEndian
+}
+
 // ByteOrder returns a binary.ByteOrder that corresponds with the Endian value.
 func (e Endian) ByteOrder() binary.ByteOrder {
 	switch e {
@@ -4,8 +4,14 @@
 
 package nvlist
 
+import "sort"
+
+// List is a decoded nvlist.
 type List map[string]interface{}
 
+// Find searches l and its embedded lists, depth first, for a pair named target
+// and returns the first value found.  Names such as "guid" appear at several
+// levels so Lookup should be used to address a particular pair.
 func (l List) Find(target string) (interface{}, bool) {
 	// search submaps for value.
 	for k := range l {
@@ -28,3 +34,13 @@ func (l List) Find(target string) (interface{}, bool) {
 
 	return nil, false
 }
+
+// names returns the names in l sorted so that encoding l is repeatable.
+func (l List) names() []string {
+	rc := make([]string, 0, len(l))
+	for name := range l {
+		rc = append(rc, name)
+	}
+	sort.Strings(rc)
+	return rc
+}
@@ -9,3 +9,12 @@ type ListMeta struct {
 	Version int32
 	Flags   uint32
 }
+
+// ListMeta flags.
+const (
+	// UniqueName denotes a list in which no two pairs share a name.
+	UniqueName = 0x1
+	// UniqueNameType denotes a list in which no two pairs share a name and
+	// type.
+	UniqueNameType = 0x2
+)
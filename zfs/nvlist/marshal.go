@@ -0,0 +1,475 @@
+// Copyright 2018 Ayan George.
+// All rights reserved.  Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package nvlist
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"math"
+	"reflect"
+	"strings"
+	"time"
+)
+
+// An UnmarshalTypeError describes a pair whose value cannot be stored in a Go
+// value of a specific type.
+type UnmarshalTypeError struct {
+	Name  string       // path to the pair such as "vdev_tree/children[0]/guid"
+	Value interface{}  // decoded value of the pair
+	Type  reflect.Type // type of the Go value it could not be stored in
+}
+
+func (e *UnmarshalTypeError) Error() string {
+	return fmt.Sprintf("cannot store %T value of %q in Go value of type %s", e.Value, e.Name, e.Type)
+}
+
+// An InvalidUnmarshalError describes an invalid argument passed to Unmarshal
+// or UnmarshalList.  The argument must be a non-nil pointer.
+type InvalidUnmarshalError struct {
+	Type reflect.Type
+}
+
+func (e *InvalidUnmarshalError) Error() string {
+	if e.Type == nil {
+		return "cannot unmarshal into nil"
+	}
+
+	if e.Type.Kind() != reflect.Ptr {
+		return fmt.Sprintf("cannot unmarshal into non-pointer %s", e.Type)
+	}
+
+	return fmt.Sprintf("cannot unmarshal into nil %s", e.Type)
+}
+
+// An UnsupportedTypeError is returned by Marshal and MarshalList when asked to
+// encode a Go value that has no nvlist representation.
+type UnsupportedTypeError struct {
+	Name string // path to the value; empty for the value passed to Marshal
+	Type reflect.Type
+}
+
+func (e *UnsupportedTypeError) Error() string {
+	if e.Name == "" {
+		return fmt.Sprintf("cannot marshal unsupported type %s", e.Type)
+	}
+	return fmt.Sprintf("cannot marshal %q; unsupported type %s", e.Name, e.Type)
+}
+
+// Unmarshal decodes the encoded nvlist in data, including its header, and
+// stores the result in the value pointed to by v.  See UnmarshalList for how
+// pairs are stored in Go values.
+func Unmarshal(data []byte, v interface{}) error {
+	l, err := Read(bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+
+	return UnmarshalList(l, v)
+}
+
+// UnmarshalList stores the pairs of l in the value pointed to by v.
+//
+// Struct fields are matched to pairs by the name in their `nvlist:"name"` tag
+// or, without a tag, by the name of the field.  Fields tagged `nvlist:"-"` are
+// ignored as are pairs that have no matching field.  Fields whose pairs are
+// missing are left alone.  The fields of untagged embedded structs are
+// matched as if they belonged to the outer struct.
+//
+// Integers may be stored in any integer type that can hold their value.
+// NVList values may be stored in structs, List or maps with string keys and
+// NVListArray values in slices of those.  Anything may be stored in an empty
+// interface.  Values that cannot be stored produce an *UnmarshalTypeError.
+func UnmarshalList(l List, v interface{}) error {
+	rv := reflect.ValueOf(v)
+	if rv.Kind() != reflect.Ptr || rv.IsNil() {
+		return &InvalidUnmarshalError{Type: reflect.TypeOf(v)}
+	}
+
+	return unmarshalValue("", l, rv.Elem())
+}
+
+// Marshal returns the XDR encoding of v as written by an Encoder using a
+// little endian header.  See MarshalList for how Go values are converted.
+func Marshal(v interface{}) ([]byte, error) {
+	l, err := MarshalList(v)
+	if err != nil {
+		return nil, err
+	}
+
+	b := &bytes.Buffer{}
+	if err := NewEncoder(b, binary.LittleEndian).Encode(l); err != nil {
+		return nil, err
+	}
+
+	return b.Bytes(), nil
+}
+
+// MarshalList converts v, a struct, a map with string keys or a pointer to
+// either, to a List.
+//
+// Struct fields are named as described for UnmarshalList.  The "omitempty"
+// option, as in `nvlist:"guid,omitempty"`, leaves out fields with zero
+// values.  Nil pointers are always left out.
+//
+// int and uint values are stored as Int64 and Uint64 pairs, bool values as
+// BooleanValue pairs, time.Duration values as HRTime pairs and nested structs
+// and maps as NVList and NVListArray pairs.
+func MarshalList(v interface{}) (List, error) {
+	rv := reflect.ValueOf(v)
+	for rv.Kind() == reflect.Ptr && !rv.IsNil() {
+		rv = rv.Elem()
+	}
+
+	switch {
+	case rv.Kind() == reflect.Struct:
+		return marshalStruct("", rv)
+	case rv.Kind() == reflect.Map && rv.Type().Key().Kind() == reflect.String:
+		return marshalMap("", rv)
+	default:
+		return nil, &UnsupportedTypeError{Type: reflect.TypeOf(v)}
+	}
+}
+
+var (
+	durationType = reflect.TypeOf(time.Duration(0))
+	listType     = reflect.TypeOf(List{})
+)
+
+// field describes a struct field that maps to a pair.
+type field struct {
+	name      string
+	index     []int
+	omitempty bool
+}
+
+// fields returns the exported fields of struct type t that map to pairs.  The
+// fields of untagged embedded structs are treated as fields of t unless t has
+// a field with the same name.
+func fields(t reflect.Type) []field {
+	rc := []field{}
+	embedded := []field{}
+
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		tag := f.Tag.Get("nvlist")
+
+		if f.Anonymous && tag == "" && f.Type.Kind() == reflect.Struct {
+			for _, ef := range fields(f.Type) {
+				ef.index = append([]int{i}, ef.index...)
+				embedded = append(embedded, ef)
+			}
+			continue
+		}
+
+		if f.PkgPath != "" || tag == "-" {
+			continue
+		}
+
+		name, opts := tag, ""
+		if i := strings.Index(tag, ","); i >= 0 {
+			name, opts = tag[:i], tag[i+1:]
+		}
+
+		if name == "" {
+			name = f.Name
+		}
+
+		rc = append(rc, field{
+			name:      name,
+			index:     []int{i},
+			omitempty: opts == "omitempty",
+		})
+	}
+
+	for _, ef := range embedded {
+		shadowed := false
+		for _, f := range rc {
+			if f.name == ef.name {
+				shadowed = true
+				break
+			}
+		}
+
+		if !shadowed {
+			rc = append(rc, ef)
+		}
+	}
+
+	return rc
+}
+
+// join returns the path of the pair named name within the list at path.
+func join(path string, name string) string {
+	if path == "" {
+		return name
+	}
+	return path + "/" + name
+}
+
+func unmarshalValue(path string, src interface{}, dst reflect.Value) error {
+	mismatch := &UnmarshalTypeError{Name: path, Value: src, Type: dst.Type()}
+
+	// pointers are allocated as needed.
+	if dst.Kind() == reflect.Ptr {
+		if dst.IsNil() {
+			dst.Set(reflect.New(dst.Type().Elem()))
+		}
+		return unmarshalValue(path, src, dst.Elem())
+	}
+
+	if src == nil {
+		return mismatch
+	}
+
+	sv := reflect.ValueOf(src)
+
+	// interfaces, List, []List and anything else that already has the right
+	// type are stored as is.
+	if sv.Type().AssignableTo(dst.Type()) {
+		dst.Set(sv)
+		return nil
+	}
+
+	switch dst.Kind() {
+	case reflect.Bool:
+		if sv.Kind() != reflect.Bool {
+			return mismatch
+		}
+		dst.SetBool(sv.Bool())
+
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		var i int64
+		switch sv.Kind() {
+		case reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+			i = sv.Int()
+		case reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+			if sv.Uint() > math.MaxInt64 {
+				return mismatch
+			}
+			i = int64(sv.Uint())
+		default:
+			return mismatch
+		}
+		if dst.OverflowInt(i) {
+			return mismatch
+		}
+		dst.SetInt(i)
+
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		var u uint64
+		switch sv.Kind() {
+		case reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+			if sv.Int() < 0 {
+				return mismatch
+			}
+			u = uint64(sv.Int())
+		case reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+			u = sv.Uint()
+		default:
+			return mismatch
+		}
+		if dst.OverflowUint(u) {
+			return mismatch
+		}
+		dst.SetUint(u)
+
+	case reflect.String:
+		if sv.Kind() != reflect.String {
+			return mismatch
+		}
+		dst.SetString(sv.String())
+
+	case reflect.Struct:
+		l, ok := src.(List)
+		if !ok {
+			return mismatch
+		}
+		for _, f := range fields(dst.Type()) {
+			v, found := l[f.name]
+			if !found {
+				continue
+			}
+			if err := unmarshalValue(join(path, f.name), v, dst.FieldByIndex(f.index)); err != nil {
+				return err
+			}
+		}
+
+	case reflect.Map:
+		l, ok := src.(List)
+		if !ok || dst.Type().Key().Kind() != reflect.String {
+			return mismatch
+		}
+		if dst.IsNil() {
+			dst.Set(reflect.MakeMapWithSize(dst.Type(), len(l)))
+		}
+		for _, name := range l.names() {
+			v := reflect.New(dst.Type().Elem()).Elem()
+			if err := unmarshalValue(join(path, name), l[name], v); err != nil {
+				return err
+			}
+			dst.SetMapIndex(reflect.ValueOf(name).Convert(dst.Type().Key()), v)
+		}
+
+	case reflect.Slice:
+		if sv.Kind() != reflect.Slice {
+			return mismatch
+		}
+		s := reflect.MakeSlice(dst.Type(), sv.Len(), sv.Len())
+		for i := 0; i < sv.Len(); i++ {
+			if err := unmarshalValue(fmt.Sprintf("%s[%d]", path, i), sv.Index(i).Interface(), s.Index(i)); err != nil {
+				return err
+			}
+		}
+		dst.Set(s)
+
+	default:
+		return mismatch
+	}
+
+	return nil
+}
+
+func marshalStruct(path string, v reflect.Value) (List, error) {
+	rc := List{}
+	for _, f := range fields(v.Type()) {
+		fv := v.FieldByIndex(f.index)
+		if f.omitempty && fv.IsZero() {
+			continue
+		}
+
+		mv, err := marshalValue(join(path, f.name), fv)
+		if err != nil {
+			return nil, err
+		}
+
+		if mv != nil {
+			rc[f.name] = mv
+		}
+	}
+	return rc, nil
+}
+
+func marshalMap(path string, v reflect.Value) (List, error) {
+	rc := List{}
+	for _, k := range v.MapKeys() {
+		name := k.String()
+		mv, err := marshalValue(join(path, name), v.MapIndex(k))
+		if err != nil {
+			return nil, err
+		}
+
+		if mv != nil {
+			rc[name] = mv
+		}
+	}
+	return rc, nil
+}
+
+// marshalValue returns the value stored in a pair for v or nil if v is a nil
+// pointer or interface and should be left out.
+func marshalValue(path string, v reflect.Value) (interface{}, error) {
+	switch v.Kind() {
+	case reflect.Ptr, reflect.Interface:
+		if v.IsNil() {
+			return nil, nil
+		}
+		return marshalValue(path, v.Elem())
+	case reflect.Bool:
+		return v.Bool(), nil
+	case reflect.Int8:
+		return int8(v.Int()), nil
+	case reflect.Int16:
+		return int16(v.Int()), nil
+	case reflect.Int32:
+		return int32(v.Int()), nil
+	case reflect.Int, reflect.Int64:
+		if v.Type() == durationType {
+			return time.Duration(v.Int()), nil
+		}
+		return v.Int(), nil
+	case reflect.Uint8:
+		return uint8(v.Uint()), nil
+	case reflect.Uint16:
+		return uint16(v.Uint()), nil
+	case reflect.Uint32:
+		return uint32(v.Uint()), nil
+	case reflect.Uint, reflect.Uint64, reflect.Uintptr:
+		return v.Uint(), nil
+	case reflect.String:
+		return v.String(), nil
+	case reflect.Struct:
+		return marshalStruct(path, v)
+	case reflect.Map:
+		if v.Type().Key().Kind() != reflect.String {
+			break
+		}
+		return marshalMap(path, v)
+	case reflect.Slice:
+		if v.Type().Elem().Kind() == reflect.Uint8 {
+			return append([]byte{}, v.Bytes()...), nil
+		}
+
+		et := marshaledType(v.Type().Elem())
+		if et == nil || TypeOf(reflect.Zero(reflect.SliceOf(et)).Interface()) == Unknown {
+			break
+		}
+
+		rc := reflect.MakeSlice(reflect.SliceOf(et), v.Len(), v.Len())
+		for i := 0; i < v.Len(); i++ {
+			name := fmt.Sprintf("%s[%d]", path, i)
+			mv, err := marshalValue(name, v.Index(i))
+			if err != nil {
+				return nil, err
+			}
+			if mv == nil {
+				return nil, &UnsupportedTypeError{Name: name, Type: v.Index(i).Type()}
+			}
+			rc.Index(i).Set(reflect.ValueOf(mv))
+		}
+		return rc.Interface(), nil
+	}
+
+	return nil, &UnsupportedTypeError{Name: path, Type: v.Type()}
+}
+
+// marshaledType returns the Go type marshalValue produces for values of type
+// t or nil if there is none.
+func marshaledType(t reflect.Type) reflect.Type {
+	switch t.Kind() {
+	case reflect.Ptr:
+		return marshaledType(t.Elem())
+	case reflect.Bool:
+		return reflect.TypeOf(false)
+	case reflect.Int8:
+		return reflect.TypeOf(int8(0))
+	case reflect.Int16:
+		return reflect.TypeOf(int16(0))
+	case reflect.Int32:
+		return reflect.TypeOf(int32(0))
+	case reflect.Int, reflect.Int64:
+		if t == durationType {
+			return durationType
+		}
+		return reflect.TypeOf(int64(0))
+	case reflect.Uint8:
+		return reflect.TypeOf(uint8(0))
+	case reflect.Uint16:
+		return reflect.TypeOf(uint16(0))
+	case reflect.Uint32:
+		return reflect.TypeOf(uint32(0))
+	case reflect.Uint, reflect.Uint64, reflect.Uintptr:
+		return reflect.TypeOf(uint64(0))
+	case reflect.String:
+		return reflect.TypeOf("")
+	case reflect.Struct:
+		return listType
+	case reflect.Map:
+		if t.Key().Kind() == reflect.String {
+			return listType
+		}
+	}
+	return nil
+}
@@ -0,0 +1,172 @@
+// Copyright 2018 Ayan George.
+// All rights reserved.  Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package nvlist_test
+
+import (
+	"errors"
+	"os"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/ayang64/ztool/zfs/nvlist"
+)
+
+type vdev struct {
+	Type     string  `nvlist:"type"`
+	ID       uint64  `nvlist:"id"`
+	GUID     uint64  `nvlist:"guid"`
+	Path     string  `nvlist:"path,omitempty"`
+	AShift   uint    `nvlist:"ashift,omitempty"`
+	Children []vdev  `nvlist:"children,omitempty"`
+	Ignored  string  `nvlist:"-"`
+	Spare    *uint64 `nvlist:"is_spare"`
+}
+
+type poolConfig struct {
+	Name     string          `nvlist:"name"`
+	Version  uint64          `nvlist:"version"`
+	State    int             `nvlist:"state"`
+	HostID   uint32          `nvlist:"hostid"`
+	Features map[string]bool `nvlist:"features_for_read"`
+	VdevTree vdev            `nvlist:"vdev_tree"`
+	Errata   int64           `nvlist:"errata,omitempty"`
+	Uptime   time.Duration   `nvlist:"uptime,omitempty"`
+	Extra    interface{}     `nvlist:"com.delphix:has_per_vdev_zaps"`
+}
+
+func TestUnmarshalPoolCache(t *testing.T) {
+	b, err := os.ReadFile("../../test-data/zpool.cache")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var pools map[string]poolConfig
+	if err := nvlist.Unmarshal(b, &pools); err != nil {
+		t.Fatal(err)
+	}
+
+	pool, found := pools["jbod"]
+	if !found {
+		t.Fatalf("pool jbod not found in %v", pools)
+	}
+
+	expected := poolConfig{
+		Name:     "jbod",
+		Version:  5000,
+		State:    0,
+		HostID:   2660936816,
+		Features: map[string]bool{"com.delphix:embedded_data": true, "com.delphix:hole_birth": true},
+		VdevTree: vdev{
+			Type: "root",
+			GUID: 4372009680223183607,
+			Children: []vdev{
+				{Type: "disk", GUID: 8939574674627541862, Path: "/dev/ada5", AShift: 12},
+			},
+		},
+		Extra: true,
+	}
+
+	if !reflect.DeepEqual(pool, expected) {
+		t.Fatalf("unmarshaled\n%#v\nexpected\n%#v", pool, expected)
+	}
+}
+
+func TestMarshalRoundTrip(t *testing.T) {
+	spare := uint64(1)
+	in := poolConfig{
+		Name:     "tank",
+		Version:  5000,
+		State:    -1,
+		Features: map[string]bool{"org.open-zfs:large_blocks": true},
+		VdevTree: vdev{
+			Type: "mirror",
+			Children: []vdev{
+				{Type: "disk", ID: 0, Path: "/dev/ada0", Ignored: "lost"},
+				{Type: "disk", ID: 1, Path: "/dev/ada1", Spare: &spare},
+			},
+		},
+		Uptime: 3 * time.Second,
+		Extra:  "anything",
+	}
+
+	l, err := nvlist.MarshalList(in)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tree := l["vdev_tree"].(nvlist.List)
+	if _, found := tree["path"]; found {
+		t.Errorf("empty path was not omitted")
+	}
+
+	children := tree["children"].([]nvlist.List)
+	if _, found := children[0]["is_spare"]; found {
+		t.Errorf("nil pointer was not omitted")
+	}
+
+	if v := l["state"]; v != int64(-1) {
+		t.Errorf("state marshaled as %#v; expected int64(-1)", v)
+	}
+
+	b, err := nvlist.Marshal(&in)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var out poolConfig
+	if err := nvlist.Unmarshal(b, &out); err != nil {
+		t.Fatal(err)
+	}
+
+	in.VdevTree.Children[0].Ignored = ""
+	if !reflect.DeepEqual(out, in) {
+		t.Fatalf("round trip produced\n%#v\nexpected\n%#v", out, in)
+	}
+}
+
+func TestUnmarshalErrors(t *testing.T) {
+	tests := map[string]struct {
+		List nvlist.List
+		Path string
+	}{
+		"string into integer": {List: nvlist.List{"version": "5000"}, Path: "version"},
+		"overflow":            {List: nvlist.List{"hostid": uint64(1 << 40)}, Path: "hostid"},
+		"negative into uint":  {List: nvlist.List{"vdev_tree": nvlist.List{"ashift": int64(-1)}}, Path: "vdev_tree/ashift"},
+		"list into string": {
+			List: nvlist.List{"vdev_tree": nvlist.List{"children": []nvlist.List{{}, {"path": nvlist.List{}}}}},
+			Path: "vdev_tree/children[1]/path",
+		},
+		"scalar into struct": {List: nvlist.List{"vdev_tree": uint64(0)}, Path: "vdev_tree"},
+	}
+
+	for name, test := range tests {
+		test := test
+		t.Run(name, func(t *testing.T) {
+			var pc poolConfig
+			err := nvlist.UnmarshalList(test.List, &pc)
+
+			var te *nvlist.UnmarshalTypeError
+			if !errors.As(err, &te) {
+				t.Fatalf("got error %v; expected *UnmarshalTypeError", err)
+			}
+
+			if te.Name != test.Path {
+				t.Errorf("error names %q; expected %q", te.Name, test.Path)
+			}
+		})
+	}
+
+	var pc poolConfig
+	var ie *nvlist.InvalidUnmarshalError
+	if err := nvlist.UnmarshalList(nvlist.List{}, pc); !errors.As(err, &ie) {
+		t.Errorf("unmarshaling into a non-pointer returned %v", err)
+	}
+
+	var ue *nvlist.UnsupportedTypeError
+	if _, err := nvlist.MarshalList(struct{ C chan int }{}); !errors.As(err, &ue) {
+		t.Errorf("marshaling a channel returned %v", err)
+	}
+}
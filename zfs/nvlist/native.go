@@ -0,0 +1,422 @@
+// Copyright 2018 Ayan George.
+// All rights reserved.  Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package nvlist
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"time"
+)
+
+// A natively encoded nvlist is a copy of the C library's in-memory structures
+// in the byte order of the host that packed it.  This is the encoding used by
+// zpool.cache and by ioctl payloads.
+//
+// The list begins with the version and flags of the top level list.  Each
+// pair is an nvpair_t followed by its name and value, each padded to a
+// multiple of eight bytes.  The pairs of embedded lists directly follow the
+// pair that holds them and every list ends with four zero bytes.
+
+// typedef struct nvpair {
+// 	int32_t nvp_size;	/* size of this nvpair */
+// 	int16_t	nvp_name_sz;	/* length of name string */
+// 	int16_t	nvp_reserve;	/* not used */
+// 	int32_t	nvp_value_elem;	/* number of elements for array types */
+// 	data_type_t nvp_type;	/* type of value */
+// 	/* name string */
+// 	/* aligned ptr array for string arrays */
+// 	/* aligned array of data for value */
+// } nvpair_t;
+
+// nativePair is the fixed size portion of a natively encoded pair.
+type nativePair struct {
+	Size     int32
+	NameSize int16 // includes the terminating NUL
+	Reserve  int16
+	Elements int32
+	Type     Type
+}
+
+// typedef struct nvlist {
+// 	int32_t		nvl_version;
+// 	uint32_t	nvl_nvflag;	/* persistent flags */
+// 	uint64_t	nvl_priv;	/* ptr to private data if not packed */
+// 	uint32_t	nvl_flag;
+// 	int32_t		nvl_pad;	/* currently not used, for alignment */
+// } nvlist_t;
+
+// nativeList is the nvlist_t stored in the value of NVList and NVListArray
+// pairs.
+type nativeList struct {
+	Version int32
+	Flags   uint32
+	Priv    uint64 // always zero when packed
+	Flag    uint32
+	Pad     int32
+}
+
+func (s *Scanner) nextNative() bool {
+	var size int32
+	if s.err = binary.Read(s.r, s.byteOrder, &size); s.err != nil {
+		return false
+	}
+
+	// four zero bytes mark the end of the list.
+	if size == 0 {
+		return false
+	}
+
+	if size < 16 {
+		s.err = fmt.Errorf("invalid nvpair size %d", size)
+		return false
+	}
+
+	if l, ok := s.r.(interface{ Len() int }); ok && int(size)-4 > l.Len() {
+		s.err = fmt.Errorf("nvpair size %d exceeds the %d bytes remaining", size, l.Len())
+		return false
+	}
+
+	record := make([]byte, size)
+	s.byteOrder.PutUint32(record, uint32(size))
+	if _, s.err = io.ReadFull(s.r, record[4:]); s.err != nil {
+		return false
+	}
+
+	var nvp nativePair
+	binary.Read(bytes.NewReader(record), s.byteOrder, &nvp)
+
+	voff := align8(16 + int(nvp.NameSize))
+	if nvp.NameSize < 1 || voff > len(record) {
+		s.err = fmt.Errorf("invalid nvpair name size %d", nvp.NameSize)
+		return false
+	}
+
+	s.pair = Pair{Size: size, DecodedSize: size}
+	s.fieldName = string(record[16 : 16+nvp.NameSize-1])
+	s.fieldType = nvp.Type
+	s.fieldNumElements = int(nvp.Elements)
+
+	f := s.nativeValueFunc(bytes.NewReader(record[voff:]), nvp.Type)
+	if f == nil {
+		s.err = fmt.Errorf("no conversion function for type %q", nvp.Type)
+		return false
+	}
+
+	if s.value, s.err = f(); s.err != nil {
+		return false
+	}
+
+	return true
+}
+
+// nativeValueFunc returns a function that decodes a native value of type t
+// from r.  Values are stored at their natural size and arrays are stored
+// without a length.
+func (s *Scanner) nativeValueFunc(r *bytes.Reader, t Type) func() (interface{}, error) {
+	read := func(v interface{}) error { return binary.Read(r, s.byteOrder, v) }
+
+	// array allocates a slice of n elements of the given size by calling mk
+	// and fills it from r.
+	array := func(size int, mk func(n int) interface{}) func() (interface{}, error) {
+		return func() (interface{}, error) {
+			if err := s.checkElements(r, size); err != nil {
+				return nil, err
+			}
+			v := mk(s.NumElements())
+			if err := read(v); err != nil {
+				return nil, err
+			}
+			return v, nil
+		}
+	}
+
+	scalar := func(f func() interface{}) func() (interface{}, error) {
+		return func() (interface{}, error) {
+			v := f()
+			if err := read(v); err != nil {
+				return nil, err
+			}
+			return deref(v), nil
+		}
+	}
+
+	// lists returns the nvlist_t headers of n embedded lists.
+	lists := func(n int) ([]nativeList, error) {
+		rc := make([]nativeList, n)
+		if err := read(rc); err != nil {
+			return nil, err
+		}
+		return rc, nil
+	}
+
+	m := map[Type]func() (interface{}, error){
+		Boolean: func() (interface{}, error) { return true, nil },
+		Byte:    scalar(func() interface{} { return new(byte) }),
+		Int16:   scalar(func() interface{} { return new(int16) }),
+		Uint16:  scalar(func() interface{} { return new(uint16) }),
+		Int32:   scalar(func() interface{} { return new(int32) }),
+		Uint32:  scalar(func() interface{} { return new(uint32) }),
+		Int64:   scalar(func() interface{} { return new(int64) }),
+		Uint64:  scalar(func() interface{} { return new(uint64) }),
+		String: func() (interface{}, error) {
+			return cstring(r)
+		},
+		ByteArray:   array(1, func(n int) interface{} { return make([]byte, n) }),
+		Int16Array:  array(2, func(n int) interface{} { return make([]int16, n) }),
+		Uint16Array: array(2, func(n int) interface{} { return make([]uint16, n) }),
+		Int32Array:  array(4, func(n int) interface{} { return make([]int32, n) }),
+		Uint32Array: array(4, func(n int) interface{} { return make([]uint32, n) }),
+		Int64Array:  array(8, func(n int) interface{} { return make([]int64, n) }),
+		Uint64Array: array(8, func(n int) interface{} { return make([]uint64, n) }),
+		StringArray: func() (interface{}, error) {
+			// skip the array of pointers that precedes the strings.
+			if err := s.checkElements(r, 8); err != nil {
+				return nil, err
+			}
+			r.Seek(int64(s.NumElements())*8, io.SeekCurrent)
+
+			rc := make([]string, 0, s.NumElements())
+			for i := 0; i < s.NumElements(); i++ {
+				str, err := cstring(r)
+				if err != nil {
+					return nil, err
+				}
+				rc = append(rc, str)
+			}
+			return rc, nil
+		},
+		HRTime: func() (interface{}, error) {
+			var v int64
+			if err := read(&v); err != nil {
+				return nil, err
+			}
+			return time.Duration(v), nil
+		},
+		NVList: func() (interface{}, error) {
+			nvl, err := lists(1)
+			if err != nil {
+				return nil, err
+			}
+			return s.collect(s.nativeSubScanner(nvl[0]))
+		},
+		NVListArray: func() (interface{}, error) {
+			// skip the array of pointers that precedes the lists.
+			if err := s.checkElements(r, 8+nvlistSize); err != nil {
+				return nil, err
+			}
+			r.Seek(int64(s.NumElements())*8, io.SeekCurrent)
+
+			nvl, err := lists(s.NumElements())
+			if err != nil {
+				return nil, err
+			}
+
+			return s.collectArray(len(nvl), func(i int) *Scanner { return s.nativeSubScanner(nvl[i]) })
+		},
+		BooleanValue: func() (interface{}, error) {
+			var v int32
+			if err := read(&v); err != nil {
+				return nil, err
+			}
+			return v != 0, nil
+		},
+		Int8:  scalar(func() interface{} { return new(int8) }),
+		Uint8: scalar(func() interface{} { return new(uint8) }),
+		BooleanArray: func() (interface{}, error) {
+			if err := s.checkElements(r, 4); err != nil {
+				return nil, err
+			}
+			v := make([]int32, s.NumElements())
+			if err := read(v); err != nil {
+				return nil, err
+			}
+			rc := make([]bool, len(v))
+			for i := range v {
+				rc[i] = v[i] != 0
+			}
+			return rc, nil
+		},
+		Int8Array:  array(1, func(n int) interface{} { return make([]int8, n) }),
+		Uint8Array: array(1, func(n int) interface{} { return make([]uint8, n) }),
+	}
+
+	if f, found := m[t]; found {
+		return f
+	}
+
+	return nil
+}
+
+// nativeSubScanner returns a scanner for the pairs of an embedded list.  They
+// follow the pair that holds the list in the stream.
+func (s *Scanner) nativeSubScanner(nvl nativeList) *Scanner {
+	return &Scanner{
+		r:         s.r,
+		byteOrder: s.byteOrder,
+		header:    s.header,
+		list:      ListMeta{Version: nvl.Version, Flags: nvl.Flags},
+	}
+}
+
+// deref returns the value pointed to by one of the pointers allocated by the
+// scalar decoders.
+func deref(v interface{}) interface{} {
+	switch p := v.(type) {
+	case *uint8:
+		return *p
+	case *int8:
+		return *p
+	case *int16:
+		return *p
+	case *uint16:
+		return *p
+	case *int32:
+		return *p
+	case *uint32:
+		return *p
+	case *int64:
+		return *p
+	case *uint64:
+		return *p
+	default:
+		return v
+	}
+}
+
+// cstring reads a NUL terminated string.
+func cstring(r *bytes.Reader) (string, error) {
+	b := []byte{}
+	for {
+		c, err := r.ReadByte()
+		if err != nil {
+			return "", fmt.Errorf("unterminated string; %w", err)
+		}
+		if c == 0 {
+			return string(b), nil
+		}
+		b = append(b, c)
+	}
+}
+
+// encodeNativeList writes the pairs of o followed by the end of list marker.
+// The version and flags of embedded lists are stored in the pair that holds
+// them so they are only written for the top level list.
+func (e *Encoder) encodeNativeList(w io.Writer, o OrderedList, top bool) error {
+	if top {
+		if err := binary.Write(w, e.bo, o.ListMeta); err != nil {
+			return err
+		}
+	}
+
+	for _, p := range o.Pairs {
+		if p.Type == Unknown {
+			return fmt.Errorf("cannot encode %q; unsupported type %T", p.Name, p.Value)
+		}
+
+		if err := e.encodeNativePair(w, p); err != nil {
+			return err
+		}
+	}
+
+	return binary.Write(w, e.bo, int32(0))
+}
+
+func (e *Encoder) encodeNativePair(w io.Writer, p NVPair) error {
+	nelem, err := elements(p.Type, p.Value)
+	if err != nil {
+		return fmt.Errorf("%q: %w", p.Name, err)
+	}
+
+	value := &bytes.Buffer{}
+	if err := e.encodeNativeValue(value, p.Type, nelem, p.Value); err != nil {
+		return fmt.Errorf("%q: %w", p.Name, err)
+	}
+
+	nvp := nativePair{
+		Size:     int32(align8(16+len(p.Name)+1) + align8(value.Len())),
+		NameSize: int16(len(p.Name) + 1),
+		Elements: int32(nelem),
+		Type:     p.Type,
+	}
+
+	b := &bytes.Buffer{}
+	binary.Write(b, e.bo, nvp)
+	b.WriteString(p.Name)
+	b.Write(make([]byte, align8(b.Len()+1)-b.Len()))
+	b.Write(value.Bytes())
+	b.Write(make([]byte, int(nvp.Size)-b.Len()))
+
+	if _, err := w.Write(b.Bytes()); err != nil {
+		return err
+	}
+
+	// embedded lists follow the pair.
+	switch p.Type {
+	case NVList:
+		return e.encodeNativeList(w, asOrdered(p.Value), false)
+	case NVListArray:
+		for _, l := range asOrderedArray(p.Value) {
+			if err := e.encodeNativeList(w, l, false); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+func (e *Encoder) encodeNativeValue(w *bytes.Buffer, t Type, nelem int, v interface{}) error {
+	put := func(v interface{}) error { return binary.Write(w, e.bo, v) }
+
+	switch t {
+	case Boolean:
+		return nil
+	case BooleanValue:
+		if v.(bool) {
+			return put(int32(1))
+		}
+		return put(int32(0))
+	case BooleanArray:
+		a := v.([]bool)
+		b := make([]int32, len(a))
+		for i := range a {
+			if a[i] {
+				b[i] = 1
+			}
+		}
+		return put(b)
+	case HRTime:
+		return put(int64(v.(time.Duration)))
+	case String:
+		w.WriteString(v.(string))
+		return w.WriteByte(0)
+	case StringArray:
+		w.Write(make([]byte, nelem*8))
+		for _, s := range v.([]string) {
+			w.WriteString(s)
+			w.WriteByte(0)
+		}
+		return nil
+	case NVList:
+		l := asOrdered(v)
+		return put(nativeList{Version: l.Version, Flags: l.Flags})
+	case NVListArray:
+		w.Write(make([]byte, nelem*8))
+		for _, l := range asOrderedArray(v) {
+			if err := put(nativeList{Version: l.Version, Flags: l.Flags}); err != nil {
+				return err
+			}
+		}
+		return nil
+	case Byte, Int8, Uint8, Int16, Uint16, Int32, Uint32, Int64, Uint64,
+		ByteArray, Int8Array, Uint8Array, Int16Array, Uint16Array,
+		Int32Array, Uint32Array, Int64Array, Uint64Array:
+		return put(v)
+	default:
+		return fmt.Errorf("cannot encode type %s", t)
+	}
+}
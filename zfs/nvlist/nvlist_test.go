@@ -6,10 +6,13 @@ package nvlist_test
 
 import (
 	"bytes"
+	"encoding/binary"
 	"encoding/json"
 	"io"
 	"os"
+	"reflect"
 	"testing"
+	"time"
 
 	"github.com/ayang64/ztool/zfs/nvlist"
 )
@@ -69,11 +72,11 @@ func TestLooper(t *testing.T) {
 			Opts:   []func() func(*nvlist.Scanner) error{},
 		},
 		{
-			Name:   "",
-			Path:   "../../../test-data/zpool.cache",
+			Name:   "zpool.cache",
+			Path:   "../../test-data/zpool.cache",
 			Offset: 0x0,
 			Size:   0x184c,
-			Opts:   []func() func(*nvlist.Scanner) error{nvlist.WithoutHeader},
+			Opts:   []func() func(*nvlist.Scanner) error{},
 		},
 	}
 
@@ -108,3 +111,577 @@ func TestLooper(t *testing.T) {
 		})
 	}
 }
+
+// xdrBuffer builds XDR encoded nvlists for tests.
+type xdrBuffer struct {
+	bytes.Buffer
+}
+
+func (x *xdrBuffer) put(v ...interface{}) *xdrBuffer {
+	for i := range v {
+		binary.Write(x, binary.BigEndian, v[i])
+	}
+	return x
+}
+
+func (x *xdrBuffer) str(s ...string) *xdrBuffer {
+	for i := range s {
+		x.put(int32(len(s[i])))
+		x.WriteString(s[i])
+		x.Write(make([]byte, (4-len(s[i])%4)%4))
+	}
+	return x
+}
+
+// pair appends a name/value pair with an already encoded value.  decoded is
+// the in-memory size of the pair recorded by the C library.
+func (x *xdrBuffer) pair(name string, t nvlist.Type, nelem int32, decoded int32, value []byte) *xdrBuffer {
+	n := (&xdrBuffer{}).str(name)
+	x.put(int32(8+n.Len()+8+len(value)), decoded)
+	x.Write(n.Bytes())
+	x.put(t, nelem)
+	x.Write(value)
+	return x
+}
+
+// emptyArray appends an empty array pair the way the C library writes it.  Its
+// encoded size counts the element count that is not written.
+func (x *xdrBuffer) emptyArray(name string, t nvlist.Type, decoded int32) *xdrBuffer {
+	n := (&xdrBuffer{}).str(name)
+	x.put(int32(8+n.Len()+8+4), decoded)
+	x.Write(n.Bytes())
+	x.put(t, int32(0))
+	return x
+}
+
+func xdr(v ...interface{}) []byte {
+	return (&xdrBuffer{}).put(v...).Bytes()
+}
+
+func TestReadTypes(t *testing.T) {
+	sub := (&xdrBuffer{}).put(int32(0), uint32(1)).
+		pair("a", nvlist.Uint64, 1, 32, xdr(uint64(1))).
+		put(int64(0)).Bytes()
+
+	empty := (&xdrBuffer{}).put(int32(0), uint32(1)).
+		emptyArray("a", nvlist.Uint64Array, 24).
+		put(int64(0)).Bytes()
+
+	tests := []struct {
+		Name     string
+		Type     nvlist.Type
+		NElem    int32
+		Value    []byte
+		Slack    int32 // bytes counted in the encoded size but not written.
+		Expected interface{}
+	}{
+		{Name: "boolean", Type: nvlist.Boolean, NElem: 0, Value: nil, Expected: true},
+		{Name: "byte", Type: nvlist.Byte, NElem: 1, Value: xdr(int32(0xfe)), Expected: byte(0xfe)},
+		{Name: "int16", Type: nvlist.Int16, NElem: 1, Value: xdr(int32(-2)), Expected: int16(-2)},
+		{Name: "uint16", Type: nvlist.Uint16, NElem: 1, Value: xdr(int32(0xfffe)), Expected: uint16(0xfffe)},
+		{Name: "int32", Type: nvlist.Int32, NElem: 1, Value: xdr(int32(-3)), Expected: int32(-3)},
+		{Name: "uint32", Type: nvlist.Uint32, NElem: 1, Value: xdr(uint32(0xfffffffd)), Expected: uint32(0xfffffffd)},
+		{Name: "int64", Type: nvlist.Int64, NElem: 1, Value: xdr(int64(-4)), Expected: int64(-4)},
+		{Name: "uint64", Type: nvlist.Uint64, NElem: 1, Value: xdr(uint64(1 << 63)), Expected: uint64(1 << 63)},
+		{Name: "string", Type: nvlist.String, NElem: 1, Value: (&xdrBuffer{}).str("hello").Bytes(), Expected: "hello"},
+		{Name: "byte array", Type: nvlist.ByteArray, NElem: 5, Value: []byte{1, 2, 3, 4, 5, 0, 0, 0}, Expected: []byte{1, 2, 3, 4, 5}},
+		{Name: "int16 array", Type: nvlist.Int16Array, NElem: 2, Value: xdr(int32(2), int32(-1), int32(7)), Expected: []int16{-1, 7}},
+		{Name: "uint16 array", Type: nvlist.Uint16Array, NElem: 2, Value: xdr(int32(2), int32(0xffff), int32(7)), Expected: []uint16{0xffff, 7}},
+		{Name: "int32 array", Type: nvlist.Int32Array, NElem: 2, Value: xdr(int32(2), int32(-1), int32(7)), Expected: []int32{-1, 7}},
+		{Name: "uint32 array", Type: nvlist.Uint32Array, NElem: 1, Value: xdr(int32(1), uint32(0xffffffff)), Expected: []uint32{0xffffffff}},
+		{Name: "int64 array", Type: nvlist.Int64Array, NElem: 2, Value: xdr(int32(2), int64(-1), int64(7)), Expected: []int64{-1, 7}},
+		{Name: "uint64 array", Type: nvlist.Uint64Array, NElem: 2, Value: xdr(int32(2), uint64(1<<63), uint64(7)), Expected: []uint64{1 << 63, 7}},
+		{Name: "empty uint64 array", Type: nvlist.Uint64Array, NElem: 0, Value: nil, Expected: []uint64{}},
+		{Name: "counted empty uint64 array", Type: nvlist.Uint64Array, NElem: 0, Value: nil, Slack: 4, Expected: []uint64{}},
+		{Name: "string array", Type: nvlist.StringArray, NElem: 3, Value: (&xdrBuffer{}).str("a", "", "bcdef").Bytes(), Expected: []string{"a", "", "bcdef"}},
+		{Name: "hrtime", Type: nvlist.HRTime, NElem: 1, Value: xdr(int64(1500)), Expected: 1500 * time.Nanosecond},
+		{Name: "nvlist", Type: nvlist.NVList, NElem: 1, Value: sub, Expected: nvlist.List{"a": uint64(1)}},
+		{Name: "nvlist with an empty array", Type: nvlist.NVList, NElem: 1, Value: empty, Slack: 4, Expected: nvlist.List{"a": []uint64{}}},
+		{Name: "nvlist array", Type: nvlist.NVListArray, NElem: 2, Value: append(append([]byte{}, sub...), sub...), Expected: []nvlist.List{{"a": uint64(1)}, {"a": uint64(1)}}},
+		{Name: "boolean value", Type: nvlist.BooleanValue, NElem: 1, Value: xdr(int32(1)), Expected: true},
+		{Name: "int8", Type: nvlist.Int8, NElem: 1, Value: xdr(int32(-5)), Expected: int8(-5)},
+		{Name: "uint8", Type: nvlist.Uint8, NElem: 1, Value: xdr(int32(0xfb)), Expected: uint8(0xfb)},
+		{Name: "sign extended uint8", Type: nvlist.Uint8, NElem: 1, Value: xdr(int32(-5)), Expected: uint8(0xfb)},
+		{Name: "boolean array", Type: nvlist.BooleanArray, NElem: 3, Value: xdr(int32(3), int32(1), int32(0), int32(1)), Expected: []bool{true, false, true}},
+		{Name: "int8 array", Type: nvlist.Int8Array, NElem: 2, Value: xdr(int32(2), int32(-1), int32(7)), Expected: []int8{-1, 7}},
+		{Name: "uint8 array", Type: nvlist.Uint8Array, NElem: 2, Value: xdr(int32(2), int32(0xff), int32(7)), Expected: []uint8{0xff, 7}},
+	}
+
+	for _, test := range tests {
+		test := test
+		t.Run(test.Name, func(t *testing.T) {
+			// put a pair after the one being tested to make sure we consume the
+			// right number of bytes.
+			x := &xdrBuffer{}
+			x.Write([]byte{1, 1, 0, 0})
+			x.put(int32(0), uint32(1))
+			x.pair("value", test.Type, test.NElem, 0, test.Value)
+			size := x.Bytes()[12:]
+			binary.BigEndian.PutUint32(size, binary.BigEndian.Uint32(size)+uint32(test.Slack))
+			x.pair("after", nvlist.String, 1, 0, (&xdrBuffer{}).str("sentinel").Bytes())
+			x.put(int64(0))
+
+			l, err := nvlist.Read(bytes.NewReader(x.Bytes()))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if !reflect.DeepEqual(l["value"], test.Expected) {
+				t.Errorf("decoded %#v; expected %#v", l["value"], test.Expected)
+			}
+
+			if l["after"] != "sentinel" {
+				t.Errorf("following pair decoded as %#v", l["after"])
+			}
+		})
+	}
+}
+
+func TestEncodeLayout(t *testing.T) {
+	// the sizes below were worked out by hand from nvs_xdr_nvp_size() and
+	// NVP_SIZE_CALC() in the C library.
+	sub := (&xdrBuffer{}).put(int32(0), uint32(1)).
+		pair("ashift", nvlist.Uint64, 1, 32, xdr(uint64(12))).
+		put(int64(0)).Bytes()
+
+	expected := &xdrBuffer{}
+	expected.Write([]byte{1, 1, 0, 0})
+	expected.put(int32(0), uint32(1))
+	expected.pair("name", nvlist.String, 1, 32, (&xdrBuffer{}).str("tank").Bytes())
+	expected.pair("vdev_tree", nvlist.NVList, 1, 56, sub)
+	expected.pair("version", nvlist.Uint64, 1, 32, xdr(uint64(5000)))
+	expected.put(int64(0))
+
+	b := &bytes.Buffer{}
+	err := nvlist.NewEncoder(b, binary.LittleEndian).Encode(nvlist.List{
+		"version":   uint64(5000),
+		"name":      "tank",
+		"vdev_tree": nvlist.List{"ashift": uint64(12)},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !bytes.Equal(b.Bytes(), expected.Bytes()) {
+		t.Fatalf("encoded\n%x\nexpected\n%x", b.Bytes(), expected.Bytes())
+	}
+}
+
+// allTypes holds a value of every type the encoder writes.
+var allTypes = nvlist.List{
+	"uint8":         uint8(0xfe),
+	"int8":          int8(-2),
+	"int16":         int16(-300),
+	"uint16":        uint16(0xfff0),
+	"int32":         int32(-70000),
+	"uint32":        uint32(0xfffffff0),
+	"int64":         int64(-1 << 40),
+	"uint64":        uint64(1<<64 - 1),
+	"hrtime":        time.Duration(123456789),
+	"bool":          true,
+	"string":        "hello, world",
+	"empty string":  "",
+	"byte array":    []byte{1, 2, 3, 4, 5, 6},
+	"int8 array":    []int8{-1, 0, 1},
+	"int16 array":   []int16{-1, 0, 1},
+	"uint16 array":  []uint16{0xffff, 0, 1},
+	"int32 array":   []int32{-1, 0, 1},
+	"uint32 array":  []uint32{0xffffffff, 0, 1},
+	"int64 array":   []int64{-1, 0, 1},
+	"uint64 array":  []uint64{1<<64 - 1, 0, 1},
+	"bool array":    []bool{true, false, true},
+	"string array":  []string{"a", "bc", "def", "ghij", ""},
+	"nvlist":        nvlist.List{"guid": uint64(42), "path": "/dev/ada0", "empty int8s": []int8{}},
+	"nvlist array":  []nvlist.List{{"id": uint64(0)}, {"id": uint64(1), "children": []nvlist.List{{"id": uint64(2)}}}},
+	"empty nvlist":  nvlist.List{},
+	"empty uint64s": []uint64{},
+}
+
+// TestEncodeLibnvpair compares the encoder with the C library.
+// test-data/nvlist.xdr holds allTypes, with its pairs added sorted by name, as
+// packed by nvlist_pack(..., NV_ENCODE_XDR, 0) from the library in C/ built
+// on a little endian amd64 host. Run make in C/pack to regenerate it.
+func TestEncodeLibnvpair(t *testing.T) {
+	packed, err := os.ReadFile("../../test-data/nvlist.xdr")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	b, err := nvlist.Marshal(allTypes)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !bytes.Equal(b, packed) {
+		t.Errorf("encoded\n%x\nexpected\n%x", b, packed)
+	}
+
+	l, err := nvlist.Read(bytes.NewReader(packed))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !reflect.DeepEqual(l, allTypes) {
+		t.Errorf("read %#v\nexpected %#v", l, allTypes)
+	}
+}
+
+func TestEncodeRoundTrip(t *testing.T) {
+	l := allTypes
+
+	tests := map[string]struct {
+		Encoding nvlist.Encoding
+		Order    binary.ByteOrder
+	}{
+		"xdr":                  {Encoding: nvlist.EncodingXDR, Order: binary.LittleEndian},
+		"native little endian": {Encoding: nvlist.EncodingNative, Order: binary.LittleEndian},
+		"native big endian":    {Encoding: nvlist.EncodingNative, Order: binary.BigEndian},
+	}
+
+	for name, test := range tests {
+		test := test
+		t.Run(name, func(t *testing.T) {
+			b := &bytes.Buffer{}
+			if err := nvlist.NewEncoder(b, test.Order, nvlist.WithEncoding(test.Encoding)).Encode(l); err != nil {
+				t.Fatal(err)
+			}
+
+			encoded := append([]byte{}, b.Bytes()...)
+
+			got, err := nvlist.Read(bytes.NewReader(encoded))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			for k := range l {
+				if !reflect.DeepEqual(got[k], l[k]) {
+					t.Errorf("%q decoded as %#v; expected %#v", k, got[k], l[k])
+				}
+			}
+
+			if len(got) != len(l) {
+				t.Errorf("decoded %d pairs; expected %d", len(got), len(l))
+			}
+
+			// encoding what we read must produce exactly the same bytes.
+			b.Reset()
+			if err := nvlist.NewEncoder(b, test.Order, nvlist.WithEncoding(test.Encoding)).Encode(got); err != nil {
+				t.Fatal(err)
+			}
+
+			if !bytes.Equal(b.Bytes(), encoded) {
+				t.Errorf("re-encoding changed the encoded list")
+			}
+		})
+	}
+}
+
+func TestReadNative(t *testing.T) {
+	// zpool.cache is packed with the native encoding on a little endian host.
+	b, err := os.ReadFile("../../test-data/zpool.cache")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	l, err := nvlist.Read(bytes.NewReader(b))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	pool, ok := l["jbod"].(nvlist.List)
+	if !ok {
+		t.Fatalf("jbod is %T; expected nvlist.List", l["jbod"])
+	}
+
+	expected := map[string]interface{}{
+		"name":                          "jbod",
+		"version":                       uint64(5000),
+		"hostname":                      "jade.ayan.net",
+		"pool_guid":                     uint64(4372009680223183607),
+		"com.delphix:has_per_vdev_zaps": true,
+	}
+
+	for k, v := range expected {
+		if !reflect.DeepEqual(pool[k], v) {
+			t.Errorf("%q is %#v; expected %#v", k, pool[k], v)
+		}
+	}
+
+	children, ok := pool["vdev_tree"].(nvlist.List)["children"].([]nvlist.List)
+	if !ok || len(children) != 1 {
+		t.Fatalf("vdev_tree children are %#v", pool["vdev_tree"].(nvlist.List)["children"])
+	}
+
+	if path := children[0]["path"]; path != "/dev/ada5" {
+		t.Errorf("vdev path is %#v; expected \"/dev/ada5\"", path)
+	}
+
+	if _, ok := l["zlocal"].(nvlist.List); !ok {
+		t.Errorf("zlocal is %T; expected nvlist.List", l["zlocal"])
+	}
+}
+
+func TestEncodeUnsupported(t *testing.T) {
+	err := nvlist.NewEncoder(io.Discard, binary.LittleEndian).Encode(nvlist.List{"int": 1})
+	if err == nil {
+		t.Fatalf("encoded an int without error")
+	}
+}
+
+func TestReadOrderedRoundTrip(t *testing.T) {
+	b, err := os.ReadFile("../../test-data/zpool.cache")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	o, err := nvlist.ReadOrdered(bytes.NewReader(b))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the pairs of each pool are not sorted so they must come back in file
+	// order.
+	names := []string{}
+	for _, p := range o.Pairs[0].Value.(nvlist.OrderedList).Pairs {
+		names = append(names, p.Name)
+	}
+
+	expected := []string{
+		"version", "name", "state", "txg", "pool_guid", "hostid", "hostname",
+		"com.delphix:has_per_vdev_zaps", "vdev_children", "vdev_tree", "features_for_read",
+	}
+
+	if !reflect.DeepEqual(names, expected) {
+		t.Errorf("read pairs %q; expected %q", names, expected)
+	}
+
+	out := &bytes.Buffer{}
+	if err := nvlist.NewEncoder(out, binary.LittleEndian, nvlist.WithEncoding(nvlist.EncodingNative)).Encode(o); err != nil {
+		t.Fatal(err)
+	}
+
+	if !bytes.Equal(out.Bytes(), b) {
+		t.Errorf("re-encoding zpool.cache produced different bytes")
+	}
+
+	l, err := nvlist.Read(bytes.NewReader(b))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !reflect.DeepEqual(o.List(), l) {
+		t.Errorf("List() does not match Read()")
+	}
+}
+
+func TestEncodeReadOrdered(t *testing.T) {
+	// a label's features_for_read holds Boolean pairs which decode to the
+	// same value as BooleanValue pairs.
+	features := (&xdrBuffer{}).put(int32(0), uint32(1)).
+		pair("com.delphix:hole_birth", nvlist.Boolean, 0, 40, nil).
+		pair("com.delphix:embedded_data", nvlist.Boolean, 0, 48, nil).
+		put(int64(0)).Bytes()
+
+	label := &xdrBuffer{}
+	label.Write([]byte{1, 1, 0, 0})
+	label.put(int32(0), uint32(1))
+	label.pair("version", nvlist.Uint64, 1, 32, xdr(uint64(5000)))
+	label.pair("name", nvlist.String, 1, 32, (&xdrBuffer{}).str("tank").Bytes())
+	label.pair("byte", nvlist.Byte, 1, 32, xdr(int32(-2)))
+	label.pair("uint8 array", nvlist.Uint8Array, 2, 40, xdr(int32(2), int32(-1), int32(7)))
+	label.pair("features_for_read", nvlist.NVList, 1, 64, features)
+	label.put(int64(0))
+
+	// the jbod pool configuration of zpool.cache is what its vdev labels
+	// hold.
+	cache, err := os.ReadFile("../../test-data/zpool.cache")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c, err := nvlist.ReadOrdered(bytes.NewReader(cache))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	jbod := &bytes.Buffer{}
+	if err := nvlist.NewEncoder(jbod, binary.LittleEndian).Encode(c.Pairs[0].Value); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := map[string]struct {
+		Encoded []byte
+	}{
+		"label": {Encoded: label.Bytes()},
+		"jbod":  {Encoded: jbod.Bytes()},
+	}
+
+	for name, test := range tests {
+		test := test
+		t.Run(name, func(t *testing.T) {
+			o, err := nvlist.ReadOrdered(bytes.NewReader(test.Encoded))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			features, _ := o.Value("features_for_read")
+			for _, p := range features.(nvlist.OrderedList).Pairs {
+				if p.Type != nvlist.Boolean {
+					t.Errorf("feature %q read as %s; expected %s", p.Name, p.Type, nvlist.Boolean)
+				}
+			}
+
+			b := &bytes.Buffer{}
+			if err := nvlist.NewEncoder(b, binary.LittleEndian).Encode(o); err != nil {
+				t.Fatal(err)
+			}
+
+			if !bytes.Equal(b.Bytes(), test.Encoded) {
+				t.Errorf("encoded\n%x\nexpected\n%x", b.Bytes(), test.Encoded)
+			}
+		})
+	}
+
+	if err := nvlist.NewEncoder(io.Discard, binary.LittleEndian).Encode(map[string]interface{}{}); err == nil {
+		t.Errorf("encoded a map that is not a List without error")
+	}
+}
+
+func TestOrderedListFlags(t *testing.T) {
+	pairs := []nvlist.NVPair{
+		{Name: "a", Type: nvlist.Uint64, Value: uint64(1)},
+		{Name: "b", Type: nvlist.String, Value: "b"},
+		{Name: "a", Type: nvlist.String, Value: "2"},
+		{Name: "a", Type: nvlist.Uint64, Value: uint64(3)},
+	}
+
+	tests := map[string]struct {
+		Flags uint32
+		Pairs []nvlist.NVPair
+		Err   bool
+	}{
+		"duplicates allowed":             {Flags: 0, Pairs: pairs},
+		"unique name":                    {Flags: nvlist.UniqueName, Pairs: pairs, Err: true},
+		"unique name and type":           {Flags: nvlist.UniqueNameType, Pairs: pairs, Err: true},
+		"unique name and type, distinct": {Flags: nvlist.UniqueNameType, Pairs: pairs[:3]},
+	}
+
+	for name, test := range tests {
+		test := test
+		t.Run(name, func(t *testing.T) {
+			// encode the duplicates without going through Add so the
+			// decoder sees every pair.
+			in := &nvlist.OrderedList{
+				ListMeta: nvlist.ListMeta{Flags: test.Flags},
+				Pairs:    test.Pairs,
+			}
+
+			for _, enc := range []nvlist.Encoding{nvlist.EncodingXDR, nvlist.EncodingNative} {
+				b := &bytes.Buffer{}
+				if err := nvlist.NewEncoder(b, binary.LittleEndian, nvlist.WithEncoding(enc)).EncodeOrdered(in); err != nil {
+					t.Fatal(err)
+				}
+				encoded := b.Bytes()
+
+				// like nvlist_unpack(), fail on pairs the flags forbid
+				// instead of dropping them.
+				if _, err := nvlist.Read(bytes.NewReader(encoded)); (err != nil) != test.Err {
+					t.Errorf("%s: Read() returned %v", enc, err)
+				}
+
+				o, err := nvlist.ReadOrdered(bytes.NewReader(encoded))
+				if test.Err {
+					if err == nil {
+						t.Errorf("%s: read %v without error", enc, o.Pairs)
+					}
+					continue
+				}
+
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				if o.Flags != test.Flags {
+					t.Errorf("%s: read flags %#x; expected %#x", enc, o.Flags, test.Flags)
+				}
+
+				if !reflect.DeepEqual(o.Pairs, test.Pairs) {
+					t.Errorf("%s: read %v; expected %v", enc, o.Pairs, test.Pairs)
+				}
+
+				last := test.Pairs[len(test.Pairs)-1]
+				if v, _ := o.Value("a"); v != last.Value {
+					t.Errorf("%s: Value(\"a\") = %#v; expected the last pair", enc, v)
+				}
+
+				if l := o.List(); len(l) != 2 || l["a"] != last.Value {
+					t.Errorf("%s: List() = %v", enc, l)
+				}
+			}
+		})
+	}
+
+	// the pairs of embedded lists are checked against their own flags.
+	embedded := &nvlist.OrderedList{
+		ListMeta: nvlist.ListMeta{Flags: nvlist.UniqueName},
+		Pairs: []nvlist.NVPair{
+			{Name: "l", Type: nvlist.NVList, Value: nvlist.OrderedList{ListMeta: nvlist.ListMeta{Flags: nvlist.UniqueName}, Pairs: pairs}},
+		},
+	}
+
+	b := &bytes.Buffer{}
+	if err := nvlist.NewEncoder(b, binary.LittleEndian).EncodeOrdered(embedded); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := nvlist.Read(b); err == nil {
+		t.Errorf("read an embedded list with duplicate names without error")
+	}
+}
+
+func TestOrderedListAdd(t *testing.T) {
+	tests := map[string]struct {
+		Flags    uint32
+		Expected []nvlist.NVPair
+	}{
+		"duplicates allowed": {
+			Flags: 0,
+			Expected: []nvlist.NVPair{
+				{Name: "a", Type: nvlist.Uint64, Value: uint64(1)},
+				{Name: "b", Type: nvlist.String, Value: "b"},
+				{Name: "a", Type: nvlist.String, Value: "2"},
+				{Name: "a", Type: nvlist.Uint64, Value: uint64(3)},
+			},
+		},
+		"unique name": {
+			Flags: nvlist.UniqueName,
+			Expected: []nvlist.NVPair{
+				{Name: "b", Type: nvlist.String, Value: "b"},
+				{Name: "a", Type: nvlist.Uint64, Value: uint64(3)},
+			},
+		},
+		"unique name and type": {
+			Flags: nvlist.UniqueNameType,
+			Expected: []nvlist.NVPair{
+				{Name: "b", Type: nvlist.String, Value: "b"},
+				{Name: "a", Type: nvlist.String, Value: "2"},
+				{Name: "a", Type: nvlist.Uint64, Value: uint64(3)},
+			},
+		},
+	}
+
+	for name, test := range tests {
+		test := test
+		t.Run(name, func(t *testing.T) {
+			o := &nvlist.OrderedList{ListMeta: nvlist.ListMeta{Flags: test.Flags}}
+			o.Add("a", nvlist.Uint64, uint64(1))
+			o.Add("b", nvlist.String, "b")
+			o.Add("a", nvlist.String, "2")
+			o.Add("a", nvlist.Uint64, uint64(3))
+
+			if !reflect.DeepEqual(o.Pairs, test.Expected) {
+				t.Errorf("added %v; expected %v", o.Pairs, test.Expected)
+			}
+		})
+	}
+}
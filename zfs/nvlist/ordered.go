@@ -0,0 +1,244 @@
+// Copyright 2018 Ayan George.
+// All rights reserved.  Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package nvlist
+
+import (
+	"fmt"
+	"io"
+)
+
+// NVPair is a single name/value pair of an OrderedList.  Type records the
+// encoded type which the Go type of Value does not always capture; Byte and
+// Uint8 pairs both hold uint8 values for instance.
+type NVPair struct {
+	Name  string
+	Type  Type
+	Value interface{}
+}
+
+// OrderedList is a decoded nvlist that keeps its pairs in the order they were
+// encoded.  Embedded lists are stored as OrderedList and []OrderedList values.
+//
+// Unlike List, an OrderedList can hold several pairs with the same name when
+// its flags allow it.  Encoding an OrderedList reproduces the original
+// encoding.
+type OrderedList struct {
+	ListMeta
+	Pairs []NVPair
+}
+
+// ReadOrdered parses the nvlist stored in the supplied io.Reader keeping the
+// order of its pairs.
+func ReadOrdered(r io.Reader, opts ...func(*Scanner) error) (*OrderedList, error) {
+	scn := NewScanner(r, opts...)
+	scn.ordered = true
+
+	v, err := scn.collect(scn)
+	if err != nil {
+		return nil, err
+	}
+
+	rc := v.(OrderedList)
+	return &rc, nil
+}
+
+// Add appends a pair to o.  As with the C library, if o's flags include
+// UniqueName any pair with the same name is removed first and if they include
+// UniqueNameType any pair with the same name and type is removed first.
+//
+// Add is meant for lists built in code.  Decoding a list whose flags forbid
+// the duplicates it holds is an error.
+func (o *OrderedList) Add(name string, t Type, v interface{}) {
+	switch {
+	case o.Flags&UniqueName != 0:
+		o.remove(func(p *NVPair) bool { return p.Name == name })
+	case o.Flags&UniqueNameType != 0:
+		o.remove(func(p *NVPair) bool { return p.Name == name && p.Type == t })
+	}
+
+	o.Pairs = append(o.Pairs, NVPair{Name: name, Type: t, Value: v})
+}
+
+func (o *OrderedList) remove(match func(*NVPair) bool) {
+	rc := o.Pairs[:0]
+	for i := range o.Pairs {
+		if !match(&o.Pairs[i]) {
+			rc = append(rc, o.Pairs[i])
+		}
+	}
+	o.Pairs = rc
+}
+
+// Value returns the value of the last pair named name.
+func (o *OrderedList) Value(name string) (interface{}, bool) {
+	for i := len(o.Pairs) - 1; i >= 0; i-- {
+		if o.Pairs[i].Name == name {
+			return o.Pairs[i].Value, true
+		}
+	}
+	return nil, false
+}
+
+// List converts o and its embedded lists to the map form.  When several pairs
+// share a name the last one wins.
+func (o *OrderedList) List() List {
+	rc := make(List, len(o.Pairs))
+	for _, p := range o.Pairs {
+		switch v := p.Value.(type) {
+		case OrderedList:
+			rc[p.Name] = v.List()
+		case []OrderedList:
+			a := make([]List, len(v))
+			for i := range v {
+				a[i] = v[i].List()
+			}
+			rc[p.Name] = a
+		default:
+			rc[p.Name] = v
+		}
+	}
+	return rc
+}
+
+// Ordered converts l and its embedded lists to an OrderedList with the
+// UniqueName flag set.  Pairs are sorted by name and their types are chosen
+// by TypeOf.
+func (l List) Ordered() OrderedList {
+	rc := OrderedList{ListMeta: ListMeta{Flags: UniqueName}}
+	for _, name := range l.names() {
+		v := l[name]
+		switch lv := v.(type) {
+		case List:
+			v = lv.Ordered()
+		case []List:
+			a := make([]OrderedList, len(lv))
+			for i := range lv {
+				a[i] = lv[i].Ordered()
+			}
+			v = a
+		}
+		rc.Pairs = append(rc.Pairs, NVPair{Name: name, Type: TypeOf(v), Value: v})
+	}
+	return rc
+}
+
+// asOrdered returns the embedded list v, which may be in either form, as an
+// OrderedList.
+func asOrdered(v interface{}) OrderedList {
+	if l, ok := v.(List); ok {
+		return l.Ordered()
+	}
+	return v.(OrderedList)
+}
+
+// asOrderedArray returns the embedded list array v, which may be in either
+// form, as a []OrderedList.
+func asOrderedArray(v interface{}) []OrderedList {
+	a, ok := v.([]List)
+	if !ok {
+		return v.([]OrderedList)
+	}
+
+	rc := make([]OrderedList, len(a))
+	for i := range a {
+		rc[i] = a[i].Ordered()
+	}
+	return rc
+}
+
+// collect reads the remaining pairs of scn, a scanner for a list embedded in
+// the one s is reading, into a List or, if s is reading ordered lists, an
+// OrderedList.
+func (s *Scanner) collect(scn *Scanner) (interface{}, error) {
+	if err := scn.Error(); err != nil {
+		return nil, err
+	}
+
+	if !s.ordered {
+		rc := make(List)
+		unique := scn.unique()
+		for scn.Next() {
+			if err := unique(); err != nil {
+				return nil, err
+			}
+			rc[scn.Name()] = scn.Value()
+		}
+		return rc, scn.Error()
+	}
+
+	scn.ordered = true
+	rc := OrderedList{ListMeta: scn.list}
+	unique := scn.unique()
+	for scn.Next() {
+		if err := unique(); err != nil {
+			return nil, err
+		}
+		rc.Pairs = append(rc.Pairs, NVPair{Name: scn.Name(), Type: scn.Type(), Value: scn.Value()})
+	}
+	return rc, scn.Error()
+}
+
+// unique returns a function that, called after each pair s reads, returns an
+// error if the pair repeats the name, or the name and type, of an earlier pair
+// when the flags of the list forbid it.  nvlist_unpack() fails with EEXIST on
+// such lists.
+func (s *Scanner) unique() func() error {
+	type key struct {
+		name string
+		t    Type
+	}
+
+	seen := map[key]bool{}
+	return func() error {
+		k := key{name: s.Name()}
+		switch {
+		case s.list.Flags&UniqueName != 0:
+		case s.list.Flags&UniqueNameType != 0:
+			k.t = s.Type()
+		default:
+			return nil
+		}
+
+		if seen[k] {
+			return fmt.Errorf("duplicate pair %q in a list with flags %#x", k.name, s.list.Flags)
+		}
+		seen[k] = true
+		return nil
+	}
+}
+
+// collectArray reads n embedded lists, each from the scanner returned by sub,
+// into a []List or, if s is reading ordered lists, a []OrderedList.
+func (s *Scanner) collectArray(n int, sub func(i int) *Scanner) (interface{}, error) {
+	var (
+		rc  []List
+		orc []OrderedList
+	)
+
+	for i := 0; i < n; i++ {
+		v, err := s.collect(sub(i))
+		if err != nil {
+			return nil, err
+		}
+
+		if s.ordered {
+			orc = append(orc, v.(OrderedList))
+		} else {
+			rc = append(rc, v.(List))
+		}
+	}
+
+	if s.ordered {
+		if orc == nil {
+			orc = []OrderedList{}
+		}
+		return orc, nil
+	}
+
+	if rc == nil {
+		rc = []List{}
+	}
+	return rc, nil
+}
@@ -0,0 +1,160 @@
+// Copyright 2018 Ayan George.
+// All rights reserved.  Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package nvlist
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+var (
+	// ErrNotFound is returned when a path names a pair that does not exist.
+	ErrNotFound = errors.New("not found")
+	// ErrNotList is returned when a path descends into or indexes a value
+	// that is not an NVList or NVListArray.
+	ErrNotList = errors.New("not an nvlist")
+	// ErrIndex is returned when a path indexes past the end of an
+	// NVListArray.
+	ErrIndex = errors.New("index out of range")
+	// ErrSyntax is returned for malformed paths.
+	ErrSyntax = errors.New("invalid path")
+)
+
+// A PathError records the path that could not be resolved and how far the
+// lookup got before it failed.
+type PathError struct {
+	Path string // path passed to Lookup
+	At   string // prefix of Path at which the lookup failed
+	Err  error
+}
+
+func (e *PathError) Error() string {
+	if e.At == e.Path {
+		return fmt.Sprintf("%q: %v", e.Path, e.Err)
+	}
+	return fmt.Sprintf("%q: %q %v", e.Path, e.At, e.Err)
+}
+
+func (e *PathError) Unwrap() error {
+	return e.Err
+}
+
+// Lookup returns the value at path.  A path is a list of pair names separated
+// by slashes.  A name may be followed by an index in square brackets to select
+// one list of an NVListArray.  For example:
+//
+//	vdev_tree/children[1]/path
+//
+// Errors are of type *PathError and wrap ErrNotFound, ErrNotList, ErrIndex or
+// ErrSyntax.
+func (l List) Lookup(path string) (interface{}, error) {
+	var v interface{} = l
+
+	at := ""
+	for _, elem := range strings.Split(path, "/") {
+		name, index, err := parsePathElem(elem)
+		if err != nil {
+			return nil, &PathError{Path: path, At: join(at, elem), Err: err}
+		}
+
+		cur, ok := v.(List)
+		if !ok {
+			return nil, &PathError{Path: path, At: at, Err: ErrNotList}
+		}
+
+		at = join(at, name)
+		if v, ok = cur[name]; !ok {
+			return nil, &PathError{Path: path, At: at, Err: ErrNotFound}
+		}
+
+		if index < 0 {
+			continue
+		}
+
+		a, ok := v.([]List)
+		if !ok {
+			return nil, &PathError{Path: path, At: at, Err: ErrNotList}
+		}
+
+		at = fmt.Sprintf("%s[%d]", at, index)
+		if index >= len(a) {
+			return nil, &PathError{Path: path, At: at, Err: ErrIndex}
+		}
+		v = a[index]
+	}
+
+	return v, nil
+}
+
+// parsePathElem splits a path element into a name and index.  The index is -1
+// if the element has none.
+func parsePathElem(elem string) (string, int, error) {
+	i := strings.IndexByte(elem, '[')
+	if i < 0 {
+		if elem == "" {
+			return "", -1, ErrSyntax
+		}
+		return elem, -1, nil
+	}
+
+	if i == 0 || !strings.HasSuffix(elem, "]") {
+		return "", -1, ErrSyntax
+	}
+
+	index, err := strconv.ParseUint(elem[i+1:len(elem)-1], 10, 31)
+	if err != nil {
+		return "", -1, ErrSyntax
+	}
+
+	return elem[:i], int(index), nil
+}
+
+// Get stores the value at path in the value pointed to by v using the same
+// rules as UnmarshalList.  Values that cannot be stored in v produce an
+// *UnmarshalTypeError naming path.
+func (l List) Get(path string, v interface{}) error {
+	rv := reflect.ValueOf(v)
+	if rv.Kind() != reflect.Ptr || rv.IsNil() {
+		return &InvalidUnmarshalError{Type: reflect.TypeOf(v)}
+	}
+
+	val, err := l.Lookup(path)
+	if err != nil {
+		return err
+	}
+
+	return unmarshalValue(path, val, rv.Elem())
+}
+
+// GetUint64 returns the unsigned integer at path.
+func (l List) GetUint64(path string) (uint64, error) {
+	var rc uint64
+	err := l.Get(path, &rc)
+	return rc, err
+}
+
+// GetString returns the string at path.
+func (l List) GetString(path string) (string, error) {
+	var rc string
+	err := l.Get(path, &rc)
+	return rc, err
+}
+
+// GetList returns the nvlist at path.
+func (l List) GetList(path string) (List, error) {
+	var rc List
+	err := l.Get(path, &rc)
+	return rc, err
+}
+
+// GetListArray returns the array of nvlists at path.
+func (l List) GetListArray(path string) ([]List, error) {
+	var rc []List
+	err := l.Get(path, &rc)
+	return rc, err
+}
@@ -0,0 +1,78 @@
+// Copyright 2018 Ayan George.
+// All rights reserved.  Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package nvlist_test
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/ayang64/ztool/zfs/nvlist"
+)
+
+func TestLookup(t *testing.T) {
+	l := nvlist.List{
+		"guid": uint64(1),
+		"vdev_tree": nvlist.List{
+			"guid": uint64(2),
+			"children": []nvlist.List{
+				{"guid": uint64(3), "path": "/dev/ada0"},
+				{"guid": uint64(4), "path": "/dev/ada1", "ashift": uint32(12)},
+			},
+		},
+	}
+
+	if v, err := l.GetUint64("vdev_tree/children[1]/guid"); err != nil || v != 4 {
+		t.Errorf("GetUint64() = %d, %v; expected 4", v, err)
+	}
+
+	if v, err := l.GetUint64("vdev_tree/children[1]/ashift"); err != nil || v != 12 {
+		t.Errorf("GetUint64() of a uint32 = %d, %v; expected 12", v, err)
+	}
+
+	if v, err := l.GetString("vdev_tree/children[0]/path"); err != nil || v != "/dev/ada0" {
+		t.Errorf("GetString() = %q, %v; expected \"/dev/ada0\"", v, err)
+	}
+
+	if v, err := l.GetList("vdev_tree/children[0]"); err != nil || v["guid"] != uint64(3) {
+		t.Errorf("GetList() = %v, %v", v, err)
+	}
+
+	if v, err := l.GetListArray("vdev_tree/children"); err != nil || len(v) != 2 {
+		t.Errorf("GetListArray() = %v, %v", v, err)
+	}
+
+	errs := map[string]struct {
+		Err error
+		At  string
+	}{
+		"vdev_tree/children[2]/path":  {Err: nvlist.ErrIndex, At: "vdev_tree/children[2]"},
+		"vdev_tree/kids[0]/path":      {Err: nvlist.ErrNotFound, At: "vdev_tree/kids"},
+		"vdev_tree/children[0]/name":  {Err: nvlist.ErrNotFound, At: "vdev_tree/children[0]/name"},
+		"guid/path":                   {Err: nvlist.ErrNotList, At: "guid"},
+		"vdev_tree[0]/guid":           {Err: nvlist.ErrNotList, At: "vdev_tree"},
+		"vdev_tree//guid":             {Err: nvlist.ErrSyntax, At: "vdev_tree/"},
+		"vdev_tree/children[x]/guid":  {Err: nvlist.ErrSyntax, At: "vdev_tree/children[x]"},
+		"vdev_tree/children[-1]/guid": {Err: nvlist.ErrSyntax, At: "vdev_tree/children[-1]"},
+	}
+
+	for path, expected := range errs {
+		_, err := l.GetUint64(path)
+
+		var pe *nvlist.PathError
+		if !errors.As(err, &pe) || !errors.Is(err, expected.Err) {
+			t.Errorf("%q: got error %v; expected %v", path, err, expected.Err)
+			continue
+		}
+
+		if pe.At != expected.At {
+			t.Errorf("%q: failed at %q; expected %q", path, pe.At, expected.At)
+		}
+	}
+
+	var te *nvlist.UnmarshalTypeError
+	if _, err := l.GetString("vdev_tree/children[0]/guid"); !errors.As(err, &te) || te.Name != "vdev_tree/children[0]/guid" {
+		t.Errorf("GetString() of a uint64 returned %v", err)
+	}
+}
@@ -9,16 +9,15 @@ import "io"
 // Read recursively parses the nvlist stored in the supplied
 // io.Reader.  It is up to the caller to ensure that the
 // reader is in position to start reading the nvlist.
+//
+// List does not keep the order or the encoded types of the pairs; use
+// ReadOrdered to read a list that must be encoded again unchanged.
 func Read(r io.Reader, opts ...func(*Scanner) error) (List, error) {
-	rc := make(List)
 	scn := NewScanner(r, opts...)
 
-	for scn.Next() {
-		rc[scn.Name()] = scn.Value()
-	}
-
-	if err := scn.Error(); err != nil {
+	v, err := scn.collect(scn)
+	if err != nil {
 		return nil, err
 	}
-	return rc, nil
+	return v.(List), nil
 }
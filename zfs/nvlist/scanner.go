@@ -5,15 +5,14 @@
 package nvlist
 
 import (
-	"bytes"
 	"encoding/binary"
 	"fmt"
 	"io"
-	"log"
+	"time"
 )
 
-// Scanner provides a convenient way to read an XDR encode nvlist from a ZFS
-// volume.  The Scanner type encapsulates all of the context related to
+// Scanner provides a convenient way to read an XDR or natively encoded nvlist
+// from a ZFS volume.  The Scanner type encapsulates all of the context related to
 // iterating over nvlist entries.
 type Scanner struct {
 	r                io.Reader // io.Reader for reading scanned data.
@@ -28,6 +27,7 @@ type Scanner struct {
 	value            interface{}
 	err              error
 	bytes            []byte
+	ordered          bool // decode embedded lists as OrderedList values.
 }
 
 func WithByteOrder(o binary.ByteOrder) func(*Scanner) error {
@@ -39,7 +39,6 @@ func WithByteOrder(o binary.ByteOrder) func(*Scanner) error {
 
 func WithoutHeader() func(*Scanner) error {
 	return func(s *Scanner) error {
-		log.Printf("setting withheader to false.")
 		s.withheader = false
 		return nil
 	}
@@ -55,23 +54,31 @@ func NewScanner(r io.Reader, opts ...func(*Scanner) error) (rc *Scanner) {
 		opt(rc)
 	}
 
-	// at the moment, byte order doesn't matter.
 	if rc.withheader {
-		log.Printf("scanning header.")
 		if err := binary.Read(r, binary.BigEndian, &rc.header); err != nil {
 			rc.err = err
 			return
 		}
 	} else {
-		log.Printf("skipping header.")
+		rc.header.Encoding = EncodingXDR
 	}
 
-	rc.byteOrder = func() binary.ByteOrder {
-		if rc.header.Endian == BigEndian {
-			return binary.BigEndian
+	switch rc.header.Encoding {
+	case EncodingXDR:
+		// XDR is always big endian no matter what byte order the host that
+		// packed the list used.
+		rc.byteOrder = binary.BigEndian
+	case EncodingNative:
+		// native lists are a copy of the C library's in-memory structures
+		// and use the byte order of the host that packed them.
+		if rc.byteOrder = rc.header.Endian.ByteOrder(); rc.byteOrder == nil {
+			rc.err = fmt.Errorf("unknown byte order %s", rc.header.Endian)
+			return
 		}
-		return binary.LittleEndian
-	}()
+	default:
+		rc.err = fmt.Errorf("unknown nvlist encoding %s", rc.header.Encoding)
+		return
+	}
 
 	if err := binary.Read(r, rc.byteOrder, &rc.list); err != nil {
 		rc.err = err
@@ -90,17 +97,39 @@ func (s *Scanner) ReadValue(r io.Reader, t Type) (interface{}, error) {
 	return f()
 }
 
+// XDR encodes every value in units of at least four bytes.  Bytes, 8 and 16
+// bit integers and booleans are all widened to 32 bits.  Arrays of those types
+// are preceded by an element count while byte arrays are stored as opaque
+// data padded to a multiple of four bytes.
 func (s *Scanner) readValueFunc(r io.Reader, t Type) func() (interface{}, error) {
 	m := map[Type]func() (interface{}, error){
 		DontCare: nil,
 		Unknown:  nil,
 		Boolean:  func() (interface{}, error) { return true, nil },
-		Byte:     nil,
-		Int16:    nil,
-		Uint16:   nil,
-		Int32:    nil,
-		Uint32:   nil,
-		Int64:    nil,
+		Byte: func() (interface{}, error) {
+			v, err := s.readInt32(r)
+			return byte(v), err
+		},
+		Int16: func() (interface{}, error) {
+			v, err := s.readInt32(r)
+			return int16(v), err
+		},
+		Uint16: func() (interface{}, error) {
+			v, err := s.readInt32(r)
+			return uint16(v), err
+		},
+		Int32: func() (interface{}, error) {
+			v, err := s.readInt32(r)
+			return v, err
+		},
+		Uint32: func() (interface{}, error) {
+			v, err := s.readInt32(r)
+			return uint32(v), err
+		},
+		Int64: func() (interface{}, error) {
+			v, err := s.readInt64(r)
+			return v, err
+		},
 		Uint64: func() (interface{}, error) {
 			var rc uint64
 			if err := binary.Read(r, s.byteOrder, &rc); err != nil {
@@ -108,51 +137,113 @@ func (s *Scanner) readValueFunc(r io.Reader, t Type) func() (interface{}, error)
 			}
 			return rc, nil
 		},
-		String: func() (interface{}, error) {
-			var length int32
-			if err := binary.Read(r, s.byteOrder, &length); err != nil {
-				return "", err
+		String: func() (interface{}, error) { return s.ReadString(r) },
+		ByteArray: func() (interface{}, error) {
+			if err := s.checkElements(r, 1); err != nil {
+				return nil, err
 			}
-
-			align4 := func(i int32) int32 {
-				return (i + 3) & ^3
+			rc := make([]byte, align4(int32(s.NumElements())))
+			if _, err := io.ReadFull(r, rc); err != nil {
+				return nil, err
 			}
-
-			str := make([]byte, align4(length))
-
-			if err := binary.Read(r, s.byteOrder, str); err != nil {
-				return "", err
+			return rc[:s.NumElements()], nil
+		},
+		Int16Array: func() (interface{}, error) {
+			v, err := s.readInt32Array(r)
+			rc := make([]int16, len(v))
+			for i := range v {
+				rc[i] = int16(v[i])
 			}
-			return string(str[:length]), nil
+			return rc, err
 		},
-		ByteArray:   nil,
-		Int16Array:  nil,
-		Uint16Array: nil,
-		Int32Array:  nil,
-		Uint32Array: nil,
-		Int64Array:  nil,
-		Uint64Array: nil,
-		StringArray: nil,
-		HRTime:      nil,
-		NVList:      func() (interface{}, error) { return s.ReadSub(r) },
-		NVListArray: func() (interface{}, error) {
-			rc := make([]List, 0, s.NumElements())
+		Uint16Array: func() (interface{}, error) {
+			v, err := s.readInt32Array(r)
+			rc := make([]uint16, len(v))
+			for i := range v {
+				rc[i] = uint16(v[i])
+			}
+			return rc, err
+		},
+		Int32Array: func() (interface{}, error) {
+			v, err := s.readInt32Array(r)
+			return v, err
+		},
+		Uint32Array: func() (interface{}, error) {
+			v, err := s.readInt32Array(r)
+			rc := make([]uint32, len(v))
+			for i := range v {
+				rc[i] = uint32(v[i])
+			}
+			return rc, err
+		},
+		Int64Array: func() (interface{}, error) {
+			v, err := s.readInt64Array(r)
+			return v, err
+		},
+		Uint64Array: func() (interface{}, error) {
+			v, err := s.readInt64Array(r)
+			rc := make([]uint64, len(v))
+			for i := range v {
+				rc[i] = uint64(v[i])
+			}
+			return rc, err
+		},
+		StringArray: func() (interface{}, error) {
+			rc := make([]string, 0, s.NumElements())
 			for i := 0; i < s.NumElements(); i++ {
-				v, err := s.ReadSub(r)
-
+				str, err := s.ReadString(r)
 				if err != nil {
-					log.Fatal(err)
+					return nil, err
 				}
-				rc = append(rc, v)
+				rc = append(rc, str)
 			}
 			return rc, nil
 		},
-		BooleanValue: nil,
-		Int8:         nil,
-		Uint8:        nil,
-		BooleanArray: nil,
-		Int8Array:    nil,
-		Uint8Array:   nil,
+		HRTime: func() (interface{}, error) {
+			// hrtime_t is a count of nanoseconds.
+			v, err := s.readInt64(r)
+			return time.Duration(v), err
+		},
+		NVList: func() (interface{}, error) { return s.collect(s.NewSubScanner(r)) },
+		NVListArray: func() (interface{}, error) {
+			return s.collectArray(s.NumElements(), func(int) *Scanner { return s.NewSubScanner(r) })
+		},
+		BooleanValue: func() (interface{}, error) {
+			v, err := s.readInt32(r)
+			return v != 0, err
+		},
+		Int8: func() (interface{}, error) {
+			v, err := s.readInt32(r)
+			return int8(v), err
+		},
+		Uint8: func() (interface{}, error) {
+			v, err := s.readInt32(r)
+			return uint8(v), err
+		},
+		BooleanArray: func() (interface{}, error) {
+			v, err := s.readInt32Array(r)
+			rc := make([]bool, len(v))
+			for i := range v {
+				rc[i] = v[i] != 0
+			}
+			return rc, err
+		},
+		Int8Array: func() (interface{}, error) {
+			v, err := s.readInt32Array(r)
+			rc := make([]int8, len(v))
+			for i := range v {
+				rc[i] = int8(v[i])
+			}
+			return rc, err
+		},
+		Uint8Array: func() (interface{}, error) {
+			v, err := s.readInt32Array(r)
+			rc := make([]uint8, len(v))
+			for i := range v {
+				rc[i] = uint8(v[i])
+			}
+			return rc, err
+		},
 	}
 
 	if f, found := m[t]; found {
@@ -162,6 +253,87 @@ func (s *Scanner) readValueFunc(r io.Reader, t Type) func() (interface{}, error)
 	return nil
 }
 
+func (s *Scanner) readInt32(r io.Reader) (int32, error) {
+	var rc int32
+	err := binary.Read(r, s.byteOrder, &rc)
+	return rc, err
+}
+
+func (s *Scanner) readInt64(r io.Reader) (int64, error) {
+	var rc int64
+	err := binary.Read(r, s.byteOrder, &rc)
+	return rc, err
+}
+
+// readArrayLength reads the element count that precedes XDR arrays and checks
+// it against the number of elements in the pair.
+func (s *Scanner) readArrayLength(r io.Reader, size int) error {
+	if err := s.checkElements(r, size); err != nil {
+		return err
+	}
+
+	n, err := s.readInt32(r)
+	if err != nil {
+		return err
+	}
+
+	if int(n) != s.NumElements() {
+		return fmt.Errorf("%q has %d array elements; expected %d", s.Name(), n, s.NumElements())
+	}
+
+	return nil
+}
+
+// readInt32Array reads an array of 32 bit values.  Like the C library, nothing
+// at all is encoded for an empty array.
+func (s *Scanner) readInt32Array(r io.Reader) ([]int32, error) {
+	if s.NumElements() == 0 {
+		return nil, nil
+	}
+
+	if err := s.readArrayLength(r, 4); err != nil {
+		return nil, err
+	}
+
+	rc := make([]int32, s.NumElements())
+	if err := binary.Read(r, s.byteOrder, rc); err != nil {
+		return nil, err
+	}
+	return rc, nil
+}
+
+// readInt64Array reads an array of 64 bit values.
+func (s *Scanner) readInt64Array(r io.Reader) ([]int64, error) {
+	if s.NumElements() == 0 {
+		return nil, nil
+	}
+
+	if err := s.readArrayLength(r, 8); err != nil {
+		return nil, err
+	}
+
+	rc := make([]int64, s.NumElements())
+	if err := binary.Read(r, s.byteOrder, rc); err != nil {
+		return nil, err
+	}
+	return rc, nil
+}
+
+// checkElements returns an error if the current pair claims more elements of
+// the given encoded size than there are bytes left in r.  This keeps us from
+// allocating huge slices when decoding garbage.
+func (s *Scanner) checkElements(r io.Reader, size int) error {
+	if s.NumElements() < 0 {
+		return fmt.Errorf("%q has a negative element count %d", s.Name(), s.NumElements())
+	}
+
+	if l, ok := r.(interface{ Len() int }); ok && s.NumElements()*size > l.Len() {
+		return fmt.Errorf("%q claims %d elements but only %d bytes remain", s.Name(), s.NumElements(), l.Len())
+	}
+
+	return nil
+}
+
 func (s *Scanner) ReadSub(r io.Reader) (List, error) {
 	rc := make(List)
 	scn := s.NewSubScanner(r)
@@ -217,8 +389,12 @@ func (s *Scanner) ReadString(r io.Reader) (string, error) {
 		return "", err
 	}
 
-	align4 := func(i int32) int32 {
-		return (i + 3) & ^3
+	if length < 0 {
+		return "", fmt.Errorf("invalid string length %d", length)
+	}
+
+	if l, ok := r.(interface{ Len() int }); ok && int(length) > l.Len() {
+		return "", fmt.Errorf("string length %d exceeds the %d bytes remaining", length, l.Len())
 	}
 
 	str := make([]byte, align4(length))
@@ -230,6 +406,11 @@ func (s *Scanner) ReadString(r io.Reader) (string, error) {
 	return string(str[:length]), nil
 }
 
+// align4 rounds i up to the next multiple of four.
+func align4(i int32) int32 {
+	return (i + 3) & ^3
+}
+
 func (s *Scanner) Name() string {
 	return s.fieldName
 }
@@ -263,6 +444,10 @@ func (s *Scanner) Next() bool {
 		return false
 	}
 
+	if s.header.Encoding == EncodingNative {
+		return s.nextNative()
+	}
+
 	if s.err = binary.Read(s.r, s.byteOrder, &s.pair); s.err != nil {
 		return false
 	}
@@ -271,14 +456,18 @@ func (s *Scanner) Next() bool {
 		return false
 	}
 
-	// read entire record into a byte slice
-	record := make([]byte, s.pair.Size-8)
-	if s.err = binary.Read(s.r, s.byteOrder, record); s.err != nil {
+	// the encoded size includes the 8 bytes of sizes we just read.  anything
+	// smaller means we're looking at garbage.
+	if s.pair.Size < 8 {
+		s.err = fmt.Errorf("invalid nvpair size %d", s.pair.Size)
 		return false
 	}
 
-	// lets read from the remainding bytes
-	br := bytes.NewReader(record)
+	// like the C library, read the fields of the pair from the stream and
+	// only use its encoded size as a bound.  The C library counts bytes it
+	// does not write in the encoded size of empty arrays and of lists that
+	// hold them so the size cannot be used to find the next pair.
+	br := &pairReader{io.LimitedReader{R: s.r, N: int64(s.pair.Size - 8)}}
 
 	// read the name of the field
 	name, err := s.ReadString(br)
@@ -316,8 +505,18 @@ func (s *Scanner) Next() bool {
 	return true
 }
 
+// pairReader reads the fields of a single XDR encoded pair.  Len reports how
+// many bytes its encoded size leaves which bounds the strings and arrays read.
+type pairReader struct {
+	io.LimitedReader
+}
+
+func (r *pairReader) Len() int {
+	return int(r.N)
+}
+
 func (s *Scanner) NewSubScanner(r io.Reader) (rc *Scanner) {
-	rc = &Scanner{r: r, byteOrder: s.byteOrder}
+	rc = &Scanner{r: r, byteOrder: s.byteOrder, header: s.header}
 	if err := binary.Read(r, s.byteOrder, &rc.list); err != nil {
 		rc.err = err
 	}
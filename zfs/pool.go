@@ -0,0 +1,487 @@
+// Copyright 2018 Ayan George.
+// All rights reserved.  Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package zfs
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+)
+
+// Device is one of the device images supplied to a Pool.
+type Device struct {
+	Name  string       // name the device was supplied under; usually its path.
+	FS    *Filesystem  // labels and uber blocks; nil if they could not be read.
+	Label *LabelConfig // configuration stored in the device's label.
+	Err   error        // reason the device cannot be used, if any.
+}
+
+func (d *Device) String() string {
+	if d.Err != nil {
+		return fmt.Sprintf("%s (%v)", d.Name, d.Err)
+	}
+	return fmt.Sprintf("%s (guid %d)", d.Name, d.Label.GUID)
+}
+
+// Pool is a pool assembled from the images of its devices.
+type Pool struct {
+	devices []*Device
+	config  *PoolConfig
+
+	guid    uint64
+	root    *RootVdev
+	leaves  map[uint64]*Device // devices by the guid of their leaf vdev.
+	missing []VdevTree
+	extra   []*Device
+	uber    *ActiveUberBlock
+
+	badCopy func(BadCopy)
+	files   []*os.File // files opened by WithDevicePath.
+}
+
+// WithDevice adds the device image rs to the pool under the given name.
+func WithDevice(name string, rs io.ReadSeeker) func(*Pool) error {
+	return func(p *Pool) error {
+		p.devices = append(p.devices, &Device{Name: name, FS: &Filesystem{rs: rs}})
+		return nil
+	}
+}
+
+// WithDevicePath adds the device or image file at path to the pool.  The file
+// is closed by Pool.Close.
+func WithDevicePath(path string) func(*Pool) error {
+	return func(p *Pool) error {
+		fh, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		p.files = append(p.files, fh)
+		return WithDevice(path, fh)(p)
+	}
+}
+
+// WithPoolConfig assembles the pool described by pc, such as one read from
+// zpool.cache, rather than the one described by the newest device label.
+func WithPoolConfig(pc *PoolConfig) func(*Pool) error {
+	return func(p *Pool) error {
+		p.config = pc
+		return nil
+	}
+}
+
+// NewPool reads the labels of every device and matches them to the pool's
+// vdev tree by their guid, top_guid and pool_guid.  The vdev tree is taken
+// from the pool configuration if one was given and is otherwise rebuilt from
+// the top level vdevs recorded in the labels.
+//
+// Missing and extra devices and a guid sum that does not match the active
+// uber block do not prevent the pool from being assembled; they are reported
+// by Check.
+func NewPool(opts ...func(*Pool) error) (*Pool, error) {
+	rc := Pool{leaves: map[uint64]*Device{}}
+	for _, opt := range opts {
+		if err := opt(&rc); err != nil {
+			rc.Close()
+			return nil, err
+		}
+	}
+
+	if len(rc.devices) == 0 {
+		return nil, fmt.Errorf("no devices supplied")
+	}
+
+	for _, d := range rc.devices {
+		if d.Err = d.FS.LoadVdevLabel(); d.Err != nil {
+			continue
+		}
+		d.Label, d.Err = d.FS.Config()
+	}
+
+	if err := rc.buildTree(); err != nil {
+		rc.Close()
+		return nil, err
+	}
+
+	rc.matchDevices()
+	rc.selectUberBlock()
+
+	return &rc, nil
+}
+
+// Close closes the files the pool opened and returns the first error
+// encountered.  Devices supplied by WithDevice are left open.
+func (p *Pool) Close() error {
+	var rc error
+	for _, fh := range p.files {
+		if err := fh.Close(); err != nil && rc == nil {
+			rc = err
+		}
+	}
+	p.files = nil
+	return rc
+}
+
+// newDiskPool returns a pool whose only top level vdev is the disk image rs.
+// It lets images without a usable label be read.
+func newDiskPool(rs io.ReadSeeker) *Pool {
+	d := &Device{FS: &Filesystem{rs: rs}}
+	disk := &DiskVdev{Vdev: Vdev{Type: "disk"}}
+
+	return &Pool{
+		devices: []*Device{d},
+		root:    &RootVdev{Vdev: Vdev{Type: "root", children: []VdevTree{disk}}},
+		leaves:  map[uint64]*Device{disk.GUID: d},
+	}
+}
+
+// newestLabel returns the usable device with the most recent label that
+// belongs to the pool with the given guid or, if guid is zero, to any pool.
+func (p *Pool) newestLabel(guid uint64, match func(*LabelConfig) bool) *Device {
+	var rc *Device
+	for _, d := range p.devices {
+		if d.Err != nil || (guid != 0 && d.Label.PoolConfig.GUID != guid) || !match(d.Label) {
+			continue
+		}
+		if rc == nil || d.Label.TXG > rc.Label.TXG {
+			rc = d
+		}
+	}
+	return rc
+}
+
+func (p *Pool) buildTree() error {
+	if p.config == nil {
+		d := p.newestLabel(0, func(*LabelConfig) bool { return true })
+		if d == nil {
+			if len(p.devices) == 1 {
+				return fmt.Errorf("device %q: %w", p.devices[0].Name, p.devices[0].Err)
+			}
+			return fmt.Errorf("no device has a valid label; %v", p.devices)
+		}
+
+		cfg, err := p.labelsConfig(d.Label)
+		if err != nil {
+			return err
+		}
+		p.config = cfg
+	}
+
+	p.guid = p.config.GUID
+
+	root, err := p.config.Tree()
+	if err != nil {
+		return fmt.Errorf("pool %q: %w", p.config.Name, err)
+	}
+	p.root = root
+
+	return nil
+}
+
+// labelsConfig rebuilds the configuration of the pool described by newest
+// from the labels of its devices.  Each label only records the top level vdev
+// its device belongs to so the tree is assembled from the newest label for
+// each top level vdev.  Slots without a label are filled with holes or
+// missing vdevs.
+func (p *Pool) labelsConfig(newest *LabelConfig) (*PoolConfig, error) {
+	rc := newest.PoolConfig
+
+	holes := map[uint64]bool{}
+	for _, id := range rc.HoleArray {
+		holes[id] = true
+	}
+
+	children := make([]VdevConfig, rc.VdevChildren)
+	for i := range children {
+		children[i] = VdevConfig{Type: "missing", ID: uint64(i)}
+		if holes[uint64(i)] {
+			children[i].Type = "hole"
+		}
+	}
+
+	for _, d := range p.devices {
+		if d.Err != nil || d.Label.PoolConfig.GUID != rc.GUID {
+			continue
+		}
+
+		top := d.Label.TopGUID
+		n := p.newestLabel(rc.GUID, func(l *LabelConfig) bool { return l.TopGUID == top })
+
+		id := n.Label.VdevTree.ID
+		if id >= uint64(len(children)) {
+			return nil, fmt.Errorf("%s: top level vdev id %d exceeds the %d vdevs in the pool", n.Name, id, len(children))
+		}
+		children[id] = n.Label.VdevTree
+	}
+
+	rc.VdevTree = VdevConfig{Type: "root", GUID: rc.GUID, Children: children}
+
+	return &rc, nil
+}
+
+// matchDevices pairs leaf vdevs with the devices whose labels name them.
+// Leaves without a device are missing and devices without a leaf are extra.
+func (p *Pool) matchDevices() {
+	byGUID := map[uint64][]*Device{}
+	for _, d := range p.devices {
+		if d.Err == nil {
+			byGUID[d.Label.GUID] = append(byGUID[d.Label.GUID], d)
+		}
+	}
+
+	// claim returns the device for leaf v of top level vdev top.  Cache
+	// devices and spares are not part of a top level vdev and may carry no
+	// pool guid.
+	claim := func(v VdevTree, top VdevTree) *Device {
+		var rc *Device
+		for _, d := range byGUID[v.Common().GUID] {
+			if top != nil && (d.Label.PoolConfig.GUID != p.guid || d.Label.TopGUID != top.Common().GUID) {
+				continue
+			}
+			if rc == nil || d.Label.TXG > rc.Label.TXG {
+				rc = d
+			}
+		}
+		return rc
+	}
+
+	var walk func(v VdevTree, top VdevTree, required bool)
+	walk = func(v VdevTree, top VdevTree, required bool) {
+		if _, ok := v.(*MissingVdev); ok && required {
+			p.missing = append(p.missing, v)
+			return
+		}
+
+		if isLeaf(v) {
+			if d := claim(v, top); d != nil {
+				p.leaves[v.Common().GUID] = d
+			} else if required {
+				p.missing = append(p.missing, v)
+			}
+			return
+		}
+
+		for _, c := range v.Children() {
+			if top == nil && v == VdevTree(p.root) {
+				walk(c, c, required)
+			} else {
+				walk(c, top, required)
+			}
+		}
+	}
+
+	walk(p.root, nil, true)
+	for _, v := range append(append([]VdevTree{}, p.root.L2Cache...), p.root.Spares...) {
+		walk(v, nil, false)
+	}
+
+	claimed := map[*Device]bool{}
+	for _, d := range p.leaves {
+		claimed[d] = true
+	}
+
+	for _, d := range p.devices {
+		if !claimed[d] {
+			p.extra = append(p.extra, d)
+		}
+	}
+}
+
+// isLeaf reports whether v is backed by a device.
+func isLeaf(v VdevTree) bool {
+	switch v := v.(type) {
+	case *LogVdev:
+		return isLeaf(v.VdevTree)
+	case *L2CacheVdev:
+		return isLeaf(v.VdevTree)
+	case *DiskVdev, *FileVdev:
+		return true
+	default:
+		return false
+	}
+}
+
+// selectUberBlock picks the newest valid uber block found on the devices of
+// the pool's top level vdevs.  Spares and cache devices may be shared with
+// other pools and are not consulted.  Devices are visited in guid order so
+// that ties always pick the same device and ashift.
+func (p *Pool) selectUberBlock() {
+	guids := []uint64{}
+
+	var walk func(v VdevTree)
+	walk = func(v VdevTree) {
+		if isLeaf(v) {
+			if _, found := p.leaves[v.Common().GUID]; found {
+				guids = append(guids, v.Common().GUID)
+			}
+			return
+		}
+		for _, c := range v.Children() {
+			walk(c)
+		}
+	}
+
+	for _, top := range p.root.Children() {
+		walk(top)
+	}
+
+	sort.Slice(guids, func(i, j int) bool { return guids[i] < guids[j] })
+
+	for _, guid := range guids {
+		d := p.leaves[guid]
+		ubs, err := d.FS.UberBlockCandidates()
+		if err != nil || len(ubs) == 0 {
+			continue
+		}
+
+		if p.uber != nil && CompareUberBlocks(&ubs[0].UberBlock, &p.uber.UberBlock) <= 0 {
+			continue
+		}
+
+		ashift, err := d.FS.AShift()
+		if err != nil {
+			continue
+		}
+
+		p.uber = &ActiveUberBlock{
+			AShift:    ashift,
+			ByteOrder: ubs[0].ByteOrder,
+			UberBlock: ubs[0].UberBlock,
+		}
+	}
+}
+
+// GUID returns the pool's guid.
+func (p *Pool) GUID() uint64 {
+	return p.guid
+}
+
+// Config returns the configuration the pool was assembled from.
+func (p *Pool) Config() *PoolConfig {
+	return p.config
+}
+
+// Root returns the root of the pool's vdev tree.
+func (p *Pool) Root() *RootVdev {
+	return p.root
+}
+
+// Devices returns every device supplied to the pool.
+func (p *Pool) Devices() []*Device {
+	return p.devices
+}
+
+// Device returns the device backing the leaf vdev with the given guid.
+func (p *Pool) Device(guid uint64) (*Device, bool) {
+	d, found := p.leaves[guid]
+	return d, found
+}
+
+// Missing returns the leaf vdevs for which no device was supplied.
+func (p *Pool) Missing() []VdevTree {
+	return p.missing
+}
+
+// Extra returns the devices that do not belong to the pool or whose labels
+// could not be read.
+func (p *Pool) Extra() []*Device {
+	return p.extra
+}
+
+// ActiveUberBlock returns the newest valid uber block found on the pool's
+// devices.
+func (p *Pool) ActiveUberBlock() (*ActiveUberBlock, error) {
+	if p.uber == nil {
+		return nil, fmt.Errorf("no valid uber blocks found")
+	}
+	return p.uber, nil
+}
+
+// GuidSum returns the sum of the guids of every vdev in the pool's vdev tree.
+// ZFS records the same sum in each uber block.
+func (p *Pool) GuidSum() uint64 {
+	var sum uint64
+
+	var walk func(v VdevTree)
+	walk = func(v VdevTree) {
+		sum += v.Common().GUID
+		for _, c := range v.Children() {
+			walk(c)
+		}
+	}
+	walk(p.root)
+
+	return sum
+}
+
+// Check returns an *AssemblyError if devices are missing or extra or if the
+// guid sum of the vdev tree does not match the active uber block.
+func (p *Pool) Check() error {
+	e := AssemblyError{
+		Missing: p.missing,
+		Extra:   p.extra,
+		GuidSum: p.GuidSum(),
+	}
+
+	if p.uber != nil {
+		e.UberBlockGuidSum = p.uber.GuidSum
+	} else {
+		e.UberBlockGuidSum = e.GuidSum
+	}
+
+	if len(e.Missing) == 0 && len(e.Extra) == 0 && e.GuidSum == e.UberBlockGuidSum {
+		return nil
+	}
+
+	return &e
+}
+
+// AssemblyError describes how the devices supplied to a pool differ from its
+// configuration.
+type AssemblyError struct {
+	Missing          []VdevTree // leaf vdevs without a device.
+	Extra            []*Device  // devices that do not belong to the pool.
+	GuidSum          uint64     // sum of the guids in the vdev tree.
+	UberBlockGuidSum uint64     // sum recorded in the active uber block.
+}
+
+func (e *AssemblyError) Error() string {
+	problems := []string{}
+
+	if len(e.Missing) > 0 {
+		names := []string{}
+		for _, v := range e.Missing {
+			names = append(names, vdevName(v))
+		}
+		problems = append(problems, fmt.Sprintf("missing devices: %s", strings.Join(names, ", ")))
+	}
+
+	if len(e.Extra) > 0 {
+		names := []string{}
+		for _, d := range e.Extra {
+			names = append(names, d.String())
+		}
+		problems = append(problems, fmt.Sprintf("extra devices: %s", strings.Join(names, ", ")))
+	}
+
+	if e.GuidSum != e.UberBlockGuidSum {
+		problems = append(problems, fmt.Sprintf("vdev guid sum %#x does not match uber block guid sum %#x", e.GuidSum, e.UberBlockGuidSum))
+	}
+
+	return strings.Join(problems, "; ")
+}
+
+// vdevName returns a description of v suitable for error messages.
+func vdevName(v VdevTree) string {
+	switch v := v.(type) {
+	case *DiskVdev:
+		return fmt.Sprintf("%s (guid %d)", v.Path, v.GUID)
+	case *FileVdev:
+		return fmt.Sprintf("%s (guid %d)", v.Path, v.GUID)
+	default:
+		return fmt.Sprintf("%s vdev %d (guid %d)", v.Common().Type, v.Common().ID, v.Common().GUID)
+	}
+}
@@ -0,0 +1,227 @@
+// Copyright 2018 Ayan George.
+// All rights reserved.  Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package zfs_test
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/ayang64/ztool/zfs"
+)
+
+func TestNewPool(t *testing.T) {
+	devices := map[uint64][]byte{
+		11: poolDevice(testPoolGUID, 11, 10, 40),
+		12: poolDevice(testPoolGUID, 12, 10, 41),
+		20: poolDevice(testPoolGUID, 20, 20, 42),
+	}
+
+	with := func(guids ...uint64) []func(*zfs.Pool) error {
+		rc := []func(*zfs.Pool) error{}
+		for _, g := range guids {
+			rc = append(rc, zfs.WithDevice(fmt.Sprintf("dev%d", g), bytes.NewReader(devices[g])))
+		}
+		return rc
+	}
+
+	t.Run("complete", func(t *testing.T) {
+		p, err := zfs.NewPool(with(11, 12, 20)...)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if err := p.Check(); err != nil {
+			t.Fatal(err)
+		}
+
+		if p.GUID() != testPoolGUID || p.GuidSum() != testPoolGuidSum {
+			t.Errorf("pool guid %d, guid sum %d", p.GUID(), p.GuidSum())
+		}
+
+		top := p.Root().Children()
+		if _, ok := top[0].(*zfs.MirrorVdev); !ok {
+			t.Errorf("top level vdev 0 is %T; expected *zfs.MirrorVdev", top[0])
+		}
+		if _, ok := top[1].(*zfs.DiskVdev); !ok {
+			t.Errorf("top level vdev 1 is %T; expected *zfs.DiskVdev", top[1])
+		}
+
+		for _, g := range []uint64{11, 12, 20} {
+			if d, found := p.Device(g); !found || d.Name != fmt.Sprintf("dev%d", g) {
+				t.Errorf("leaf %d is backed by %v", g, d)
+			}
+		}
+
+		ub, err := p.ActiveUberBlock()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if ub.TransactionGroup != 42 {
+			t.Errorf("active uber block is from txg %d; expected 42", ub.TransactionGroup)
+		}
+	})
+
+	t.Run("missing mirror child", func(t *testing.T) {
+		p, err := zfs.NewPool(with(11, 20)...)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var ae *zfs.AssemblyError
+		if err := p.Check(); !errors.As(err, &ae) {
+			t.Fatalf("Check() = %v; expected *AssemblyError", err)
+		}
+
+		if len(ae.Missing) != 1 || ae.Missing[0].Common().GUID != 12 || len(ae.Extra) != 0 {
+			t.Errorf("missing %v, extra %v", ae.Missing, ae.Extra)
+		}
+
+		if ae.GuidSum != ae.UberBlockGuidSum {
+			t.Errorf("guid sum %d does not match %d", ae.GuidSum, ae.UberBlockGuidSum)
+		}
+	})
+
+	t.Run("missing top level vdev", func(t *testing.T) {
+		p, err := zfs.NewPool(with(11, 12)...)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var ae *zfs.AssemblyError
+		if err := p.Check(); !errors.As(err, &ae) {
+			t.Fatalf("Check() = %v; expected *AssemblyError", err)
+		}
+
+		if len(ae.Missing) != 1 {
+			t.Fatalf("missing %v", ae.Missing)
+		}
+
+		if _, ok := ae.Missing[0].(*zfs.MissingVdev); !ok {
+			t.Errorf("missing vdev is %T; expected *zfs.MissingVdev", ae.Missing[0])
+		}
+
+		if ae.GuidSum != testPoolGuidSum-20 || ae.UberBlockGuidSum != testPoolGuidSum {
+			t.Errorf("guid sum %d, uber block guid sum %d", ae.GuidSum, ae.UberBlockGuidSum)
+		}
+	})
+
+	t.Run("spare from another pool", func(t *testing.T) {
+		// a spare shared with a pool that has moved on must not supply the
+		// uber block.
+		opts := append(with(11, 12, 20),
+			zfs.WithDevice("spare", bytes.NewReader(poolDevice(999, 30, 20, 50))))
+
+		cfg := zfs.PoolConfig{
+			Name: "tank",
+			GUID: testPoolGUID,
+			VdevTree: zfs.VdevConfig{
+				Type: "root",
+				GUID: testPoolGUID,
+				Children: []zfs.VdevConfig{
+					{Type: "mirror", GUID: 10, Children: []zfs.VdevConfig{{Type: "disk", GUID: 11}, {Type: "disk", GUID: 12}}},
+					{Type: "disk", ID: 1, GUID: 20},
+				},
+				Spares: []zfs.VdevConfig{{Type: "disk", GUID: 30}},
+			},
+		}
+		opts = append(opts, zfs.WithPoolConfig(&cfg))
+
+		p, err := zfs.NewPool(opts...)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if d, _ := p.Device(30); d == nil || d.Name != "spare" {
+			t.Fatalf("spare is backed by %v", d)
+		}
+
+		ub, err := p.ActiveUberBlock()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if ub.TransactionGroup != 42 {
+			t.Errorf("active uber block is from txg %d; expected 42", ub.TransactionGroup)
+		}
+	})
+
+	t.Run("extra devices", func(t *testing.T) {
+		opts := append(with(11, 12, 20),
+			zfs.WithDevice("other", bytes.NewReader(poolDevice(999, 11, 10, 50))),
+			zfs.WithDevice("blank", bytes.NewReader(make([]byte, 2<<20))),
+		)
+
+		// the other pool's label is newer so the pool must be named
+		// explicitly.
+		cfg := zfs.PoolConfig{
+			Name: "tank",
+			GUID: testPoolGUID,
+			VdevTree: zfs.VdevConfig{
+				Type: "root",
+				GUID: testPoolGUID,
+				Children: []zfs.VdevConfig{
+					{Type: "mirror", GUID: 10, Children: []zfs.VdevConfig{{Type: "disk", GUID: 11}, {Type: "disk", GUID: 12}}},
+					{Type: "disk", ID: 1, GUID: 20},
+				},
+			},
+		}
+		opts = append(opts, zfs.WithPoolConfig(&cfg))
+
+		p, err := zfs.NewPool(opts...)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var ae *zfs.AssemblyError
+		if err := p.Check(); !errors.As(err, &ae) {
+			t.Fatalf("Check() = %v; expected *AssemblyError", err)
+		}
+
+		names := []string{}
+		for _, d := range ae.Extra {
+			names = append(names, d.Name)
+		}
+
+		if len(ae.Missing) != 0 || fmt.Sprint(names) != "[other blank]" {
+			t.Errorf("missing %v, extra %q", ae.Missing, names)
+		}
+
+		if d, _ := p.Device(11); d == nil || d.Name != "dev11" {
+			t.Errorf("leaf 11 is backed by %v", d)
+		}
+	})
+}
+
+func TestPoolClose(t *testing.T) {
+	img := poolDevice(testPoolGUID, 20, 20, 40)
+	data := bytes.Repeat([]byte("closed"), 512)[:2048]
+	copy(img[4<<20+8<<9:], data)
+
+	path := filepath.Join(t.TempDir(), "dev20")
+	if err := os.WriteFile(path, img, 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	p, err := zfs.NewPool(zfs.WithDevicePath(path))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	bp := dataBlock(1, 8, data)
+	if got, err := p.ReadPhysical(&bp); err != nil || !bytes.Equal(got, data) {
+		t.Fatalf("ReadPhysical() = %q, %v", got, err)
+	}
+
+	if err := p.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := p.ReadPhysical(&bp); err == nil {
+		t.Errorf("ReadPhysical() succeeded after Close")
+	}
+}
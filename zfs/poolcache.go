@@ -0,0 +1,53 @@
+// Copyright 2018 Ayan George.
+// All rights reserved.  Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package zfs
+
+import (
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/ayang64/ztool/zfs/nvlist"
+)
+
+// ReadPoolCache reads the pool configurations stored in the zpool.cache file
+// at path.
+func ReadPoolCache(path string) ([]PoolConfig, error) {
+	fh, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer fh.Close()
+
+	return DecodePoolCache(fh)
+}
+
+// DecodePoolCache decodes the pool configurations in a zpool.cache file read
+// from r.  The cache is a packed nvlist, usually natively encoded, with one
+// NVList pair per pool.  Pools are returned in the order they appear in the
+// cache.
+func DecodePoolCache(r io.Reader) ([]PoolConfig, error) {
+	o, err := nvlist.ReadOrdered(r)
+	if err != nil {
+		return nil, fmt.Errorf("cannot read pool cache; %w", err)
+	}
+
+	rc := []PoolConfig{}
+	for _, p := range o.Pairs {
+		l, ok := p.Value.(nvlist.OrderedList)
+		if !ok {
+			return nil, fmt.Errorf("pool cache entry %q is %s; expected %s", p.Name, p.Type, nvlist.NVList)
+		}
+
+		var pc PoolConfig
+		if err := nvlist.UnmarshalList(l.List(), &pc); err != nil {
+			return nil, fmt.Errorf("pool %q: %w", p.Name, err)
+		}
+
+		rc = append(rc, pc)
+	}
+
+	return rc, nil
+}
@@ -0,0 +1,68 @@
+// Copyright 2018 Ayan George.
+// All rights reserved.  Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package zfs_test
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/ayang64/ztool/zfs"
+)
+
+func TestReadPoolCache(t *testing.T) {
+	pools, err := zfs.ReadPoolCache("../test-data/zpool.cache")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	type leaf struct {
+		Path string
+		GUID uint64
+	}
+
+	expected := []struct {
+		Name   string
+		GUID   uint64
+		Leaves []leaf
+	}{
+		{Name: "jbod", GUID: 4372009680223183607, Leaves: []leaf{{"/dev/ada5", 8939574674627541862}}},
+		{Name: "zlocal", GUID: 1354235212842945083, Leaves: []leaf{{"/dev/label/zlocal0", 2847083331999755807}}},
+		{Name: "zmirror0", GUID: 18439080933961865546, Leaves: []leaf{
+			{"/dev/label/zbackup0", 11357257273031114030},
+			{"/dev/label/zbackup1", 720395683767109767},
+			{"/dev/label/zbackup2", 5822118969348018013},
+		}},
+		{Name: "ztorrent", GUID: 18185438142404143507, Leaves: []leaf{{"/dev/diskid/DISK-5XW0XFX0", 17692772502332878352}}},
+	}
+
+	if len(pools) != len(expected) {
+		t.Fatalf("read %d pools; expected %d", len(pools), len(expected))
+	}
+
+	for i, e := range expected {
+		p := pools[i]
+		if p.Name != e.Name || p.GUID != e.GUID {
+			t.Errorf("pool %d is %q (%d); expected %q (%d)", i, p.Name, p.GUID, e.Name, e.GUID)
+		}
+
+		if p.VdevTree.Type != "root" || p.VdevTree.GUID != p.GUID {
+			t.Errorf("%s: vdev tree root is %q (%d)", p.Name, p.VdevTree.Type, p.VdevTree.GUID)
+		}
+
+		leaves := []leaf{}
+		for _, l := range p.VdevTree.Leaves() {
+			leaves = append(leaves, leaf{l.Path, l.GUID})
+		}
+
+		if !reflect.DeepEqual(leaves, e.Leaves) {
+			t.Errorf("%s: leaves are %v; expected %v", p.Name, leaves, e.Leaves)
+		}
+	}
+
+	mirror := pools[2].VdevTree.Children[0]
+	if mirror.Type != "mirror" || mirror.AShift != 12 || len(mirror.Children) != 2 {
+		t.Errorf("zmirror0 top level vdev is %+v", mirror)
+	}
+}
@@ -0,0 +1,418 @@
+// Copyright 2018 Ayan George.
+// All rights reserved.  Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package zfs
+
+import (
+	"errors"
+	"fmt"
+)
+
+// RAID-Z parity is computed over GF(2^8) with the polynomial
+// x^8 + x^4 + x^3 + x^2 + 1.  P is the XOR of the data columns, Q weights
+// them by powers of 2 and R by powers of 4.
+var gfExp, gfLog = func() (exp [512]byte, log [256]byte) {
+	x := 1
+	for i := 0; i < 255; i++ {
+		exp[i] = byte(x)
+		log[x] = byte(i)
+		x <<= 1
+		if x&0x100 != 0 {
+			x ^= 0x11d
+		}
+	}
+	for i := 255; i < len(exp); i++ {
+		exp[i] = exp[i-255]
+	}
+	return
+}()
+
+func gfMul(a, b byte) byte {
+	if a == 0 || b == 0 {
+		return 0
+	}
+	return gfExp[int(gfLog[a])+int(gfLog[b])]
+}
+
+func gfInv(a byte) byte {
+	return gfExp[255-int(gfLog[a])]
+}
+
+// gfPow returns a raised to the power e.
+func gfPow(a byte, e int) byte {
+	if e == 0 {
+		return 1
+	}
+	return gfExp[(int(gfLog[a])*e)%255]
+}
+
+// gfInvert inverts the square matrix m in place.
+func gfInvert(m [][]byte) error {
+	n := len(m)
+	inv := make([][]byte, n)
+	for i := range inv {
+		inv[i] = make([]byte, n)
+		inv[i][i] = 1
+	}
+
+	for c := 0; c < n; c++ {
+		pivot := -1
+		for r := c; r < n; r++ {
+			if m[r][c] != 0 {
+				pivot = r
+				break
+			}
+		}
+		if pivot < 0 {
+			return errors.New("parity matrix is singular")
+		}
+		m[c], m[pivot] = m[pivot], m[c]
+		inv[c], inv[pivot] = inv[pivot], inv[c]
+
+		scale := gfInv(m[c][c])
+		for i := 0; i < n; i++ {
+			m[c][i] = gfMul(m[c][i], scale)
+			inv[c][i] = gfMul(inv[c][i], scale)
+		}
+
+		for r := 0; r < n; r++ {
+			if r == c || m[r][c] == 0 {
+				continue
+			}
+			f := m[r][c]
+			for i := 0; i < n; i++ {
+				m[r][i] ^= gfMul(f, m[c][i])
+				inv[r][i] ^= gfMul(f, inv[c][i])
+			}
+		}
+	}
+
+	copy(m, inv)
+	return nil
+}
+
+// RaidzColumn is the part of a RAID-Z block stored on one child.
+type RaidzColumn struct {
+	Child  int    // index of the child vdev.
+	Offset uint64 // offset within the child.
+	Size   uint64
+}
+
+// RaidzMap describes how a block is laid out across the children of a RAID-Z
+// vdev.  The first NParity columns hold parity and the rest hold the data in
+// order.
+type RaidzMap struct {
+	NParity int
+	Columns []RaidzColumn
+	Size    uint64 // bytes of data held by the data columns.
+}
+
+// NewRaidzMap maps the block of size bytes at offset within a RAID-Z vdev
+// with the given ashift, number of children and parity.
+func NewRaidzMap(offset, size, ashift, children, nparity uint64) *RaidzMap {
+	b := offset >> ashift
+	s := (size + 1<<ashift - 1) >> ashift
+	f := b % children
+	o := (b / children) << ashift
+
+	q := s / (children - nparity)
+	r := s - q*(children-nparity)
+	bc := uint64(0)
+	if r != 0 {
+		bc = r + nparity
+	}
+
+	acols := children
+	if q == 0 {
+		acols = bc
+	}
+
+	m := RaidzMap{NParity: int(nparity), Size: size}
+	for c := uint64(0); c < acols; c++ {
+		col := f + c
+		coff := o
+		if col >= children {
+			col -= children
+			coff += 1 << ashift
+		}
+
+		csize := q << ashift
+		if c < bc {
+			csize = (q + 1) << ashift
+		}
+
+		m.Columns = append(m.Columns, RaidzColumn{Child: int(col), Offset: coff, Size: csize})
+	}
+
+	// single parity vdevs swap the parity and first data column every
+	// megabyte to spread parity across the children.
+	if nparity == 1 && offset&(1<<20) != 0 {
+		m.Columns[0].Child, m.Columns[1].Child = m.Columns[1].Child, m.Columns[0].Child
+		m.Columns[0].Offset, m.Columns[1].Offset = m.Columns[1].Offset, m.Columns[0].Offset
+	}
+
+	return &m
+}
+
+// coefficient returns the weight of data column j in parity column p.
+func (m *RaidzMap) coefficient(p, j int) byte {
+	ndata := len(m.Columns) - m.NParity
+	return gfPow(byte(1<<uint(p)), ndata-1-j)
+}
+
+// Generate computes the parity columns of cols from its data columns.
+// cols holds one buffer per column, each of the column's size.
+func (m *RaidzMap) Generate(cols [][]byte) {
+	for p := 0; p < m.NParity; p++ {
+		m.generate(cols, p)
+	}
+}
+
+func (m *RaidzMap) generate(cols [][]byte, p int) {
+	pc := cols[p]
+	for i := range pc {
+		pc[i] = 0
+	}
+	for c := m.NParity; c < len(cols); c++ {
+		w := m.coefficient(p, c-m.NParity)
+		for i, d := range cols[c] {
+			pc[i] ^= gfMul(w, d)
+		}
+	}
+}
+
+// Reconstruct rebuilds the columns listed in missing from the remaining
+// columns of cols.  It fails if more data columns are missing than there are
+// parity columns left to rebuild them from.
+func (m *RaidzMap) Reconstruct(cols [][]byte, missing []int) error {
+	lost := map[int]bool{}
+	for _, c := range missing {
+		lost[c] = true
+	}
+
+	parity := []int{}
+	for p := 0; p < m.NParity; p++ {
+		if !lost[p] {
+			parity = append(parity, p)
+		}
+	}
+
+	data := []int{}
+	for c := m.NParity; c < len(cols); c++ {
+		if lost[c] {
+			data = append(data, c)
+		}
+	}
+
+	if len(data) > len(parity) {
+		return fmt.Errorf("%d data columns are missing but only %d parity columns are usable", len(data), len(parity))
+	}
+	parity = parity[:len(data)]
+
+	if len(data) > 0 {
+		mat := make([][]byte, len(data))
+		for r, p := range parity {
+			mat[r] = make([]byte, len(data))
+			for i, c := range data {
+				mat[r][i] = m.coefficient(p, c-m.NParity)
+			}
+		}
+		if err := gfInvert(mat); err != nil {
+			return err
+		}
+
+		syn := make([]byte, len(parity))
+		for i := range cols[0] {
+			for r, p := range parity {
+				s := cols[p][i]
+				for c := m.NParity; c < len(cols); c++ {
+					if !lost[c] && i < len(cols[c]) {
+						s ^= gfMul(m.coefficient(p, c-m.NParity), cols[c][i])
+					}
+				}
+				syn[r] = s
+			}
+
+			for r, c := range data {
+				if i >= len(cols[c]) {
+					continue
+				}
+				var x byte
+				for k, s := range syn {
+					x ^= gfMul(mat[r][k], s)
+				}
+				cols[c][i] = x
+			}
+		}
+	}
+
+	for p := 0; p < m.NParity; p++ {
+		if lost[p] {
+			m.generate(cols, p)
+		}
+	}
+
+	return nil
+}
+
+// Data returns the data columns of cols joined together and cut to the size
+// of the block.
+func (m *RaidzMap) Data(cols [][]byte) []byte {
+	rc := []byte{}
+	for _, c := range cols[m.NParity:] {
+		rc = append(rc, c...)
+	}
+	return rc[:m.Size]
+}
+
+// rebuild calls f with the data of cols rebuilt under each assumption about
+// which columns are bad until f returns false.  missing lists the columns that
+// could not be read and are always rebuilt.  Combinations of other data
+// columns, up to the number of parity columns left over, are assumed bad in
+// turn; fewer first.  f is also passed the columns rebuilt beyond missing.
+func (m *RaidzMap) rebuild(cols [][]byte, missing []int, f func(data []byte, rebuilt []int) bool) error {
+	candidates := []int{}
+	for c := m.NParity; c < len(cols); c++ {
+		if !containsInt(missing, c) && len(cols[c]) > 0 {
+			candidates = append(candidates, c)
+		}
+	}
+
+	if len(missing) > m.NParity {
+		return fmt.Errorf("%d columns are missing but there are only %d parity columns", len(missing), m.NParity)
+	}
+
+	var err error
+	more := true
+	for n := 0; more && n+len(missing) <= m.NParity; n++ {
+		forEachCombination(candidates, n, func(extra []int) bool {
+			try := make([][]byte, len(cols))
+			for i := range cols {
+				try[i] = append([]byte{}, cols[i]...)
+			}
+
+			if err = m.Reconstruct(try, append(append([]int{}, missing...), extra...)); err != nil {
+				return true
+			}
+
+			more = f(m.Data(try), append([]int{}, extra...))
+			return more
+		})
+	}
+
+	return err
+}
+
+func containsInt(s []int, v int) bool {
+	for _, e := range s {
+		if e == v {
+			return true
+		}
+	}
+	return false
+}
+
+// forEachCombination calls f with every n element subset of s until f returns
+// false.
+func forEachCombination(s []int, n int, f func([]int) bool) {
+	var walk func(start int, picked []int) bool
+	walk = func(start int, picked []int) bool {
+		if len(picked) == n {
+			return f(picked)
+		}
+		for i := start; i < len(s); i++ {
+			if !walk(i+1, append(picked, s[i])) {
+				return false
+			}
+		}
+		return true
+	}
+	walk(0, []int{})
+}
+
+// readRaidz reads the block of size bytes at offset from the RAID-Z vdev v,
+// rebuilding columns that cannot be read or fail verification from parity.
+func (p *Pool) readRaidz(v *RaidzVdev, offset uint64, size int, verify func([]byte) error, bad func(VdevTree, error)) ([]byte, error) {
+	children := v.Children()
+	m := NewRaidzMap(offset, uint64(size), v.AShift, uint64(len(children)), v.NParity)
+	return p.readRows(v, []*RaidzMap{m}, children, size, verify, bad)
+}
+
+// readRows reads the columns of each row of a block from children and
+// recovers the block from them.  The rows are rebuilt together since only the
+// block as a whole can be verified.
+func (p *Pool) readRows(v VdevTree, rows []*RaidzMap, children []VdevTree, size int, verify func([]byte) error, bad func(VdevTree, error)) ([]byte, error) {
+	cols := make([][][]byte, len(rows))
+	missing := make([][]int, len(rows))
+
+	for r, m := range rows {
+		cols[r] = make([][]byte, len(m.Columns))
+		for i, c := range m.Columns {
+			if c.Size == 0 {
+				cols[r][i] = []byte{}
+				continue
+			}
+
+			data, err := p.readVdev(children[c.Child], c.Offset, int(c.Size), nil, bad)
+			if err != nil {
+				missing[r] = append(missing[r], i)
+				data = make([]byte, c.Size)
+			}
+			cols[r][i] = data
+		}
+	}
+
+	var (
+		data    []byte
+		rebuilt = make([][]int, len(rows))
+		verr    error
+	)
+
+	var try func(r int, acc []byte) (bool, error)
+	try = func(r int, acc []byte) (bool, error) {
+		if r == len(rows) {
+			acc = acc[:size]
+			if verify != nil {
+				if verr = verify(acc); verr != nil {
+					return false, nil
+				}
+			}
+			data = acc
+			return true, nil
+		}
+
+		found := false
+		var ferr error
+		err := rows[r].rebuild(cols[r], missing[r], func(d []byte, extra []int) bool {
+			found, ferr = try(r+1, append(append([]byte{}, acc...), d...))
+			if found {
+				rebuilt[r] = extra
+			}
+			return !found && ferr == nil
+		})
+		if ferr != nil {
+			return false, ferr
+		}
+		if !found && err != nil {
+			return false, err
+		}
+		return found, nil
+	}
+
+	found, err := try(0, []byte{})
+	if err == nil && !found {
+		err = verr
+	}
+	if err != nil {
+		return nil, fmt.Errorf("cannot reconstruct block on %s: %w", vdevName(v), err)
+	}
+
+	for r, m := range rows {
+		for _, i := range rebuilt[r] {
+			bad(children[m.Columns[i].Child], fmt.Errorf("column %d at %#x did not match the block checksum and was rebuilt from parity", i, m.Columns[i].Offset))
+		}
+	}
+
+	return data, nil
+}
@@ -0,0 +1,296 @@
+// Copyright 2018 Ayan George.
+// All rights reserved.  Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package zfs_test
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"math/rand"
+	"reflect"
+	"testing"
+
+	"github.com/ayang64/ztool/zfs"
+	"github.com/ayang64/ztool/zfs/nvlist"
+)
+
+// guidSum returns the sum of the guids of v and every vdev below it.
+func guidSum(v nvlist.List) uint64 {
+	sum := v["guid"].(uint64)
+	if children, ok := v["children"].([]nvlist.List); ok {
+		for _, c := range children {
+			sum += guidSum(c)
+		}
+	}
+	return sum
+}
+
+// singleTopPool returns images of the devices, by guid, of a pool whose only
+// top level vdev is top.
+func singleTopPool(top nvlist.List, leaves ...uint64) map[uint64][]byte {
+	rc := map[uint64][]byte{}
+	for _, g := range leaves {
+		img := labelImage(8<<20, xdrList(
+			"version", uint64(5000),
+			"name", "tank",
+			"state", uint64(0),
+			"txg", uint64(40),
+			"pool_guid", uint64(testPoolGUID),
+			"guid", g,
+			"top_guid", top["guid"],
+			"vdev_children", uint64(1),
+			"vdev_tree", top,
+		))
+
+		putUberBlock(img, 10, 0, zfs.UberBlock{
+			Magic:            zfs.UberBlockMagic,
+			TransactionGroup: 40,
+			GuidSum:          testPoolGUID + guidSum(top),
+		}, binary.LittleEndian)
+
+		rc[g] = img
+	}
+	return rc
+}
+
+func TestNewRaidzMap(t *testing.T) {
+	col := func(child int, offset, size uint64) zfs.RaidzColumn {
+		return zfs.RaidzColumn{Child: child, Offset: offset, Size: size}
+	}
+
+	tests := map[string]struct {
+		Offset, Size, Children, NParity uint64
+		Expected                        []zfs.RaidzColumn
+	}{
+		"full row": {
+			Offset: 0, Size: 2048, Children: 5, NParity: 1,
+			Expected: []zfs.RaidzColumn{col(0, 0, 512), col(1, 0, 512), col(2, 0, 512), col(3, 0, 512), col(4, 0, 512)},
+		},
+		"wraps to next row": {
+			Offset: 3 * 512, Size: 1536, Children: 5, NParity: 2,
+			Expected: []zfs.RaidzColumn{col(3, 0, 512), col(4, 0, 512), col(0, 512, 512), col(1, 512, 512), col(2, 512, 512)},
+		},
+		"partial row": {
+			Offset: 0, Size: 5 * 512, Children: 4, NParity: 1,
+			Expected: []zfs.RaidzColumn{col(0, 0, 1024), col(1, 0, 1024), col(2, 0, 1024), col(3, 0, 512)},
+		},
+		"short block": {
+			Offset: 0, Size: 512, Children: 6, NParity: 3,
+			Expected: []zfs.RaidzColumn{col(0, 0, 512), col(1, 0, 512), col(2, 0, 512), col(3, 0, 512)},
+		},
+		"rounds up to sector": {
+			Offset: 0, Size: 100, Children: 3, NParity: 1,
+			Expected: []zfs.RaidzColumn{col(0, 0, 512), col(1, 0, 512)},
+		},
+		"single parity swap": {
+			Offset: 1 << 20, Size: 1024, Children: 4, NParity: 1,
+			Expected: []zfs.RaidzColumn{col(1, 1<<18, 512), col(0, 1<<18, 512), col(2, 1<<18, 512)},
+		},
+	}
+
+	for name, test := range tests {
+		test := test
+		t.Run(name, func(t *testing.T) {
+			m := zfs.NewRaidzMap(test.Offset, test.Size, 9, test.Children, test.NParity)
+			if m.NParity != int(test.NParity) {
+				t.Errorf("map has %d parity columns; expected %d", m.NParity, test.NParity)
+			}
+			if !reflect.DeepEqual(m.Columns, test.Expected) {
+				t.Fatalf("columns %v; expected %v", m.Columns, test.Expected)
+			}
+		})
+	}
+}
+
+// raidzColumns returns the columns of m filled with random data and parity.
+func raidzColumns(m *zfs.RaidzMap, rnd *rand.Rand) [][]byte {
+	cols := make([][]byte, len(m.Columns))
+	for i, c := range m.Columns {
+		cols[i] = make([]byte, c.Size)
+		if i >= m.NParity {
+			rnd.Read(cols[i])
+		}
+	}
+	m.Generate(cols)
+	return cols
+}
+
+func TestRaidzReconstruct(t *testing.T) {
+	rnd := rand.New(rand.NewSource(1))
+
+	for nparity := uint64(1); nparity <= 3; nparity++ {
+		nparity := nparity
+		t.Run(fmt.Sprintf("raidz%d", nparity), func(t *testing.T) {
+			// a partial row so the columns differ in size.
+			m := zfs.NewRaidzMap(0, 11*512, 9, nparity+5, nparity)
+			orig := raidzColumns(m, rnd)
+
+			var lose func(start int, missing []int)
+			lose = func(start int, missing []int) {
+				if len(missing) > 0 {
+					cols := make([][]byte, len(orig))
+					for i := range orig {
+						cols[i] = append([]byte{}, orig[i]...)
+					}
+					for _, c := range missing {
+						for i := range cols[c] {
+							cols[c][i] = 0xa5
+						}
+					}
+
+					if err := m.Reconstruct(cols, missing); err != nil {
+						t.Fatalf("missing %v: %v", missing, err)
+					}
+					if !reflect.DeepEqual(cols, orig) {
+						t.Fatalf("missing %v: reconstructed the wrong data", missing)
+					}
+				}
+
+				if len(missing) == int(nparity) {
+					return
+				}
+				for c := start; c < len(orig); c++ {
+					lose(c+1, append(append([]int{}, missing...), c))
+				}
+			}
+			lose(0, nil)
+
+			cols := make([][]byte, len(orig))
+			copy(cols, orig)
+			missing := []int{}
+			for c := 0; c <= int(nparity); c++ {
+				missing = append(missing, int(nparity)+c)
+			}
+			if err := m.Reconstruct(cols, missing); err == nil {
+				t.Fatalf("reconstructed %d data columns with %d parity columns", len(missing), nparity)
+			}
+		})
+	}
+}
+
+func TestPoolReadRaidz(t *testing.T) {
+	const size = 7 * 512
+
+	leaves := []uint64{11, 12, 13, 14, 15, 16}
+	children := []nvlist.List{}
+	for i, g := range leaves {
+		children = append(children, disk(g, fmt.Sprintf("/dev/da%d", i)))
+	}
+
+	rnd := rand.New(rand.NewSource(2))
+	data := make([]byte, size)
+	rnd.Read(data)
+
+	for nparity := uint64(1); nparity <= 3; nparity++ {
+		top := nvlist.List{
+			"type": "raidz", "id": uint64(0), "guid": uint64(10), "ashift": uint64(9),
+			"nparity": nparity, "children": children,
+		}
+
+		// the block is placed so it wraps onto a second row.
+		bp := zfs.BlockPointer{Props: blockProps(size)}
+		bp.Vdevs[0] = zfs.NewDVA(0, (16+4)<<9, false)
+		bp.ChecksumList, _ = zfs.BlockChecksum(zfs.ChecksumFletcher4, data, binary.LittleEndian)
+
+		m := zfs.NewRaidzMap(bp.Vdevs[0].VdevOffset(), size, 9, uint64(len(leaves)), nparity)
+
+		tests := map[string]struct {
+			Absent  []int // columns whose device is left out of the pool.
+			Corrupt []int // columns whose contents are damaged.
+			Fail    bool
+		}{
+			"intact":          {},
+			"lost parity":     {Absent: []int{0}},
+			"damaged data":    {Corrupt: []int{int(nparity)}},
+			"all parity lost": {Absent: seq(0, int(nparity))},
+			"data lost":       {Absent: seq(int(nparity), int(nparity))},
+			"lost and damaged": {
+				Absent:  seq(int(nparity), int(nparity)-1),
+				Corrupt: []int{len(m.Columns) - 1},
+			},
+			"too many lost": {Absent: seq(0, int(nparity)+1), Fail: true},
+		}
+
+		for name, test := range tests {
+			test := test
+			t.Run(fmt.Sprintf("raidz%d %s", nparity, name), func(t *testing.T) {
+				images := singleTopPool(top, leaves...)
+
+				cols := make([][]byte, len(m.Columns))
+				rest := data
+				for i, c := range m.Columns {
+					cols[i] = make([]byte, c.Size)
+					if i >= m.NParity {
+						rest = rest[copy(cols[i], rest):]
+					}
+				}
+				m.Generate(cols)
+
+				for i, c := range m.Columns {
+					img := images[leaves[c.Child]]
+					copy(img[4<<20+c.Offset:], cols[i])
+					if containsColumn(test.Corrupt, i) {
+						img[4<<20+c.Offset] ^= 0xff
+					}
+				}
+
+				bad := []zfs.BadCopy{}
+				opts := []func(*zfs.Pool) error{
+					zfs.WithBadCopyHandler(func(bc zfs.BadCopy) { bad = append(bad, bc) }),
+				}
+				for i, g := range leaves {
+					absent := false
+					for _, c := range test.Absent {
+						absent = absent || m.Columns[c].Child == i
+					}
+					if !absent {
+						opts = append(opts, zfs.WithDevice(fmt.Sprintf("dev%d", g), bytes.NewReader(images[g])))
+					}
+				}
+
+				p, err := zfs.NewPool(opts...)
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				got, err := p.ReadPhysical(&bp)
+				if test.Fail {
+					if err == nil {
+						t.Fatal("read a block with too many columns missing")
+					}
+					return
+				}
+				if err != nil {
+					t.Fatal(err)
+				}
+				if !bytes.Equal(got, data) {
+					t.Fatal("read the wrong data")
+				}
+
+				if len(bad) != len(test.Absent)+len(test.Corrupt) {
+					t.Errorf("reported %v as bad", bad)
+				}
+			})
+		}
+	}
+}
+
+// seq returns n column indices starting at start.
+func seq(start, n int) []int {
+	rc := []int{}
+	for i := 0; i < n; i++ {
+		rc = append(rc, start+i)
+	}
+	return rc
+}
+
+func containsColumn(cols []int, c int) bool {
+	for _, e := range cols {
+		if e == c {
+			return true
+		}
+	}
+	return false
+}
@@ -5,13 +5,27 @@
 package zfs
 
 import (
+	"encoding/binary"
 	"fmt"
 	"io"
+	"math/bits"
 	"time"
 )
 
+const (
+	// UberBlockMagic is the value of UberBlock.Magic in a valid uber block.
+	UberBlockMagic = 0x00bab10c // oo-ba-bloc!
+
+	// mmpMagic is set in UberBlock.MMPMagic by pools with multihost enabled.
+	mmpMagic = 0xa11cea11
+
+	// bit in UberBlock.MMPConfig that denotes a valid MMP sequence number.
+	mmpSeqValidBit = 0x02
+)
+
 type ActiveUberBlock struct {
-	AShift uint
+	AShift    uint
+	ByteOrder binary.ByteOrder // byte order the uber block was written in
 	UberBlock
 }
 
@@ -35,10 +49,105 @@ type UberBlock struct {
 	Timestamp        uint64       // time of last sync
 	RootBP           BlockPointer // mos objset_phys_t
 	SoftwareVersion  uint64       // FreeBSD is usually 5000
-	PAD              [3]uint64    // padding
+	MMPMagic         uint64       // multi-modifier protection magic
+	MMPDelay         uint64       // nanosec since last MMP write
+	MMPConfig        uint64       // MMP sequence number, interval and fail intervals
 	CheckpointTx     uint64       // Checkpoint Transaction
 }
 
+// MMPValid reports whether the multi-modifier protection fields are in use.
+func (ub *UberBlock) MMPValid() bool {
+	return ub.MMPMagic == mmpMagic
+}
+
+// MMPSeqValid reports whether MMPSeq holds a valid sequence number.
+func (ub *UberBlock) MMPSeqValid() bool {
+	return ub.MMPValid() && ub.MMPConfig&mmpSeqValidBit != 0
+}
+
+// MMPSeq returns the MMP sequence number; it distinguishes uber blocks written
+// in the same txg at the same second.
+func (ub *UberBlock) MMPSeq() uint64 {
+	return (ub.MMPConfig >> 32) & 0xffff
+}
+
+// CompareUberBlocks returns -1, 0 or 1 if a is older than, the same age as or
+// newer than b.  Like vdev_uberblock_compare(), the transaction group is
+// compared first and the timestamp and MMP sequence number break ties.
+func CompareUberBlocks(a, b *UberBlock) int {
+	cmp := func(x, y uint64) int {
+		switch {
+		case x < y:
+			return -1
+		case x > y:
+			return 1
+		default:
+			return 0
+		}
+	}
+
+	if c := cmp(a.TransactionGroup, b.TransactionGroup); c != 0 {
+		return c
+	}
+
+	if c := cmp(a.Timestamp, b.Timestamp); c != 0 {
+		return c
+	}
+
+	// uber blocks without a valid sequence number count as sequence 0 so
+	// one with a valid sequence of 0 is the same age.
+	var aseq, bseq uint64
+	if a.MMPSeqValid() {
+		aseq = a.MMPSeq()
+	}
+	if b.MMPSeqValid() {
+		bseq = b.MMPSeq()
+	}
+
+	return cmp(aseq, bseq)
+}
+
+// uberBlockOrder returns the byte order of the uber block in buf as determined
+// by its magic number.
+func uberBlockOrder(buf []byte) (binary.ByteOrder, error) {
+	switch magic := binary.LittleEndian.Uint64(buf); magic {
+	case UberBlockMagic:
+		return binary.LittleEndian, nil
+	case bits.ReverseBytes64(UberBlockMagic):
+		return binary.BigEndian, nil
+	default:
+		return nil, fmt.Errorf("bad uber block magic %#016x", magic)
+	}
+}
+
+// UberBlockSlot is one slot of the uber block ring stored in each label.  Err
+// is set if the slot is empty, has a bad magic number or fails its checksum.
+type UberBlockSlot struct {
+	Index     int              // position in the ring
+	Offset    int64            // byte offset of the slot on the device
+	ByteOrder binary.ByteOrder // byte order the uber block was written in
+	UberBlock
+	Err error
+}
+
+// Valid reports whether the slot holds an uber block that passed its checksum.
+func (s *UberBlockSlot) Valid() bool {
+	return s.Err == nil
+}
+
+// uberBlockShift returns log2 of the size of an uber block slot on a vdev with
+// the given ashift.  Slots are never smaller than 1k or larger than 8k.
+func uberBlockShift(ashift uint) uint {
+	switch {
+	case ashift < 10:
+		return 10
+	case ashift > 13:
+		return 13
+	default:
+		return ashift
+	}
+}
+
 func (ub *UberBlock) MOS(rs io.ReadSeeker) (*DnodePhys, error) {
 	/*
 		// the MOS is always element 1 in the UberBlocks's Vdev
@@ -49,7 +158,7 @@ func (ub *UberBlock) MOS(rs io.ReadSeeker) (*DnodePhys, error) {
 }
 
 func (ub *UberBlock) String() string {
-	return fmt.Sprintf("\nMagic: %08x (valid: %v), ", ub.Magic, ub.Magic == 0xbab10c) +
+	return fmt.Sprintf("\nMagic: %08x (valid: %v), ", ub.Magic, ub.Magic == UberBlockMagic) +
 		fmt.Sprintf("Version: %d, ", ub.Version) +
 		fmt.Sprintf("TrasnactionGroup: %d, ", ub.TransactionGroup) +
 		fmt.Sprintf("Timestamp: %s (%d)\n", time.Unix(int64(ub.Timestamp), 0), ub.Timestamp) +
@@ -0,0 +1,128 @@
+// Copyright 2018 Ayan George.
+// All rights reserved.  Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package zfs_test
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+
+	"github.com/ayang64/ztool/zfs"
+	"github.com/ayang64/ztool/zfs/nvlist"
+)
+
+func TestCompareUberBlocks(t *testing.T) {
+	mmp := func(seq uint64) (uint64, uint64) { return 0xa11cea11, seq<<32 | 0x02 }
+
+	m0, c0 := mmp(0)
+	m1, c1 := mmp(1)
+	m2, c2 := mmp(2)
+
+	tests := map[string]struct {
+		A, B     zfs.UberBlock
+		Expected int
+	}{
+		"txg":             {A: zfs.UberBlock{TransactionGroup: 9, Timestamp: 200}, B: zfs.UberBlock{TransactionGroup: 10, Timestamp: 100}, Expected: -1},
+		"timestamp":       {A: zfs.UberBlock{TransactionGroup: 10, Timestamp: 200}, B: zfs.UberBlock{TransactionGroup: 10, Timestamp: 100}, Expected: 1},
+		"equal":           {A: zfs.UberBlock{TransactionGroup: 10, Timestamp: 100}, B: zfs.UberBlock{TransactionGroup: 10, Timestamp: 100}, Expected: 0},
+		"mmp seq":         {A: zfs.UberBlock{TransactionGroup: 10, MMPMagic: m2, MMPConfig: c2}, B: zfs.UberBlock{TransactionGroup: 10, MMPMagic: m1, MMPConfig: c1}, Expected: 1},
+		"mmp seq invalid": {A: zfs.UberBlock{TransactionGroup: 10, MMPMagic: m1, MMPConfig: 1 << 32}, B: zfs.UberBlock{TransactionGroup: 10, MMPMagic: m1, MMPConfig: c1}, Expected: -1},
+		"mmp seq 0":       {A: zfs.UberBlock{TransactionGroup: 10, MMPMagic: m0, MMPConfig: c0}, B: zfs.UberBlock{TransactionGroup: 10}, Expected: 0},
+		"no mmp":          {A: zfs.UberBlock{TransactionGroup: 10, MMPMagic: m1, MMPConfig: c1}, B: zfs.UberBlock{TransactionGroup: 10}, Expected: 1},
+	}
+
+	for name, test := range tests {
+		test := test
+		t.Run(name, func(t *testing.T) {
+			if got := zfs.CompareUberBlocks(&test.A, &test.B); got != test.Expected {
+				t.Errorf("CompareUberBlocks() = %d; expected %d", got, test.Expected)
+			}
+			if got := zfs.CompareUberBlocks(&test.B, &test.A); got != -test.Expected {
+				t.Errorf("reversed CompareUberBlocks() = %d; expected %d", got, -test.Expected)
+			}
+		})
+	}
+}
+
+func TestActiveUberBlock(t *testing.T) {
+	const (
+		size  = 8 << 20
+		shift = 12
+	)
+
+	type slot struct {
+		N  int
+		UB zfs.UberBlock
+		BO binary.ByteOrder
+	}
+
+	ub := func(txg, ts uint64) zfs.UberBlock {
+		return zfs.UberBlock{Magic: zfs.UberBlockMagic, TransactionGroup: txg, Timestamp: ts}
+	}
+
+	tests := map[string]struct {
+		Slots      []slot
+		Candidates []uint64 // timestamps of candidates, newest first.
+	}{
+		"highest txg not in last slot": {
+			Slots:      []slot{{0, ub(12, 1), binary.LittleEndian}, {1, ub(13, 2), binary.LittleEndian}, {2, ub(11, 3), binary.LittleEndian}},
+			Candidates: []uint64{2, 1, 3},
+		},
+		"timestamp breaks tie": {
+			Slots:      []slot{{0, ub(12, 5), binary.LittleEndian}, {1, ub(12, 4), binary.LittleEndian}},
+			Candidates: []uint64{5, 4},
+		},
+		"bad magic ignored": {
+			Slots:      []slot{{0, ub(12, 1), binary.LittleEndian}, {1, zfs.UberBlock{Magic: 0xdeadbeef, TransactionGroup: 99, Timestamp: 2}, binary.LittleEndian}},
+			Candidates: []uint64{1},
+		},
+		"byte swapped": {
+			Slots:      []slot{{0, ub(12, 1), binary.LittleEndian}, {5, ub(20, 2), binary.BigEndian}},
+			Candidates: []uint64{2, 1},
+		},
+	}
+
+	for name, test := range tests {
+		test := test
+		t.Run(name, func(t *testing.T) {
+			img := labelImage(size, xdrList("version", uint64(5000), "vdev_tree", nvlist.List{"ashift": uint64(shift)}))
+			for _, s := range test.Slots {
+				putUberBlock(img, shift, s.N, s.UB, s.BO)
+			}
+
+			fs, err := zfs.New(zfs.WithReadSeeker(bytes.NewReader(img)))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			ubs, err := fs.UberBlockCandidates()
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if len(ubs) != len(test.Candidates) {
+				t.Fatalf("found %d candidates; expected %d", len(ubs), len(test.Candidates))
+			}
+
+			for i := range ubs {
+				if ubs[i].Magic != zfs.UberBlockMagic {
+					t.Errorf("candidate %d has magic %#x", i, ubs[i].Magic)
+				}
+				if ubs[i].Timestamp != test.Candidates[i] {
+					t.Errorf("candidate %d has timestamp %d; expected %d", i, ubs[i].Timestamp, test.Candidates[i])
+				}
+			}
+
+			aub, err := fs.ActiveUberBlock()
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if aub.Timestamp != test.Candidates[0] {
+				t.Errorf("active uber block has timestamp %d; expected %d", aub.Timestamp, test.Candidates[0])
+			}
+		})
+	}
+}
@@ -4,9 +4,137 @@
 
 package zfs
 
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+
+	"github.com/ayang64/ztool/zfs/nvlist"
+)
+
+const (
+	// VdevLabelSize is the size of a single vdev label.
+	VdevLabelSize = 256 << 10
+
+	// VdevLabels is the number of copies of the vdev label written to each
+	// device.  L0 and L1 live at the front of the device and L2 and L3 live in
+	// the last 512k.
+	VdevLabels = 4
+
+	// offsets of the nvlist and the uber block ring within a label.
+	labelNVPOffset       = 16 << 10
+	labelUberBlockOffset = 128 << 10
+)
+
 type VdevLabel struct {
 	BlankSpace      [8 << 10]byte   // 8k blank to accommodate os data
 	BootBlockHeader [8 << 10]byte   // 8k reserved blank space
 	NVP             [112 << 10]byte // XDR encoded  name value pairs
 	UberBlockBuf    [128 << 10]byte // uber block array
 }
+
+// LabelOffset returns the byte offset of label l (0 through 3) on a device of
+// the given size.  Like ZFS, the device size is first rounded down to a
+// multiple of the label size.
+func LabelOffset(l int, size int64) int64 {
+	offset := int64(l) * VdevLabelSize
+	if l >= VdevLabels/2 {
+		offset += (size &^ (VdevLabelSize - 1)) - VdevLabels*VdevLabelSize
+	}
+	return offset
+}
+
+// LabelCopy is one of the four copies of the vdev label found on a device.
+// Err is nil if the copy could be read, its nvlist passed its checksum and was
+// decoded.
+type LabelCopy struct {
+	Index  int         // label number; 0 through 3.
+	Offset int64       // byte offset of the label on the device.
+	Label  *VdevLabel  // raw label contents.
+	NVList nvlist.List // decoded name/value pairs.
+	Err    error       // reason the copy is unusable, if any.
+}
+
+// Valid reports whether the label copy can be used.
+func (lc *LabelCopy) Valid() bool {
+	return lc.Err == nil
+}
+
+func (lc LabelCopy) String() string {
+	if lc.Err != nil {
+		return fmt.Sprintf("L%d @ %#x: invalid: %v", lc.Index, lc.Offset, lc.Err)
+	}
+	return fmt.Sprintf("L%d @ %#x: valid", lc.Index, lc.Offset)
+}
+
+// LabelError reports that a device has no valid vdev label.  Labels holds the
+// copies that were read, each with the reason it is unusable, and is nil if
+// the device is too small to hold them.
+type LabelError struct {
+	Size   int64 // size of the device.
+	Labels []LabelCopy
+}
+
+func (e *LabelError) Error() string {
+	if e.Labels == nil {
+		return fmt.Sprintf("device is %d bytes; too small to hold %d vdev labels", e.Size, VdevLabels)
+	}
+	return fmt.Sprintf("no valid vdev label found; %v", e.Labels)
+}
+
+// ReadVdevLabels reads all four copies of the vdev label from rs.  A copy
+// that cannot be read or decoded is still returned with its Err field set so
+// callers can see which labels survived.  An error is only returned if the
+// size of the device cannot be determined or, as a *LabelError, if the device
+// is too small to hold the labels.
+func ReadVdevLabels(rs io.ReadSeeker) ([]LabelCopy, error) {
+	size, err := rs.Seek(0, io.SeekEnd)
+	if err != nil {
+		return nil, err
+	}
+
+	if size < VdevLabels*VdevLabelSize {
+		return nil, &LabelError{Size: size}
+	}
+
+	rc := make([]LabelCopy, 0, VdevLabels)
+	for l := 0; l < VdevLabels; l++ {
+		lc := LabelCopy{
+			Index:  l,
+			Offset: LabelOffset(l, size),
+		}
+		lc.Label, lc.NVList, lc.Err = readVdevLabel(rs, lc.Offset)
+		rc = append(rc, lc)
+	}
+
+	return rc, nil
+}
+
+func readVdevLabel(rs io.ReadSeeker, offset int64) (*VdevLabel, nvlist.List, error) {
+	if _, err := rs.Seek(offset, io.SeekStart); err != nil {
+		return nil, nil, err
+	}
+
+	vdl := VdevLabel{}
+	if err := binary.Read(rs, binary.LittleEndian, &vdl); err != nil {
+		return nil, nil, err
+	}
+
+	if err := VerifyLabelChecksum(vdl.NVP[:], uint64(offset+labelNVPOffset)); err != nil {
+		return &vdl, nil, fmt.Errorf("label nvlist: %w", err)
+	}
+
+	l, err := nvlist.Read(bytes.NewReader(vdl.NVP[:]))
+	if err != nil {
+		return &vdl, nil, err
+	}
+
+	// a blank label decodes to an empty list.  every label ZFS writes carries
+	// at least the pool version.
+	if _, found := l["version"]; !found {
+		return &vdl, l, fmt.Errorf("label nvlist has no version")
+	}
+
+	return &vdl, l, nil
+}
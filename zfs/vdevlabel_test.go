@@ -0,0 +1,204 @@
+// Copyright 2018 Ayan George.
+// All rights reserved.  Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package zfs_test
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"testing"
+
+	"github.com/ayang64/ztool/zfs"
+	"github.com/ayang64/ztool/zfs/nvlist"
+)
+
+// xdrList builds an XDR encoded nvlist from alternating names and values.
+func xdrList(pairs ...interface{}) []byte {
+	l := nvlist.List{}
+	for i := 0; i < len(pairs); i += 2 {
+		l[pairs[i].(string)] = pairs[i+1]
+	}
+
+	b := &bytes.Buffer{}
+	if err := nvlist.NewEncoder(b, binary.LittleEndian).Encode(l); err != nil {
+		panic(err)
+	}
+	return b.Bytes()
+}
+
+// labelImage returns a device image of the given size with a label holding
+// nvl written to every label slot.
+func labelImage(size int64, nvl []byte) []byte {
+	img := make([]byte, size)
+	for l := 0; l < zfs.VdevLabels; l++ {
+		offset := zfs.LabelOffset(l, size)
+		nvp := img[offset+16<<10 : offset+128<<10]
+		copy(nvp, nvl)
+		zfs.WriteLabelChecksum(nvp, uint64(offset+16<<10), binary.LittleEndian)
+	}
+	return img
+}
+
+// putUberBlock writes ub to slot n of the uber block ring of every label in
+// img.  shift is log2 of the slot size.
+func putUberBlock(img []byte, shift uint, n int, ub zfs.UberBlock, bo binary.ByteOrder) {
+	for l := 0; l < zfs.VdevLabels; l++ {
+		offset := zfs.LabelOffset(l, int64(len(img))) + 128<<10 + int64(n)<<shift
+		slot := img[offset : offset+1<<shift]
+
+		b := &bytes.Buffer{}
+		binary.Write(b, bo, ub)
+		copy(slot, b.Bytes())
+		zfs.WriteLabelChecksum(slot, uint64(offset), bo)
+	}
+}
+
+func TestLabelOffset(t *testing.T) {
+	const size = 64<<20 + 12345 // not a multiple of the label size.
+
+	want := []int64{0, 256 << 10, 64<<20 - 512<<10, 64<<20 - 256<<10}
+	for l := range want {
+		if got := zfs.LabelOffset(l, size); got != want[l] {
+			t.Errorf("LabelOffset(%d) = %#x; expected %#x", l, got, want[l])
+		}
+	}
+}
+
+func TestReadVdevLabels(t *testing.T) {
+	const size = 8 << 20
+
+	nvl := xdrList("version", uint64(5000), "name", "tank", "vdev_tree", nvlist.List{"ashift": uint64(12)})
+
+	tests := map[string]struct {
+		Clobber []int // labels to zero out.
+		Valid   []bool
+		Used    int
+		Fail    bool
+	}{
+		"all valid":         {Valid: []bool{true, true, true, true}, Used: 0},
+		"front overwritten": {Clobber: []int{0, 1}, Valid: []bool{false, false, true, true}, Used: 2},
+		"only L3":           {Clobber: []int{0, 1, 2}, Valid: []bool{false, false, false, true}, Used: 3},
+		"none":              {Clobber: []int{0, 1, 2, 3}, Valid: []bool{false, false, false, false}, Fail: true},
+	}
+
+	for name, test := range tests {
+		test := test
+		t.Run(name, func(t *testing.T) {
+			img := labelImage(size, nvl)
+			for _, l := range test.Clobber {
+				offset := zfs.LabelOffset(l, size)
+				copy(img[offset:offset+zfs.VdevLabelSize], make([]byte, zfs.VdevLabelSize))
+			}
+
+			labels, err := zfs.ReadVdevLabels(bytes.NewReader(img))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			for l := range labels {
+				if labels[l].Valid() != test.Valid[l] {
+					t.Errorf("%v; expected valid = %v", labels[l], test.Valid[l])
+				}
+			}
+
+			fs, err := zfs.New(zfs.WithReadSeeker(bytes.NewReader(img)))
+			if test.Fail {
+				if err == nil {
+					t.Fatalf("opened a device with no valid labels")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if got := fs.Label().Index; got != test.Used {
+				t.Errorf("using label L%d; expected L%d", got, test.Used)
+			}
+
+			ashift, err := fs.AShift()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if ashift != 12 {
+				t.Errorf("ashift = %d; expected 12", ashift)
+			}
+		})
+	}
+}
+
+func TestLabelChecksum(t *testing.T) {
+	const size = 8 << 20
+
+	img := labelImage(size, xdrList("version", uint64(5000), "vdev_tree", nvlist.List{"ashift": uint64(9)}))
+
+	// flip a bit in the nvlist of L0 and L2.
+	for _, l := range []int{0, 2} {
+		img[zfs.LabelOffset(l, size)+16<<10+40] ^= 0x01
+	}
+
+	labels, err := zfs.ReadVdevLabels(bytes.NewReader(img))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for l, valid := range []bool{false, true, false, true} {
+		if labels[l].Valid() != valid {
+			t.Errorf("%v; expected valid = %v", labels[l], valid)
+		}
+	}
+
+	var cerr *zfs.ChecksumError
+	if !errors.As(labels[0].Err, &cerr) {
+		t.Fatalf("L0 error is %v; expected a checksum error", labels[0].Err)
+	}
+}
+
+func TestUberBlockChecksum(t *testing.T) {
+	const size = 8 << 20
+
+	img := labelImage(size, xdrList("version", uint64(5000), "vdev_tree", nvlist.List{"ashift": uint64(9)}))
+
+	// ashift 9 still uses 1k uber block slots.
+	const shift = 10
+
+	for n, txg := range []uint64{10, 11, 12} {
+		putUberBlock(img, shift, n, zfs.UberBlock{Magic: 0xbab10c, TransactionGroup: txg}, binary.LittleEndian)
+	}
+
+	// corrupt slot 2 -- the one with the highest txg -- in every label.
+	for l := 0; l < zfs.VdevLabels; l++ {
+		img[zfs.LabelOffset(l, size)+128<<10+2<<shift+16] ^= 0xff
+	}
+
+	fs, err := zfs.New(zfs.WithReadSeeker(bytes.NewReader(img)))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ubs, err := fs.UberBlocks()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(ubs) != 128 {
+		t.Fatalf("found %d uber block slots; expected 128", len(ubs))
+	}
+
+	for i := range ubs {
+		if valid := i < 2; ubs[i].Valid() != valid {
+			t.Errorf("slot %d valid = %v (%v); expected %v", i, ubs[i].Valid(), ubs[i].Err, valid)
+		}
+	}
+
+	ub, err := fs.ActiveUberBlock()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if ub.TransactionGroup != 11 {
+		t.Errorf("active uber block has txg %d; expected 11", ub.TransactionGroup)
+	}
+}
@@ -0,0 +1,256 @@
+// Copyright 2018 Ayan George.
+// All rights reserved.  Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package zfs
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// vdevLabelStartSize is the space at the front of each leaf taken by the two
+// front labels and the boot block.  Offsets in a DVA start after it.
+const vdevLabelStartSize = 4 << 20
+
+// BadCopy describes a copy of a block that could not be read or did not match
+// its checksum.
+type BadCopy struct {
+	DVA    int      // index of the DVA in the block pointer.
+	Vdev   VdevTree // vdev that returned the bad copy; usually a leaf.
+	Offset uint64   // offset of the copy within its top level vdev.
+	Err    error
+}
+
+func (bc BadCopy) String() string {
+	name := "unknown vdev"
+	if bc.Vdev != nil {
+		name = vdevName(bc.Vdev)
+	}
+	return fmt.Sprintf("DVA %d on %s at %#x: %v", bc.DVA, name, bc.Offset, bc.Err)
+}
+
+// ReadError is returned when no copy of a block could be read.
+type ReadError struct {
+	Copies []BadCopy // every copy that was tried.
+}
+
+func (e *ReadError) Error() string {
+	if len(e.Copies) == 0 {
+		return "block has no copies to read"
+	}
+
+	copies := []string{}
+	for _, bc := range e.Copies {
+		copies = append(copies, bc.String())
+	}
+	return fmt.Sprintf("no good copy of block: %s", strings.Join(copies, "; "))
+}
+
+// WithBadCopyHandler has the pool call f for every bad copy it comes across,
+// including those it recovers from by reading another copy.
+func WithBadCopyHandler(f func(BadCopy)) func(*Pool) error {
+	return func(p *Pool) error {
+		p.badCopy = f
+		return nil
+	}
+}
+
+// ReadAt reads len(b) bytes from the device image starting at offset off.
+func (d *Device) ReadAt(b []byte, off int64) (int, error) {
+	if _, err := d.FS.rs.Seek(off, io.SeekStart); err != nil {
+		return 0, err
+	}
+	return io.ReadFull(d.FS.rs, b)
+}
+
+// ReadPhysical returns the physical, still compressed, contents of the block
+// bp points to.  Each DVA is tried in turn and, within mirrors, each child
+// until a copy matches the checksum in bp.  Gang blocks are reassembled from
+// their members and embedded block pointers return their payload.  Blocks with
+// a checksum type that is not supported are returned unverified.
+func (p *Pool) ReadPhysical(bp *BlockPointer) ([]byte, error) {
+	if bp.Props.Embedded() {
+		return bp.EmbeddedPayload()
+	}
+
+	verify := func(data []byte) error { return verifyBlock(bp, data) }
+
+	e := ReadError{}
+	for i := range bp.Vdevs {
+		dva := &bp.Vdevs[i]
+		if dva.IsEmpty() {
+			continue
+		}
+
+		offset := dva.VdevOffset()
+		bad := func(v VdevTree, err error) {
+			bc := BadCopy{DVA: i, Vdev: v, Offset: offset, Err: err}
+			e.Copies = append(e.Copies, bc)
+			if p.badCopy != nil {
+				p.badCopy(bc)
+			}
+		}
+
+		top := p.root.Children()
+		if int(dva.Vdev()) >= len(top) {
+			bad(nil, fmt.Errorf("no top level vdev %d", dva.Vdev()))
+			continue
+		}
+
+		var (
+			data []byte
+			err  error
+		)
+		if dva.Gang() {
+			data, err = p.readGang(bp, top[dva.Vdev()], offset, bad)
+		} else {
+			data, err = p.readVdev(top[dva.Vdev()], offset, bp.Props.Psize(), verify, bad)
+		}
+		if err == nil {
+			return data, nil
+		}
+	}
+
+	return nil, &e
+}
+
+// readVdev reads size bytes at offset from v.  verify decides whether a copy
+// is good; a nil verify accepts any copy that can be read.  Leaves report
+// copies they cannot supply to bad; vdevs with redundancy try other copies
+// before giving up.
+func (p *Pool) readVdev(v VdevTree, offset uint64, size int, verify func([]byte) error, bad func(VdevTree, error)) ([]byte, error) {
+	switch v := v.(type) {
+	case *LogVdev:
+		return p.readVdev(v.VdevTree, offset, size, verify, bad)
+
+	case *DiskVdev, *FileVdev:
+		d, found := p.leaves[v.Common().GUID]
+		if !found {
+			err := fmt.Errorf("device is missing")
+			bad(v, err)
+			return nil, err
+		}
+
+		data := make([]byte, size)
+		if _, err := d.ReadAt(data, int64(offset+vdevLabelStartSize)); err != nil {
+			bad(v, err)
+			return nil, err
+		}
+
+		if verify == nil {
+			return data, nil
+		}
+
+		if err := verify(data); err != nil {
+			if cerr, ok := err.(*ChecksumError); ok {
+				cerr.Offset = offset + vdevLabelStartSize
+			}
+			bad(v, err)
+			return nil, err
+		}
+
+		return data, nil
+
+	case *MirrorVdev, *ReplacingVdev, *SpareVdev:
+		// every child holds a full copy of the block.
+		for _, c := range v.Children() {
+			if data, err := p.readVdev(c, offset, size, verify, bad); err == nil {
+				return data, nil
+			}
+		}
+		return nil, fmt.Errorf("no child of %s holds a good copy", vdevName(v))
+
+	case *RaidzVdev:
+		return p.readRaidz(v, offset, size, verify, bad)
+
+	case *DraidVdev:
+		return p.readDraid(v, offset, size, verify, bad)
+
+	case *DSpareVdev:
+		return p.readDistributedSpare(v, offset, size, verify, bad)
+
+	default:
+		err := fmt.Errorf("reading from %s vdevs is not supported", v.Common().Type)
+		bad(v, err)
+		return nil, err
+	}
+}
+
+// ReadBlock returns the logical contents of the block bp points to.  Holes
+// read as zeros, embedded block pointers return their payload and other blocks
+// are read from the pool, checked against their checksum and decompressed.
+func (p *Pool) ReadBlock(bp *BlockPointer) ([]byte, error) {
+	return readBlock(bp, p.ReadPhysical)
+}
+
+// ReadBlockSize is ReadBlock for a block whose logical size is known from
+// where bp was found; data blocks of a dnode hold dn_datablkszsec sectors for
+// instance.  Holes read as size zeros since a hole written before hole_birth
+// is entirely zero and does not record its size.  Other blocks must hold
+// exactly size bytes.
+func (p *Pool) ReadBlockSize(bp *BlockPointer, size int) ([]byte, error) {
+	if bp.IsHole() {
+		return make([]byte, size), nil
+	}
+
+	lbuf, err := p.ReadBlock(bp)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(lbuf) != size {
+		return nil, fmt.Errorf("block holds %d bytes; expected %d", len(lbuf), size)
+	}
+
+	return lbuf, nil
+}
+
+// readBlock returns the logical contents of the block bp points to using
+// physical to read blocks that are neither holes nor embedded.
+func readBlock(bp *BlockPointer, physical func(*BlockPointer) ([]byte, error)) ([]byte, error) {
+	switch {
+	case bp.IsHole():
+		// holes keep their logical size on pools with hole_birth; older
+		// holes are entirely zero and read as a single sector.  Callers
+		// that know the size use ReadBlockSize.
+		return make([]byte, bp.Props.Lsize()), nil
+	case bp.Props.Embedded():
+		return bp.ReadEmbedded()
+	}
+
+	pbuf, err := physical(bp)
+	if err != nil {
+		return nil, err
+	}
+
+	if bp.Props.Compression() == CompressionOff {
+		if len(pbuf) != bp.Props.Lsize() {
+			return nil, fmt.Errorf("uncompressed block holds %d bytes; expected %d", len(pbuf), bp.Props.Lsize())
+		}
+		return pbuf, nil
+	}
+
+	lbuf := make([]byte, bp.Props.Lsize())
+	n, err := bp.Props.Compression().Decompress(lbuf, pbuf)
+	if err != nil {
+		return nil, fmt.Errorf("cannot decompress %s block: %w", bp.Props.Compression(), err)
+	}
+	if n != len(lbuf) {
+		return nil, fmt.Errorf("%s block decompressed to %d of %d bytes", bp.Props.Compression(), n, len(lbuf))
+	}
+
+	return lbuf, nil
+}
+
+// GetDnode reads and decodes the dnode bp points to.
+func (p *Pool) GetDnode(bp *BlockPointer) (*DnodePhys, error) {
+	lbuf, err := p.ReadBlock(bp)
+	if err != nil {
+		return nil, err
+	}
+
+	return bp.Vdevs[0].ReadDnode(bytes.NewReader(lbuf), bp.Props.ByteOrder())
+}
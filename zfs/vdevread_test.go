@@ -0,0 +1,343 @@
+// Copyright 2018 Ayan George.
+// All rights reserved.  Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package zfs_test
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/ayang64/ztool/zfs"
+)
+
+func TestBlockChecksum(t *testing.T) {
+	tests := map[string]struct {
+		Type     zfs.ChecksumType
+		Data     []byte
+		Order    binary.ByteOrder
+		Expected [4]uint64
+	}{
+		"fletcher2": {
+			Type:     zfs.ChecksumFletcher2,
+			Data:     []byte{1, 0, 0, 0, 0, 0, 0, 0, 2, 0, 0, 0, 0, 0, 0, 0},
+			Order:    binary.LittleEndian,
+			Expected: [4]uint64{1, 2, 1, 2},
+		},
+		"fletcher4 little endian": {
+			Type:     zfs.ChecksumFletcher4,
+			Data:     []byte{1, 0, 0, 0, 2, 0, 0, 0},
+			Order:    binary.LittleEndian,
+			Expected: [4]uint64{3, 4, 5, 6},
+		},
+		"fletcher4 big endian": {
+			Type:     zfs.ChecksumFletcher4,
+			Data:     []byte{0, 0, 0, 1, 0, 0, 0, 2},
+			Order:    binary.BigEndian,
+			Expected: [4]uint64{3, 4, 5, 6},
+		},
+		"sha256": {
+			Type:  zfs.ChecksumSHA256,
+			Data:  []byte{},
+			Order: binary.LittleEndian,
+			Expected: [4]uint64{
+				0xe3b0c44298fc1c14, 0x9afbf4c8996fb924,
+				0x27ae41e4649b934c, 0xa495991b7852b855,
+			},
+		},
+		"sha512": {
+			Type:  zfs.ChecksumSHA512,
+			Data:  []byte{},
+			Order: binary.LittleEndian,
+			Expected: [4]uint64{
+				0xc672b8d1ef56ed28, 0xab87c3622c511406,
+				0x9bdd3ad7b8f97374, 0x98d0c01ecef0967a,
+			},
+		},
+	}
+
+	for name, test := range tests {
+		test := test
+		t.Run(name, func(t *testing.T) {
+			sum, err := zfs.BlockChecksum(test.Type, test.Data, test.Order)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if sum != test.Expected {
+				t.Fatalf("checksum %x; expected %x", sum, test.Expected)
+			}
+		})
+	}
+
+	for _, ct := range []zfs.ChecksumType{zfs.ChecksumSkein, zfs.ChecksumEdonR, zfs.ChecksumBlake3} {
+		if _, err := zfs.BlockChecksum(ct, []byte{}, binary.LittleEndian); !errors.Is(err, zfs.ErrChecksumUnsupported) {
+			t.Errorf("BlockChecksum(%d) = %v; expected ErrChecksumUnsupported", ct, err)
+		}
+	}
+}
+
+func TestPoolReadPhysical(t *testing.T) {
+	const (
+		off   = 4<<20 + 512 // DVA offset 1 past the front labels.
+		props = zfs.BlockPointerProps(1<<63 | uint64(zfs.CompressionOff)<<32 | uint64(zfs.ChecksumFletcher4)<<40)
+	)
+
+	data := make([]byte, 512)
+	for i := range data {
+		data[i] = byte(i)
+	}
+
+	// one copy on the mirror and a second on the single disk.
+	bp := zfs.BlockPointer{Props: props}
+	bp.Vdevs[0] = zfs.NewDVA(0, 512, false)
+	bp.Vdevs[1] = zfs.NewDVA(1, 512, false)
+	bp.ChecksumList, _ = zfs.BlockChecksum(zfs.ChecksumFletcher4, data, binary.LittleEndian)
+
+	tests := map[string]struct {
+		Corrupt []uint64 // leaves that hold a damaged copy.
+		Bad     []uint64 // leaves expected to be reported, in order.
+		Fail    bool
+	}{
+		"all good":             {},
+		"first mirror child":   {Corrupt: []uint64{11}, Bad: []uint64{11}},
+		"whole mirror":         {Corrupt: []uint64{11, 12}, Bad: []uint64{11, 12}},
+		"second mirror child":  {Corrupt: []uint64{12}},
+		"every copy":           {Corrupt: []uint64{11, 12, 20}, Bad: []uint64{11, 12, 20}, Fail: true},
+		"mirror and lone disk": {Corrupt: []uint64{11, 20}, Bad: []uint64{11}},
+	}
+
+	for name, test := range tests {
+		test := test
+		t.Run(name, func(t *testing.T) {
+			opts := []func(*zfs.Pool) error{}
+
+			bad := []zfs.BadCopy{}
+			opts = append(opts, zfs.WithBadCopyHandler(func(bc zfs.BadCopy) { bad = append(bad, bc) }))
+
+			for _, g := range []uint64{11, 12, 20} {
+				top := uint64(10)
+				if g == 20 {
+					top = 20
+				}
+				img := poolDevice(testPoolGUID, g, top, 40)
+				copy(img[off:], data)
+				for _, c := range test.Corrupt {
+					if c == g {
+						img[off] ^= 0xff
+					}
+				}
+				opts = append(opts, zfs.WithDevice(fmt.Sprintf("dev%d", g), bytes.NewReader(img)))
+			}
+
+			p, err := zfs.NewPool(opts...)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			got, err := p.ReadPhysical(&bp)
+			if test.Fail {
+				var re *zfs.ReadError
+				if !errors.As(err, &re) {
+					t.Fatalf("ReadPhysical() = %v; expected *ReadError", err)
+				}
+				if len(re.Copies) != len(test.Bad) {
+					t.Errorf("read error lists %d copies; expected %d", len(re.Copies), len(test.Bad))
+				}
+			} else {
+				if err != nil {
+					t.Fatal(err)
+				}
+				if !bytes.Equal(got, data) {
+					t.Fatalf("read the wrong data")
+				}
+			}
+
+			if len(bad) != len(test.Bad) {
+				t.Fatalf("reported %v; expected bad copies on %v", bad, test.Bad)
+			}
+			for i, bc := range bad {
+				if bc.Vdev.Common().GUID != test.Bad[i] {
+					t.Errorf("bad copy %d on vdev %d; expected %d", i, bc.Vdev.Common().GUID, test.Bad[i])
+				}
+				var cerr *zfs.ChecksumError
+				if !errors.As(bc.Err, &cerr) || cerr.Offset != off {
+					t.Errorf("bad copy %d: %v", i, bc.Err)
+				}
+			}
+		})
+	}
+}
+
+func TestFilesystemReadBlock(t *testing.T) {
+	data := make([]byte, 1024)
+	for i := range data {
+		data[i] = byte(i * 7)
+	}
+
+	img := poolDevice(testPoolGUID, 20, 20, 40)
+	copy(img[4<<20+40*512:], data)
+
+	fs, err := zfs.New(zfs.WithReadSeeker(bytes.NewReader(img)))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the first copy is on the mirror, whose devices are not at hand.
+	copies := dataBlock(1, 40, data)
+	copies.Vdevs[1] = copies.Vdevs[0]
+	copies.Vdevs[0] = zfs.NewDVA(0, 40<<9, false)
+
+	damaged := dataBlock(1, 40, data)
+	damaged.ChecksumList[0]++
+
+	// the checksum matches but the data cannot be decompressed.
+	unknown := dataBlock(1, 40, data)
+	unknown.Props = unknown.Props&^(0x7f<<32) | zfs.BlockPointerProps(zfs.CompressionEmpty)<<32
+
+	embedded := zfs.BlockPointer{Props: zfs.BlockPointerProps(1<<63 | uint64(zfs.CompressionOff)<<32 | 2)}
+	embedded.SetEmbeddedPayload([]byte("abc"))
+
+	withChecksum := func(ct zfs.ChecksumType) zfs.BlockPointer {
+		bp := dataBlock(1, 40, data)
+		bp.Props = bp.Props&^(0xff<<40) | zfs.BlockPointerProps(ct)<<40
+		bp.ChecksumList, _ = zfs.BlockChecksum(ct, data, binary.LittleEndian)
+		return bp
+	}
+
+	// the logical size of an uncompressed block must match its physical
+	// size.
+	mismatched := dataBlock(1, 40, data)
+	mismatched.Props++
+
+	sha512 := withChecksum(zfs.ChecksumSHA512)
+	damagedSHA512 := withChecksum(zfs.ChecksumSHA512)
+	damagedSHA512.ChecksumList[3]++
+
+	// the last two words of an encrypted block's checksum are its MAC and
+	// fletcher checksums fold them into the first two.
+	withMAC := func(ct zfs.ChecksumType, ot zfs.DmuObjectType) zfs.BlockPointer {
+		bp := withChecksum(ct)
+		bp.Props = bp.Props&^(0xff<<48) | 1<<61 | zfs.BlockPointerProps(ot)<<48
+		if ct == zfs.ChecksumFletcher4 {
+			bp.ChecksumList[0] ^= bp.ChecksumList[2]
+			bp.ChecksumList[1] ^= bp.ChecksumList[3]
+		}
+		bp.ChecksumList[2], bp.ChecksumList[3] = 0x1234, 0x5678
+		return bp
+	}
+	damagedCrypt := withMAC(zfs.ChecksumFletcher4, zfs.DMU_OT_PLAIN_FILE_CONTENTS)
+	damagedCrypt.ChecksumList[1]++
+
+	tests := map[string]struct {
+		BP       zfs.BlockPointer
+		Expected []byte
+		Fail     bool
+	}{
+		"single copy":             {BP: dataBlock(1, 40, data), Expected: data},
+		"second copy":             {BP: copies, Expected: data},
+		"damaged":                 {BP: damaged, Fail: true},
+		"no such vdev":            {BP: dataBlock(5, 40, data), Fail: true},
+		"hole":                    {BP: zfs.BlockPointer{Props: blockProps(1024), Birth: 3}, Expected: make([]byte, 1024)},
+		"embedded":                {BP: embedded, Expected: []byte("abc")},
+		"unsupported compression": {BP: unknown, Fail: true},
+		"sha512":                  {BP: sha512, Expected: data},
+		"damaged sha512":          {BP: damagedSHA512, Fail: true},
+		"crypt fletcher4":         {BP: withMAC(zfs.ChecksumFletcher4, zfs.DMU_OT_PLAIN_FILE_CONTENTS), Expected: data},
+		"crypt sha512":            {BP: withMAC(zfs.ChecksumSHA512, zfs.DMU_OT_PLAIN_FILE_CONTENTS), Expected: data},
+		"damaged crypt":           {BP: damagedCrypt, Fail: true},
+		"crypt objset":            {BP: withMAC(zfs.ChecksumSHA512, zfs.DMU_OT_OBJSET), Fail: true},
+		"unsupported checksum":    {BP: withChecksum(zfs.ChecksumSkein), Expected: data},
+		"size mismatch":           {BP: mismatched, Fail: true},
+	}
+
+	for name, test := range tests {
+		test := test
+		t.Run(name, func(t *testing.T) {
+			got, err := fs.ReadBlock(&test.BP)
+			if test.Fail {
+				if err == nil {
+					t.Fatalf("read %v without error", got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(got, test.Expected) {
+				t.Fatalf("read %v; expected %v", got, test.Expected)
+			}
+		})
+	}
+}
+
+func TestPoolReadCompressed(t *testing.T) {
+	img := poolDevice(testPoolGUID, 20, 20, 40)
+	p, err := zfs.NewPool(zfs.WithDevice("dev20", bytes.NewReader(img)))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := map[string]struct {
+		Compression zfs.ZfsCompressionType
+		Data        []byte
+		Pbuf        []byte // compressed form; Compress is used if nil.
+	}{
+		"lzjb":   {Compression: zfs.CompressionLZJB, Data: compressibleData(16 << 10)},
+		"gzip-1": {Compression: zfs.CompressionGzip1, Data: compressibleData(64 << 10)},
+		"gzip-2": {Compression: zfs.CompressionGzip2, Data: compressibleData(64 << 10)},
+		"gzip-3": {Compression: zfs.CompressionGzip3, Data: compressibleData(64 << 10)},
+		"gzip-4": {Compression: zfs.CompressionGzip4, Data: compressibleData(64 << 10)},
+		"gzip-5": {Compression: zfs.CompressionGzip5, Data: compressibleData(64 << 10)},
+		"gzip-6": {Compression: zfs.CompressionGzip6, Data: compressibleData(64 << 10)},
+		"gzip-7": {Compression: zfs.CompressionGzip7, Data: compressibleData(64 << 10)},
+		"gzip-8": {Compression: zfs.ComperssionGzip8, Data: compressibleData(64 << 10)},
+		"gzip-9": {Compression: zfs.CompressionGzip9, Data: compressibleData(64 << 10)},
+		"lz4":    {Compression: zfs.CompressionLZ4, Data: compressibleData(128 << 10)},
+		"zle":    {Compression: zfs.CompressionLZE, Data: zleBlock(), Pbuf: zleEncoded},
+		"zstd":   {Compression: zfs.CompressionZSTD, Data: compressibleData(128 << 10)},
+
+		"lz4 from zfs":            {Compression: zfs.CompressionLZ4, Data: lz4Data(), Pbuf: lz4Block},
+		"zstd from the zstd tool": {Compression: zfs.CompressionZSTD, Data: zstdData(), Pbuf: zstdBlock(0x0300290a)},
+	}
+
+	for name, test := range tests {
+		test := test
+		t.Run(name, func(t *testing.T) {
+			pbuf := test.Pbuf
+			if pbuf == nil {
+				pbuf = make([]byte, len(test.Data))
+				n, err := test.Compression.Compress(pbuf, test.Data)
+				if err != nil {
+					t.Fatal(err)
+				}
+				pbuf = pbuf[:n]
+			}
+
+			// physical sizes are whole sectors.
+			pbuf = append(append([]byte{}, pbuf...), make([]byte, -len(pbuf)&511)...)
+			copy(img[4<<20+40*512:], pbuf)
+
+			bp := dataBlock(1, 40, pbuf)
+			bp.Props = zfs.BlockPointerProps(1<<63 | uint64(zfs.ChecksumFletcher4)<<40 | uint64(test.Compression)<<32 |
+				uint64(len(pbuf)/512-1)<<16 | uint64(len(test.Data)/512-1))
+
+			got, err := p.ReadBlock(&bp)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(got, test.Data) {
+				t.Fatal("read the wrong data")
+			}
+
+			// a block that decompresses to less than its logical size
+			// is damaged.
+			bp.Props++
+			if got, err := p.ReadBlock(&bp); err == nil {
+				t.Fatalf("read %d bytes from a block of %d", len(got), len(test.Data))
+			}
+		})
+	}
+}
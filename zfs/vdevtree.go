@@ -0,0 +1,260 @@
+// Copyright 2018 Ayan George.
+// All rights reserved.  Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package zfs
+
+import "fmt"
+
+// VdevTree is a node of a pool's vdev tree.  The concrete type of a node
+// determines how I/O is routed through it.
+//
+// *RootVdev, *MirrorVdev, *ReplacingVdev, *SpareVdev, *RaidzVdev and
+// *DraidVdev combine their children.  *DiskVdev and *FileVdev are backed by
+// devices and *DSpareVdev by space on the children of a *DraidVdev.
+// *HoleVdev, *MissingVdev and *IndirectVdev stand in for top level vdevs that
+// have no usable devices.  Log devices are wrapped in *LogVdev and cache
+// devices in *L2CacheVdev.
+type VdevTree interface {
+	// Common returns the properties shared by every type of vdev.
+	Common() *Vdev
+	// Children returns the vdevs below this one.
+	Children() []VdevTree
+}
+
+// Vdev holds the properties common to every type of vdev.  Top level vdevs
+// record the allocation properties; AShift, ASize, MetaslabArray and
+// MetaslabShift are zero elsewhere.
+type Vdev struct {
+	Type          string
+	ID            uint64
+	GUID          uint64
+	AShift        uint64
+	ASize         uint64
+	MetaslabArray uint64
+	MetaslabShift uint64
+	CreateTXG     uint64
+
+	children []VdevTree
+}
+
+// Common returns v.
+func (v *Vdev) Common() *Vdev {
+	return v
+}
+
+// Children returns the vdevs below v.
+func (v *Vdev) Children() []VdevTree {
+	return v.children
+}
+
+// RootVdev is the root of a pool's vdev tree.  Its children are the top level
+// vdevs.  Cache devices and hot spares are not part of the tree proper and are
+// kept separately.
+type RootVdev struct {
+	Vdev
+	L2Cache []VdevTree
+	Spares  []VdevTree
+}
+
+// MirrorVdev stores a full copy of each block on every child.
+type MirrorVdev struct{ Vdev }
+
+// ReplacingVdev is a temporary mirror of a device and its replacement.
+type ReplacingVdev struct{ Vdev }
+
+// SpareVdev is a temporary mirror of a device and the hot spare standing in
+// for it.
+type SpareVdev struct{ Vdev }
+
+// RaidzVdev stripes blocks with NParity parity columns across its children.
+type RaidzVdev struct {
+	Vdev
+	NParity uint64
+}
+
+// DraidVdev is a distributed RAID vdev.  Redundancy groups of NData data and
+// NParity parity columns and NSpares distributed spares are permuted across
+// its children.
+type DraidVdev struct {
+	Vdev
+	NParity uint64
+	NData   uint64
+	NSpares uint64
+	NGroups uint64
+
+	perms []byte // permutation map for the number of children.
+}
+
+// DiskVdev is a leaf backed by a disk.
+type DiskVdev struct {
+	Vdev
+	Path      string
+	PhysPath  string
+	DevID     string
+	WholeDisk bool
+}
+
+// FileVdev is a leaf backed by a file.
+type FileVdev struct {
+	Vdev
+	Path string
+}
+
+// DSpareVdev is a distributed spare.  Its space is spread across the children
+// of the dRAID vdev with guid TopGUID.
+type DSpareVdev struct {
+	Vdev
+	TopGUID uint64
+	SpareID uint64
+}
+
+// HoleVdev takes the place of a removed log device so the IDs of the other top
+// level vdevs do not change.
+type HoleVdev struct{ Vdev }
+
+// MissingVdev takes the place of a top level vdev that is absent from the
+// configuration.
+type MissingVdev struct{ Vdev }
+
+// IndirectVdev is a removed top level vdev whose data was remapped to the
+// remaining vdevs.
+type IndirectVdev struct{ Vdev }
+
+// LogVdev is a top level vdev that holds the intent log rather than pool data.
+type LogVdev struct{ VdevTree }
+
+// L2CacheVdev is a device used as a second level read cache.
+type L2CacheVdev struct{ VdevTree }
+
+// NewVdevTree builds the typed vdev tree described by cfg.
+func NewVdevTree(cfg *VdevConfig) (VdevTree, error) {
+	v := Vdev{
+		Type:          cfg.Type,
+		ID:            cfg.ID,
+		GUID:          cfg.GUID,
+		AShift:        cfg.AShift,
+		ASize:         cfg.ASize,
+		MetaslabArray: cfg.MetaslabArray,
+		MetaslabShift: cfg.MetaslabShift,
+		CreateTXG:     cfg.CreateTXG,
+	}
+
+	for i := range cfg.Children {
+		c, err := NewVdevTree(&cfg.Children[i])
+		if err != nil {
+			return nil, fmt.Errorf("%s vdev %d child %d: %w", cfg.Type, cfg.ID, i, err)
+		}
+		v.children = append(v.children, c)
+	}
+
+	var rc VdevTree
+
+	switch cfg.Type {
+	case "root":
+		rc = &RootVdev{Vdev: v}
+	case "mirror":
+		rc = &MirrorVdev{v}
+	case "replacing":
+		rc = &ReplacingVdev{v}
+	case "spare":
+		rc = &SpareVdev{v}
+	case "raidz":
+		// raidz vdevs created before RAID-Z2 existed have no nparity.  Like
+		// vdev_raidz_init(), take them to have single parity.
+		nparity := uint64(1)
+		if cfg.NParity != nil {
+			nparity = *cfg.NParity
+		}
+		if nparity < 1 || nparity > 3 {
+			return nil, fmt.Errorf("raidz vdev %d has invalid parity %d", cfg.ID, nparity)
+		}
+		rc = &RaidzVdev{Vdev: v, NParity: nparity}
+	case "draid":
+		if cfg.NParity == nil {
+			return nil, fmt.Errorf("draid vdev %d has no parity", cfg.ID)
+		}
+		nparity := *cfg.NParity
+		if nparity < 1 || nparity > 3 {
+			return nil, fmt.Errorf("draid vdev %d has invalid parity %d", cfg.ID, nparity)
+		}
+		if cfg.NChildren != 0 && cfg.NChildren != uint64(len(cfg.Children)) {
+			return nil, fmt.Errorf("draid vdev %d uses failure domains which are not supported", cfg.ID)
+		}
+		if ndisks := uint64(len(cfg.Children)) - cfg.NSpares; cfg.NData == 0 || cfg.NGroups == 0 ||
+			uint64(len(cfg.Children)) < cfg.NData+nparity+cfg.NSpares ||
+			(cfg.NData+nparity)*cfg.NGroups%ndisks != 0 {
+			return nil, fmt.Errorf("draid vdev %d has invalid geometry", cfg.ID)
+		}
+		perms, err := draidPermutations(uint64(len(cfg.Children)))
+		if err != nil {
+			return nil, fmt.Errorf("draid vdev %d: %w", cfg.ID, err)
+		}
+		rc = &DraidVdev{
+			Vdev:    v,
+			NParity: nparity,
+			NData:   cfg.NData,
+			NSpares: cfg.NSpares,
+			NGroups: cfg.NGroups,
+			perms:   perms,
+		}
+	case "dspare":
+		rc = &DSpareVdev{Vdev: v, TopGUID: cfg.TopGUID, SpareID: cfg.SpareID}
+	case "disk":
+		rc = &DiskVdev{
+			Vdev:      v,
+			Path:      cfg.Path,
+			PhysPath:  cfg.PhysPath,
+			DevID:     cfg.DevID,
+			WholeDisk: cfg.WholeDisk != 0,
+		}
+	case "file":
+		rc = &FileVdev{Vdev: v, Path: cfg.Path}
+	case "hole":
+		rc = &HoleVdev{v}
+	case "missing":
+		rc = &MissingVdev{v}
+	case "indirect":
+		rc = &IndirectVdev{v}
+	default:
+		return nil, fmt.Errorf("unknown vdev type %q", cfg.Type)
+	}
+
+	if cfg.IsLog != 0 {
+		rc = &LogVdev{rc}
+	}
+
+	return rc, nil
+}
+
+// Tree builds the pool's typed vdev tree including the cache devices and hot
+// spares listed in its root vdev.
+func (pc *PoolConfig) Tree() (*RootVdev, error) {
+	t, err := NewVdevTree(&pc.VdevTree)
+	if err != nil {
+		return nil, err
+	}
+
+	root, ok := t.(*RootVdev)
+	if !ok {
+		return nil, fmt.Errorf("vdev tree of pool %q is rooted at a %s vdev", pc.Name, pc.VdevTree.Type)
+	}
+
+	for i := range pc.VdevTree.L2Cache {
+		c, err := NewVdevTree(&pc.VdevTree.L2Cache[i])
+		if err != nil {
+			return nil, fmt.Errorf("cache device %d: %w", i, err)
+		}
+		root.L2Cache = append(root.L2Cache, &L2CacheVdev{c})
+	}
+
+	for i := range pc.VdevTree.Spares {
+		s, err := NewVdevTree(&pc.VdevTree.Spares[i])
+		if err != nil {
+			return nil, fmt.Errorf("spare %d: %w", i, err)
+		}
+		root.Spares = append(root.Spares, s)
+	}
+
+	return root, nil
+}
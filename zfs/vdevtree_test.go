@@ -0,0 +1,206 @@
+// Copyright 2018 Ayan George.
+// All rights reserved.  Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package zfs_test
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/ayang64/ztool/zfs"
+	"github.com/ayang64/ztool/zfs/nvlist"
+)
+
+func disk(guid uint64, path string) nvlist.List {
+	return nvlist.List{"type": "disk", "guid": guid, "path": path, "whole_disk": uint64(1)}
+}
+
+func TestPoolConfigTree(t *testing.T) {
+	l := nvlist.List{
+		"name":      "tank",
+		"pool_guid": uint64(1),
+		"vdev_tree": nvlist.List{
+			"type": "root",
+			"guid": uint64(1),
+			"children": []nvlist.List{
+				{
+					"type": "raidz", "id": uint64(0), "guid": uint64(10), "nparity": uint64(2),
+					"ashift": uint64(12), "asize": uint64(1 << 30), "metaslab_array": uint64(37),
+					"children": []nvlist.List{disk(11, "/dev/da0"), disk(12, "/dev/da1"), disk(13, "/dev/da2"), disk(14, "/dev/da3")},
+				},
+				{"type": "hole", "id": uint64(1), "guid": uint64(0)},
+				{
+					"type": "mirror", "id": uint64(2), "guid": uint64(20), "is_log": uint64(1),
+					"children": []nvlist.List{disk(21, "/dev/da4"), {"type": "file", "guid": uint64(22), "path": "/tmp/log"}},
+				},
+				{
+					"type": "draid", "id": uint64(3), "guid": uint64(30), "nparity": uint64(1),
+					"draid_ndata": uint64(4), "draid_nspares": uint64(1), "draid_ngroups": uint64(2),
+					"children": []nvlist.List{
+						disk(31, "/dev/da5"), disk(32, "/dev/da6"), disk(33, "/dev/da7"),
+						disk(34, "/dev/da8"), disk(35, "/dev/da9"), disk(36, "/dev/da10"),
+					},
+				},
+				{"type": "indirect", "id": uint64(4), "guid": uint64(40)},
+			},
+			"l2cache": []nvlist.List{disk(50, "/dev/nvd0")},
+			"spares":  []nvlist.List{disk(60, "/dev/da9")},
+		},
+	}
+
+	var pc zfs.PoolConfig
+	if err := nvlist.UnmarshalList(l, &pc); err != nil {
+		t.Fatal(err)
+	}
+
+	root, err := pc.Tree()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	top := root.Children()
+	if len(top) != 5 {
+		t.Fatalf("root has %d children; expected 5", len(top))
+	}
+
+	raidz, ok := top[0].(*zfs.RaidzVdev)
+	if !ok {
+		t.Fatalf("child 0 is %T; expected *zfs.RaidzVdev", top[0])
+	}
+
+	if raidz.NParity != 2 || raidz.AShift != 12 || raidz.ASize != 1<<30 || raidz.MetaslabArray != 37 || raidz.GUID != 10 {
+		t.Errorf("raidz vdev is %+v", raidz)
+	}
+
+	for i, c := range raidz.Children() {
+		d, ok := c.(*zfs.DiskVdev)
+		if !ok || d.GUID != uint64(11+i) || !d.WholeDisk {
+			t.Errorf("raidz child %d is %#v", i, c)
+		}
+	}
+
+	if _, ok := top[1].(*zfs.HoleVdev); !ok {
+		t.Errorf("child 1 is %T; expected *zfs.HoleVdev", top[1])
+	}
+
+	log, ok := top[2].(*zfs.LogVdev)
+	if !ok {
+		t.Fatalf("child 2 is %T; expected *zfs.LogVdev", top[2])
+	}
+
+	if _, ok := log.VdevTree.(*zfs.MirrorVdev); !ok || log.Common().GUID != 20 {
+		t.Errorf("log vdev wraps %T with guid %d", log.VdevTree, log.Common().GUID)
+	}
+
+	if f, ok := log.Children()[1].(*zfs.FileVdev); !ok || f.Path != "/tmp/log" {
+		t.Errorf("log child 1 is %#v", log.Children()[1])
+	}
+
+	draid, ok := top[3].(*zfs.DraidVdev)
+	if !ok || draid.NParity != 1 || draid.NData != 4 || draid.NSpares != 1 || draid.NGroups != 2 {
+		t.Errorf("child 3 is %#v", top[3])
+	}
+
+	if _, ok := top[4].(*zfs.IndirectVdev); !ok {
+		t.Errorf("child 4 is %T; expected *zfs.IndirectVdev", top[4])
+	}
+
+	if len(root.L2Cache) != 1 || root.L2Cache[0].Common().GUID != 50 {
+		t.Errorf("cache devices are %v", root.L2Cache)
+	} else if _, ok := root.L2Cache[0].(*zfs.L2CacheVdev); !ok {
+		t.Errorf("cache device is %T; expected *zfs.L2CacheVdev", root.L2Cache[0])
+	}
+
+	if len(root.Spares) != 1 || root.Spares[0].(*zfs.DiskVdev).Path != "/dev/da9" {
+		t.Errorf("spares are %v", root.Spares)
+	}
+}
+
+func TestNewVdevTreeErrors(t *testing.T) {
+	tests := map[string]zfs.VdevConfig{
+		"unknown type":   {Type: "tape"},
+		"raidz parity":   {Type: "raidz", NParity: uint64p(4)},
+		"raidz parity 0": {Type: "raidz", NParity: uint64p(0)},
+		"draid parity":   {Type: "draid"},
+		"draid width":    {Type: "draid", NParity: uint64p(1), NData: 4, NGroups: 1, Children: []zfs.VdevConfig{{Type: "disk"}, {Type: "disk"}, {Type: "disk"}, {Type: "disk"}}},
+		"unknown child":  {Type: "mirror", Children: []zfs.VdevConfig{{Type: "disk"}, {Type: "tape"}}},
+	}
+
+	for name, cfg := range tests {
+		cfg := cfg
+		t.Run(name, func(t *testing.T) {
+			if v, err := zfs.NewVdevTree(&cfg); err == nil {
+				t.Fatalf("built %#v without error", v)
+			}
+		})
+	}
+}
+
+func TestRaidzWithoutParity(t *testing.T) {
+	// raidz labels written before RAID-Z2 existed have no nparity.
+	l := nvlist.List{
+		"type": "raidz", "id": uint64(0), "guid": uint64(10), "ashift": uint64(9),
+		"children": []nvlist.List{disk(11, "/dev/da0"), disk(12, "/dev/da1"), disk(13, "/dev/da2")},
+	}
+
+	var cfg zfs.VdevConfig
+	if err := nvlist.UnmarshalList(l, &cfg); err != nil {
+		t.Fatal(err)
+	}
+
+	if cfg.NParity != nil {
+		t.Fatalf("nparity is %d; expected none", *cfg.NParity)
+	}
+
+	v, err := zfs.NewVdevTree(&cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if raidz, ok := v.(*zfs.RaidzVdev); !ok || raidz.NParity != 1 {
+		t.Errorf("vdev is %#v; expected single parity raidz", v)
+	}
+}
+
+func TestFilesystemVdevTree(t *testing.T) {
+	nvl := xdrList(
+		"version", uint64(5000),
+		"name", "tank",
+		"pool_guid", uint64(1),
+		"guid", uint64(12),
+		"top_guid", uint64(10),
+		"vdev_tree", nvlist.List{
+			"type": "mirror", "guid": uint64(10), "ashift": uint64(9), "metaslab_array": uint64(37),
+			"children": []nvlist.List{disk(11, "/dev/da0"), disk(12, "/dev/da1")},
+		},
+	)
+
+	fs, err := zfs.New(zfs.WithReadSeeker(bytes.NewReader(labelImage(8<<20, nvl))))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cfg, err := fs.Config()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if cfg.Name != "tank" || cfg.GUID != 12 || cfg.TopGUID != 10 || cfg.PoolConfig.GUID != 1 {
+		t.Errorf("label config is %+v", cfg)
+	}
+
+	v, err := fs.VdevTree()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	m, ok := v.(*zfs.MirrorVdev)
+	if !ok {
+		t.Fatalf("vdev tree is %T; expected *zfs.MirrorVdev", v)
+	}
+
+	if m.AShift != 9 || m.MetaslabArray != 37 || len(m.Children()) != 2 {
+		t.Errorf("mirror vdev is %+v", m)
+	}
+}
@@ -17,33 +17,60 @@ import (
 	"encoding/binary"
 	"fmt"
 	"io"
-	"log"
-
-	// "github.com/pierrec/lz4"
-	lz4 "github.com/bkaradzic/go-lz4"
 )
 
+//	64	56	48	40	32	24	16	8	0
+//	+-------+-------+-------+-------+-------+-------+-------+-------+
+//	|		vdev1		| GRID  |	  ASIZE		|
+//	+-------+-------+-------+-------+-------+-------+-------+-------+
+//	|G|			 offset1				|
+//	+-------+-------+-------+-------+-------+-------+-------+-------+
+//
+// The first word is kept whole since the position of its fields in memory
+// depends on the byte order it was decoded in.
+
 type DVA struct {
-	VDEV   uint32 // id of vdev
-	Size   uint32 // first byte is GRID (whatever that means) and remaning 3 bytes are ASIZE (allocated size)
+	Word   uint64 // id of the top level vdev, GRID and ASIZE (allocated size)
 	Offset uint64 // first bit is G (whatever that is) and the remainder is the offset into the vdev
 }
 
-func (dva *DVA) ReadDnode(r io.Reader) (*DnodePhys, error) {
+// NewDVA returns the DVA of a block at byte offset of top level vdev vdev.
+func NewDVA(vdev uint32, offset uint64, gang bool) DVA {
+	dva := DVA{Word: uint64(vdev&0xffffff) << 32, Offset: offset >> 9}
+	if gang {
+		dva.Offset |= 1 << 63
+	}
+	return dva
+}
+
+// Vdev returns the id of the top level vdev the block is on.
+func (dva *DVA) Vdev() uint32 {
+	return uint32(dva.Word>>32) & 0xffffff
+}
+
+// ReadDnode decodes a dnode stored in byte order bo from r.
+func (dva *DVA) ReadDnode(r io.Reader, bo binary.ByteOrder) (*DnodePhys, error) {
 	dn := DnodePhys{}
 
-	if err := binary.Read(r, binary.LittleEndian, &dn); err != nil {
-		log.Fatal(err)
+	if err := binary.Read(r, bo, &dn); err != nil {
+		return nil, err
 	}
 
-	log.Printf("dn = %#v", dn)
 	return &dn, nil
 }
 
+// Asize returns the space allocated to the block in bytes including RAID-Z
+// parity and gang headers.
 func (dva *DVA) Asize() int {
-	return int(dva.Size) & 0x0ffffff
+	return int(dva.Word&0xffffff) << 9
 }
 
+// Block returns the byte offset of the block on a lone disk, counting the 4MiB
+// of labels and boot block that precede the allocatable space.
+//
+// Deprecated: Block ignores the layout of mirrors, RAID-Z and dRAID vdevs.  Use
+// VdevOffset for the offset within the top level vdev or Pool.ReadPhysical to
+// read the block.
 func (dva *DVA) Block() uint64 {
 	// ZFS talks about data in terms of 512byte blocks. the actual location is
 	// 4mb + (512 * offset) the shift gets rid of the G bit which is stored in
@@ -52,13 +79,20 @@ func (dva *DVA) Block() uint64 {
 	mask := uint64(1 << 63)
 	offs := dva.Offset &^ mask
 
-	log.Printf("mask: %064b", mask)
-	log.Printf("offs: %064b", dva.Offset)
-	log.Printf("valu: %064b", offs)
-	log.Printf("offs: %064b", offs<<9)
 	return (offs << 9) + 0x400000
 }
 
+// VdevOffset returns the byte offset of the block within the allocatable
+// space of its top level vdev.
+func (dva *DVA) VdevOffset() uint64 {
+	return (dva.Offset &^ (1 << 63)) << 9
+}
+
+// IsEmpty reports whether the DVA is unused.
+func (dva *DVA) IsEmpty() bool {
+	return dva.Word == 0 && dva.Offset == 0
+}
+
 func (dva *DVA) Gang() bool {
 	// From the "ZFS On Disk Format" document:
 	//
@@ -305,27 +339,55 @@ const (
 	CompressionGzip9                                //"ZIO_COMPRESS_GZIP_9",
 	CompressionLZE                                  // "ZIO_COMPRESS_ZLE",
 	CompressionLZ4                                  // "ZIO_COMPRESS_LZ4",
+	CompressionZSTD                                 // "ZIO_COMPRESS_ZSTD",
 	CompressionFunctions                            // "ZIO_COMPRESS_FUNCTIONS",
 )
 
+// Decompress decompresses src, a block compressed with zct, into dst which
+// must be the logical size of the block.
 func (zct ZfsCompressionType) Decompress(dst []byte, src []byte) (int, error) {
-	switch zct.String() {
-	case "ZIO_COMPRESS_INHERIT":
-		return 0, nil
-	case "ZIO_COMPRESS_ON":
-		return 0, nil
-	case "ZIO_COMPRESS_LZ4":
-		return func(dst []byte, src []byte) (int, error) {
-			d, err := lz4.Decode(dst, src)
-			if err != nil {
-				return 0, err
-			}
-			return len(d), nil
-		}(dst, src)
-	case "ZIO_COMPRESS_ZLE":
-		return 0, nil
+	switch zct {
+	case CompressionOff:
+		return copy(dst, src), nil
+	case CompressionLZ4:
+		return lz4Decompress(dst, src)
+	case CompressionLZJB:
+		return lzjbDecompress(dst, src)
+	case CompressionGzip1, CompressionGzip2, CompressionGzip3, CompressionGzip4, CompressionGzip5,
+		CompressionGzip6, CompressionGzip7, ComperssionGzip8, CompressionGzip9:
+		return gzipDecompress(dst, src)
+	case CompressionLZE:
+		return zleDecompress(dst, src)
+	case CompressionZSTD:
+		return zstdDecompress(dst, src)
+	default:
+		return 0, fmt.Errorf("decompressing %s blocks is not supported", zct)
+	}
+}
+
+// Compress compresses src with zct into dst and returns the number of bytes
+// used.  It fails if the result does not fit in dst; ZFS stores such blocks
+// uncompressed.  This is mostly useful for building device images.
+func (zct ZfsCompressionType) Compress(dst []byte, src []byte) (int, error) {
+	switch zct {
+	case CompressionOff:
+		if len(src) > len(dst) {
+			return 0, fmt.Errorf("%d bytes do not fit into %d", len(src), len(dst))
+		}
+		return copy(dst, src), nil
+	case CompressionLZ4:
+		return lz4Compress(dst, src)
+	case CompressionLZJB:
+		return lzjbCompress(dst, src)
+	case CompressionGzip1, CompressionGzip2, CompressionGzip3, CompressionGzip4, CompressionGzip5,
+		CompressionGzip6, CompressionGzip7, ComperssionGzip8, CompressionGzip9:
+		return gzipCompress(dst, src, gzipLevel(zct))
+	case CompressionLZE:
+		return zleCompress(dst, src)
+	case CompressionZSTD:
+		return zstdCompress(dst, src, ZstdLevelDefault)
 	default:
-		return func(dst []byte, src []byte) (int, error) { return copy(dst, src), nil }(dst, src)
+		return 0, fmt.Errorf("compressing %s blocks is not supported", zct)
 	}
 }
 
@@ -347,6 +409,7 @@ func (zct ZfsCompressionType) String() string {
 		"ZIO_COMPRESS_GZIP_9",
 		"ZIO_COMPRESS_ZLE",
 		"ZIO_COMPRESS_LZ4",
+		"ZIO_COMPRESS_ZSTD",
 		"ZIO_COMPRESS_FUNCTIONS",
 	}
 	if zct < 0 {
@@ -372,38 +435,61 @@ func (zct ZfsCompressionType) String() string {
 
 type BlockPointerProps uint64
 
-/*
-{
-	Level       uint8              // 1
-	Type        uint8              // 1
-	Checksum    uint8              // 1
-	Compression ZfsCompressionType // 1
-	PSize       uint16             // 2
-	LSize       uint16             // 2
-}
-*/
+//	64	56	48	40	32	24	16	8	0
+//	+-------+-------+-------+-------+-------+-------+-------+-------+
+//	|BDX|lvl| type	| cksum |E| comp|    PSIZE	|     LSIZE	|
+//	+-------+-------+-------+-------+-------+-------+-------+-------+
+//
+// Sizes are recorded in 512 byte sectors less one.  The compression field
+// was 5 bits wide until the bits above it were claimed for more compression
+// functions; 7 bits are read here which covers both.
 
-func (bpp BlockPointerProps) Level() int {
-	return int((uint64(bpp) &^ (1 << 63)) >> uint64(56))
+const (
+	bppSizeBits     = 16
+	bppSizeMask     = 1<<bppSizeBits - 1
+	bppCompressBits = 7
+	bppLevelBits    = 5
+)
 
+// Level returns the level of indirection of the block; 0 for data blocks.
+func (bpp BlockPointerProps) Level() int {
+	return int(bpp>>56) & (1<<bppLevelBits - 1)
 }
 
 func (bpp BlockPointerProps) Embedded() bool {
 	return (uint64(bpp>>39) & 0x01) == 1
 }
 
+// Dedup reports whether the block is in the dedup table.
+func (bpp BlockPointerProps) Dedup() bool {
+	return (uint64(bpp>>62) & 0x01) == 1
+}
+
+// Crypt reports whether the block is encrypted or authenticated or, for
+// indirect blocks, carries a MAC of the blocks below it.
+func (bpp BlockPointerProps) Crypt() bool {
+	return (uint64(bpp>>61) & 0x01) == 1
+}
+
 // Logical Size - size without compression (decompressed size)
 func (bpp BlockPointerProps) Lsize() int {
-	return int(uint8(bpp&0xff)+1) * 512
+	if bpp.Embedded() {
+		// embedded block pointers record sizes in bytes.
+		return int(bpp&(1<<25-1)) + 1
+	}
+	return (int(bpp&bppSizeMask) + 1) * 512
 }
 
 // Physical Size - size on disk
 func (bpp BlockPointerProps) Psize() int {
-	return int(uint8((bpp>>8)&0xff)+1) * 512
+	if bpp.Embedded() {
+		return int((bpp>>25)&0x7f) + 1
+	}
+	return (int(bpp>>bppSizeBits&bppSizeMask) + 1) * 512
 }
 
 func (bpp BlockPointerProps) Compression() ZfsCompressionType {
-	return ZfsCompressionType(uint8(bpp>>32) & 0x7f)
+	return ZfsCompressionType(uint8(bpp>>32) & (1<<bppCompressBits - 1))
 }
 func (bpp BlockPointerProps) CompressionString() string {
 	return bpp.Compression().String()
@@ -433,6 +519,7 @@ func (bpp BlockPointerProps) ChecksumString() string {
 		"ZIO_CHECKSUM_SHA512",
 		"ZIO_CHECKSUM_SKEIN",
 		"ZIO_CHECKSUM_EDONR",
+		"ZIO_CHECKSUM_BLAKE3",
 		"ZIO_CHECKSUM_FUNCTIONS",
 	}
 	c := int(bpp.Checksum())
@@ -449,6 +536,14 @@ func (bpp BlockPointerProps) Endian() string {
 	return []string{"BigEndian", "LittleEndian"}[(bpp >> 63)]
 }
 
+// ByteOrder returns the byte order of the data the block pointer refers to.
+func (bpp BlockPointerProps) ByteOrder() binary.ByteOrder {
+	if bpp>>63 == 1 {
+		return binary.LittleEndian
+	}
+	return binary.BigEndian
+}
+
 // struct uberblock {
 // 	/*   8 */	uint64_t	ub_magic;			/* UBERBLOCK_MAGIC		*/
 // 	/*   8 */ uint64_t	ub_version;		/* SPA_VERSION			*/
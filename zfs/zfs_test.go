@@ -40,9 +40,7 @@ func TestZFS(t *testing.T) {
 
 			t.Logf("ashift = %d", ashift)
 
-			ubs, err := fs.UberBlocks()
-			ubs = ubs
-			if err != nil {
+			if _, err := fs.UberBlocks(); err != nil {
 				t.Fatal(err)
 			}
 
@@ -54,8 +52,8 @@ func TestZFS(t *testing.T) {
 
 			for idx, vd := range ub.RootBP.Vdevs {
 				t.Logf("compression: %s", ub.RootBP.Props.Compression())
-				t.Logf("vdev %03d (%d): %#v, gang = %v, disk offset = %d", vd.VDEV, idx, vd, vd.Gang(), vd.Block())
-				t.Logf("    Asize: %d, Size: %d", vd.Asize(), vd.Size)
+				t.Logf("vdev %03d (%d): %#v, gang = %v, disk offset = %d", vd.Vdev(), idx, vd, vd.Gang(), vd.Block())
+				t.Logf("    Asize: %d, Word: %#x", vd.Asize(), vd.Word)
 			}
 
 			t.Logf("ROOTBP: %s", ub.RootBP)
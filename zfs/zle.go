@@ -0,0 +1,94 @@
+// Copyright 2018 Ayan George.
+// All rights reserved.  Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package zfs
+
+import "fmt"
+
+// ZLE (zero length encoding) only compresses runs of zeros.  Each run starts
+// with a length byte; values below zleN are followed by that many plus one
+// literal bytes and larger values stand for that many less zleN plus one
+// zeros.
+const zleN = 64
+
+// zleDecompress fills dst from src, the ZLE encoded form of dst.
+func zleDecompress(dst []byte, src []byte) (int, error) {
+	s, d := 0, 0
+	for s < len(src) && d < len(dst) {
+		n := 1 + int(src[s])
+		s++
+
+		if n <= zleN {
+			if s+n > len(src) || d+n > len(dst) {
+				return d, fmt.Errorf("zle run of %d bytes at input byte %d overruns the block", n, s-1)
+			}
+			copy(dst[d:], src[s:s+n])
+			s, d = s+n, d+n
+			continue
+		}
+
+		n -= zleN
+		if d+n > len(dst) {
+			return d, fmt.Errorf("zle run of %d zeros at input byte %d overruns the block", n, s-1)
+		}
+		for i := d; i < d+n; i++ {
+			dst[i] = 0
+		}
+		d += n
+	}
+
+	if d != len(dst) {
+		return d, fmt.Errorf("zle input holds %d of %d bytes", d, len(dst))
+	}
+
+	return d, nil
+}
+
+// zleCompress encodes src into dst and returns the number of bytes used.  It
+// fails if the encoded form does not fit in dst.
+func zleCompress(dst []byte, src []byte) (int, error) {
+	s, d := 0, 0
+	for s < len(src) && d < len(dst)-1 {
+		first := s
+		length := d
+		d++
+
+		if src[s] == 0 {
+			last := s + 256 - zleN
+			if last > len(src) {
+				last = len(src)
+			}
+			for s < last && src[s] == 0 {
+				s++
+			}
+			dst[length] = byte(s - first - 1 + zleN)
+			continue
+		}
+
+		if len(dst)-d < zleN {
+			break
+		}
+
+		// literal runs end at a pair of zeros.
+		last := s + zleN
+		if last > len(src) {
+			last = len(src)
+		}
+		for s < last-1 && src[s]|src[s+1] != 0 {
+			dst[d] = src[s]
+			s, d = s+1, d+1
+		}
+		if src[s] != 0 {
+			dst[d] = src[s]
+			s, d = s+1, d+1
+		}
+		dst[length] = byte(s - first - 1)
+	}
+
+	if s != len(src) {
+		return 0, fmt.Errorf("%d bytes do not compress into %d", len(src), len(dst))
+	}
+
+	return d, nil
+}
@@ -0,0 +1,229 @@
+// Copyright 2018 Ayan George.
+// All rights reserved.  Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package zfs_test
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/ayang64/ztool/zfs"
+)
+
+// zleBlock returns a synthetic, sparse 1K block shaped like metadata.  It
+// was built by hand rather than read from a pool.
+func zleBlock() []byte {
+	b := make([]byte, 1024)
+	b[0], b[1], b[2] = 0x13, 7, 1
+	for i := 0; i < 8; i++ {
+		b[8+i] = byte(i + 1)
+	}
+	for i := 0; i < 100; i++ {
+		b[64+i] = byte(i + 1)
+	}
+	b[600], b[602] = 0xff, 0xee
+	return b
+}
+
+// zleEncoded is the synthetic zleBlock as encoded by zfs_zle_compress_buf()
+// in ZFS.
+var zleEncoded = []byte{
+	0x02, 0x13, 0x07, 0x01, 0x44, 0x07, 0x01, 0x02, 0x03, 0x04, 0x05, 0x06,
+	0x07, 0x08, 0x6f, 0x3f, 0x01, 0x02, 0x03, 0x04, 0x05, 0x06, 0x07, 0x08,
+	0x09, 0x0a, 0x0b, 0x0c, 0x0d, 0x0e, 0x0f, 0x10, 0x11, 0x12, 0x13, 0x14,
+	0x15, 0x16, 0x17, 0x18, 0x19, 0x1a, 0x1b, 0x1c, 0x1d, 0x1e, 0x1f, 0x20,
+	0x21, 0x22, 0x23, 0x24, 0x25, 0x26, 0x27, 0x28, 0x29, 0x2a, 0x2b, 0x2c,
+	0x2d, 0x2e, 0x2f, 0x30, 0x31, 0x32, 0x33, 0x34, 0x35, 0x36, 0x37, 0x38,
+	0x39, 0x3a, 0x3b, 0x3c, 0x3d, 0x3e, 0x3f, 0x40, 0x23, 0x41, 0x42, 0x43,
+	0x44, 0x45, 0x46, 0x47, 0x48, 0x49, 0x4a, 0x4b, 0x4c, 0x4d, 0x4e, 0x4f,
+	0x50, 0x51, 0x52, 0x53, 0x54, 0x55, 0x56, 0x57, 0x58, 0x59, 0x5a, 0x5b,
+	0x5c, 0x5d, 0x5e, 0x5f, 0x60, 0x61, 0x62, 0x63, 0x64, 0xff, 0xff, 0x73,
+	0x02, 0xff, 0x00, 0xee, 0xff, 0xff, 0x64,
+}
+
+func TestZLE(t *testing.T) {
+	block := zleBlock()
+
+	tests := map[string]struct {
+		Data    []byte
+		Encoded []byte // expected encoding, if known.
+	}{
+		"metadata":  {Data: block, Encoded: zleEncoded},
+		"zeros":     {Data: make([]byte, 4096)},
+		"literals":  {Data: bytes.Repeat([]byte{1, 2, 3, 0}, 100)},
+		"text":      {Data: compressibleData(2000)},
+		"one zero":  {Data: []byte{0}, Encoded: []byte{0x40}},
+		"one byte":  {Data: []byte{9}, Encoded: []byte{0x00, 0x09}},
+		"trailing":  {Data: append([]byte{5}, make([]byte, 200)...)},
+		"no data":   {Data: []byte{}, Encoded: []byte{}},
+		"long runs": {Data: append(make([]byte, 1000), 1)},
+	}
+
+	for name, test := range tests {
+		test := test
+		t.Run(name, func(t *testing.T) {
+			buf := make([]byte, len(test.Data)+128)
+			n, err := zfs.CompressionLZE.Compress(buf, test.Data)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if test.Encoded != nil && !bytes.Equal(buf[:n], test.Encoded) {
+				t.Fatalf("encoded as %#v; expected %#v", buf[:n], test.Encoded)
+			}
+
+			dst := make([]byte, len(test.Data))
+			if _, err := zfs.CompressionLZE.Decompress(dst, buf[:n]); err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(dst, test.Data) {
+				t.Fatal("data changed in a round trip")
+			}
+		})
+	}
+}
+
+// TestZLEPoolConfig round-trips real pool data: the packed configuration in
+// test-data/zpool.cache, zero filled to the 16K block that holds a pool's
+// config object.  test-data/zpool.cache.zle is that block as encoded by
+// zfs_zle_compress_buf() from OpenZFS's zle.c, not a block read from disk;
+// test-data/zle-encode/encode.c writes it.
+func TestZLEPoolConfig(t *testing.T) {
+	config, err := os.ReadFile("../test-data/zpool.cache")
+	if err != nil {
+		t.Fatal(err)
+	}
+	encoded, err := os.ReadFile("../test-data/zpool.cache.zle")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	block := make([]byte, 16<<10)
+	copy(block, config)
+
+	dst := make([]byte, len(block))
+	if _, err := zfs.CompressionLZE.Decompress(dst, encoded); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(dst, block) {
+		t.Fatal("decompressed block does not match zpool.cache")
+	}
+
+	buf := make([]byte, len(block))
+	n, err := zfs.CompressionLZE.Compress(buf, block)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(buf[:n], encoded) {
+		t.Fatalf("encoded in %d bytes that differ from the %d zfs encoded", n, len(encoded))
+	}
+}
+
+// TestZLECaptured checks blocks dumped from pools with compression=zle against
+// their block pointers and the logical contents zdb decoded.  Each line of
+// test-data/zle/blocks names a block in test-data/zle dumped with
+//
+//	zdb -R pool vdev:offset:psize:r > test-data/zle/name
+//	zdb -R pool vdev:offset:lsize/psize:dr > test-data/zle/name.d
+//
+// followed by the lsize and the fletcher4 checksum zdb -ddddd shows for its
+// little endian block pointer:
+//
+//	name lsize cksum=a:b:c:d
+func TestZLECaptured(t *testing.T) {
+	manifest, err := os.ReadFile("../test-data/zle/blocks")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, line := range strings.Split(strings.TrimSpace(string(manifest)), "\n") {
+		f := strings.Fields(line)
+		if len(f) != 3 || !strings.HasPrefix(f[2], "cksum=") {
+			t.Fatalf("bad manifest line %q", line)
+		}
+
+		t.Run(f[0], func(t *testing.T) {
+			raw, err := os.ReadFile(filepath.Join("../test-data/zle", f[0]))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			lsize, err := strconv.Atoi(f[1])
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			var bp zfs.BlockPointer
+			words := strings.Split(strings.TrimPrefix(f[2], "cksum="), ":")
+			if len(words) != len(bp.ChecksumList) {
+				t.Fatalf("checksum %q does not have %d words", f[2], len(bp.ChecksumList))
+			}
+			for i := range words {
+				if bp.ChecksumList[i], err = strconv.ParseUint(words[i], 16, 64); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			l, p := uint64(lsize/512-1), uint64(len(raw)/512-1)
+			bp.Props = zfs.BlockPointerProps(1<<63 | uint64(zfs.ChecksumFletcher4)<<40 | uint64(zfs.CompressionLZE)<<32 | p<<16 | l)
+
+			if err := zfs.VerifyBlockChecksum(&bp, raw); err != nil {
+				t.Fatal(err)
+			}
+
+			logical, err := os.ReadFile(filepath.Join("../test-data/zle", f[0]+".d"))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			dst := make([]byte, lsize)
+			if _, err := zfs.CompressionLZE.Decompress(dst, raw); err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(dst, logical) {
+				t.Fatal("decompressed block does not match the one zdb decoded")
+			}
+
+			// the encoding is zero filled to the physical size.
+			buf := make([]byte, len(raw))
+			n, err := zfs.CompressionLZE.Compress(buf, logical)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(buf, raw) {
+				t.Fatalf("encoded in %d bytes that differ from the %d zfs wrote", n, len(raw))
+			}
+		})
+	}
+}
+
+func TestZLEDecompressErrors(t *testing.T) {
+	tests := map[string]struct {
+		Src  []byte
+		Size int
+	}{
+		"short input":     {Src: zleEncoded[:60], Size: 1024},
+		"literal overrun": {Src: []byte{0x03, 1, 2}, Size: 8},
+		"zeros overrun":   {Src: []byte{0x7f}, Size: 8},
+		"block too big":   {Src: zleEncoded, Size: 1025},
+	}
+
+	for name, test := range tests {
+		test := test
+		t.Run(name, func(t *testing.T) {
+			dst := make([]byte, test.Size)
+			if _, err := zfs.CompressionLZE.Decompress(dst, test.Src); err == nil {
+				t.Fatalf("decompressed %v without error", dst)
+			}
+		})
+	}
+
+	// blocks that do not shrink are stored uncompressed.
+	if n, err := zfs.CompressionLZE.Compress(make([]byte, 100), compressibleData(100)); err == nil {
+		t.Fatalf("encoded text in %d bytes", n)
+	}
+}
@@ -0,0 +1,175 @@
+// Copyright 2018 Ayan George.
+// All rights reserved.  Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package zfs
+
+import (
+	"encoding/binary"
+	"fmt"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// typedef struct zfs_zstd_header {
+// 	uint32_t c_len;
+// 	uint32_t raw_version_level;
+// 	char data[];
+// } zfs_zstdhdr_t;
+//
+// Both fields are big endian.  The data is a zstd frame without its magic
+// number followed by padding up to the physical size of the block.
+
+const (
+	// ZstdHeaderSize is the size of the header in front of zstd compressed
+	// blocks.
+	ZstdHeaderSize = 8
+
+	// ZstdLevelDefault is the level compression=zstd uses.
+	ZstdLevelDefault = 3
+
+	// zstdVersion is the version of zstd recorded in blocks we compress;
+	// the zstd shipped with OpenZFS, 1.5.7.
+	zstdVersion = 10507
+
+	zstdMagic = 0xfd2fb528
+
+	// zstdMaxMemory bounds what the decoder allocates for a block; ZFS
+	// blocks are at most 16M.
+	zstdMaxMemory = 16 << 20
+)
+
+// zstdFastLevels holds the zstd levels of the ZFS zstd-fast-N levels in the
+// order they are numbered from 103; 102 only marks where they begin.
+var zstdFastLevels = []int{
+	-1, -2, -3, -4, -5, -6, -7, -8, -9, -10,
+	-20, -30, -40, -50, -60, -70, -80, -90, -100,
+	-500, -1000,
+}
+
+// ZstdHeader is the header ZFS puts in front of zstd compressed blocks.
+type ZstdHeader struct {
+	CompressedSize uint32 // size of the zstd frame that follows the header.
+	Level          uint8  // ZFS compression level; 1-19 or 103 and up for zstd-fast.
+	Version        uint32 // version of zstd the block was compressed with; 10405 is 1.4.5.
+}
+
+// ReadZstdHeader decodes the header at the front of src, a zstd compressed
+// block.
+func ReadZstdHeader(src []byte) (*ZstdHeader, error) {
+	if len(src) < ZstdHeaderSize {
+		return nil, fmt.Errorf("zstd block of %d bytes is too short for its header", len(src))
+	}
+
+	h := ZstdHeader{CompressedSize: binary.BigEndian.Uint32(src)}
+
+	// early releases stored the version and level in the wrong order or
+	// byte order.  Versions fit in 24 bits, so the position of the first
+	// zero byte tells which layout was used.
+	raw := binary.BigEndian.Uint32(src[4:])
+	shift := 0
+	for ; shift < 4; shift++ {
+		if raw>>(8*shift)&0xff == 0 {
+			break
+		}
+	}
+
+	swapped := raw>>24 | raw>>8&0xff00 | raw<<8&0xff0000 | raw<<24
+	switch shift {
+	case 0:
+		h.Level, h.Version = uint8(raw>>24), swapped>>8
+	case 1:
+		h.Level, h.Version = uint8(raw), swapped&0xffffff
+	case 2:
+		h.Level, h.Version = uint8(raw>>24), raw&0xffffff
+	case 3:
+		h.Level, h.Version = uint8(raw), raw>>8
+	}
+
+	if _, err := zstdLevel(h.Level); err != nil {
+		return nil, err
+	}
+
+	if int(h.CompressedSize) > len(src)-ZstdHeaderSize {
+		return nil, fmt.Errorf("zstd frame of %d bytes overruns the %d byte block", h.CompressedSize, len(src))
+	}
+
+	return &h, nil
+}
+
+// zstdLevel returns the zstd compression level of the ZFS compression level
+// level.
+func zstdLevel(level uint8) (int, error) {
+	switch {
+	case level >= 1 && level <= 19:
+		return int(level), nil
+	case level >= 103 && int(level) < 103+len(zstdFastLevels):
+		return zstdFastLevels[level-103], nil
+	default:
+		// blocks with a bad level are most likely damaged.
+		return 0, fmt.Errorf("invalid zstd level %d", level)
+	}
+}
+
+// zstdDecoder decodes every zstd block; it is safe for concurrent use.  A
+// damaged frame header cannot make it allocate more than a block's worth of
+// memory.
+var zstdDecoder, zstdDecoderErr = zstd.NewReader(nil,
+	zstd.WithDecoderConcurrency(0),
+	zstd.WithDecoderMaxMemory(zstdMaxMemory),
+	zstd.WithDecodeAllCapLimit(true))
+
+// zstdDecompress fills dst from src, a zstd compressed block that must hold
+// exactly len(dst) bytes.
+func zstdDecompress(dst []byte, src []byte) (int, error) {
+	if zstdDecoderErr != nil {
+		return 0, zstdDecoderErr
+	}
+
+	h, err := ReadZstdHeader(src)
+	if err != nil {
+		return 0, err
+	}
+
+	// the decoder expects the magic number ZFS leaves out.
+	frame := make([]byte, 4+h.CompressedSize)
+	binary.LittleEndian.PutUint32(frame, zstdMagic)
+	copy(frame[4:], src[ZstdHeaderSize:])
+
+	// the cap of dst limits the decoder to the size of the block.
+	out, err := zstdDecoder.DecodeAll(frame, dst[:0:len(dst)])
+	if err != nil {
+		return 0, err
+	}
+	if len(out) != len(dst) {
+		return 0, fmt.Errorf("zstd frame holds %d bytes; expected %d", len(out), len(dst))
+	}
+
+	return len(out), nil
+}
+
+// zstdCompress compresses src into dst at the ZFS compression level level and
+// prepends the ZFS header.  It fails if the result does not fit in dst.
+func zstdCompress(dst []byte, src []byte, level uint8) (int, error) {
+	zl, err := zstdLevel(level)
+	if err != nil {
+		return 0, err
+	}
+
+	enc, err := zstd.NewWriter(nil, zstd.WithEncoderLevel(zstd.EncoderLevelFromZstd(zl)), zstd.WithEncoderCRC(false))
+	if err != nil {
+		return 0, err
+	}
+	defer enc.Close()
+
+	frame := enc.EncodeAll(src, nil)[4:]
+	if ZstdHeaderSize+len(frame) > len(dst) {
+		return 0, fmt.Errorf("%d bytes do not compress into %d", len(src), len(dst))
+	}
+
+	binary.BigEndian.PutUint32(dst, uint32(len(frame)))
+	binary.BigEndian.PutUint32(dst[4:], uint32(level)<<24|zstdVersion)
+	copy(dst[ZstdHeaderSize:], frame)
+
+	return ZstdHeaderSize + len(frame), nil
+}
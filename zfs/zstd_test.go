@@ -0,0 +1,131 @@
+// Copyright 2018 Ayan George.
+// All rights reserved.  Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package zfs_test
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+
+	"github.com/ayang64/ztool/zfs"
+)
+
+// zstdFrame is "zstd compressed zfs block\n" repeated to 1K as compressed by
+// zstd 1.5.6 at level 3, less the magic number.
+var zstdFrame = []byte{
+	0x60, 0x00, 0x03, 0x15, 0x01, 0x00, 0xd0, 0x7a, 0x73, 0x74, 0x64, 0x20,
+	0x63, 0x6f, 0x6d, 0x70, 0x72, 0x65, 0x73, 0x73, 0x65, 0x64, 0x20, 0x7a,
+	0x66, 0x73, 0x20, 0x62, 0x6c, 0x6f, 0x63, 0x6b, 0x0a, 0x01, 0x00, 0x8e,
+	0xef, 0x6a, 0x8e, 0x01,
+}
+
+func zstdData() []byte {
+	return bytes.Repeat([]byte("zstd compressed zfs block\n"), 40)[:1024]
+}
+
+// zstdBlock returns zstdFrame behind a ZFS header whose level and version
+// field is raw, padded to a sector.
+func zstdBlock(raw uint32) []byte {
+	b := make([]byte, 512)
+	binary.BigEndian.PutUint32(b, uint32(len(zstdFrame)))
+	binary.BigEndian.PutUint32(b[4:], raw)
+	copy(b[zfs.ZstdHeaderSize:], zstdFrame)
+	return b
+}
+
+func TestReadZstdHeader(t *testing.T) {
+	expected := zfs.ZstdHeader{CompressedSize: uint32(len(zstdFrame)), Level: 3, Version: 10506}
+
+	tests := map[string]struct {
+		Raw      uint32
+		Expected *zfs.ZstdHeader
+	}{
+		"level and version":         {Raw: 0x0300290a, Expected: &expected},
+		"version and level":         {Raw: 0x00290a03, Expected: &expected},
+		"swapped level and version": {Raw: 0x0a290003, Expected: &expected},
+		"swapped version and level": {Raw: 0x030a2900, Expected: &expected},
+		"zstd-fast-1000": {
+			Raw:      123<<24 | 10506,
+			Expected: &zfs.ZstdHeader{CompressedSize: expected.CompressedSize, Level: 123, Version: 10506},
+		},
+		"zstd-fast-1": {
+			Raw:      103<<24 | 10506,
+			Expected: &zfs.ZstdHeader{CompressedSize: expected.CompressedSize, Level: 103, Version: 10506},
+		},
+		"level 0":          {Raw: 0x0000290a},
+		"reserved level":   {Raw: 101<<24 | 10506},
+		"zstd-fast marker": {Raw: 102<<24 | 10506},
+		"past zstd-fast":   {Raw: 124<<24 | 10506},
+		"no layout found":  {Raw: 0x01010101},
+	}
+
+	for name, test := range tests {
+		test := test
+		t.Run(name, func(t *testing.T) {
+			h, err := zfs.ReadZstdHeader(zstdBlock(test.Raw))
+			if test.Expected == nil {
+				if err == nil {
+					t.Fatalf("decoded %+v without error", *h)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if *h != *test.Expected {
+				t.Fatalf("decoded %+v; expected %+v", *h, *test.Expected)
+			}
+		})
+	}
+
+	if h, err := zfs.ReadZstdHeader(zstdBlock(0x0300290a)[:20]); err == nil {
+		t.Fatalf("decoded %+v from a truncated block", *h)
+	}
+}
+
+func TestZstdDecompress(t *testing.T) {
+	// zstd frames written by ZFS carry no checksum of their own.
+	truncated := zstdBlock(0x0300290a)
+	binary.BigEndian.PutUint32(truncated, 20)
+
+	// a damaged frame header claiming 1T of content must not be allocated.
+	huge := zstdBlock(0x0300290a)
+	frame := append([]byte{0xe0, 0, 0, 0, 0, 0, 1, 0, 0}, zstdFrame[3:]...)
+	binary.BigEndian.PutUint32(huge, uint32(len(frame)))
+	copy(huge[zfs.ZstdHeaderSize:], frame)
+
+	tests := map[string]struct {
+		Src  []byte
+		Size int
+		Fail bool
+	}{
+		"block":     {Src: zstdBlock(0x0300290a), Size: 1024},
+		"too long":  {Src: zstdBlock(0x0300290a), Size: 1025, Fail: true},
+		"too short": {Src: zstdBlock(0x0300290a), Size: 1023, Fail: true},
+		"truncated": {Src: truncated, Size: 1024, Fail: true},
+		"huge":      {Src: huge, Size: 1024, Fail: true},
+		"no header": {Src: zstdFrame, Size: 1024, Fail: true},
+	}
+
+	for name, test := range tests {
+		test := test
+		t.Run(name, func(t *testing.T) {
+			dst := make([]byte, test.Size)
+			_, err := zfs.CompressionZSTD.Decompress(dst, test.Src)
+			if test.Fail {
+				if err == nil {
+					t.Fatal("decompressed without error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(dst, zstdData()) {
+				t.Fatalf("decompressed %q", dst)
+			}
+		})
+	}
+}